@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	exportv1 "github.com/MuxiKeStack/be-evaluation/api/export/v1"
+	"github.com/MuxiKeStack/be-evaluation/importer"
+	"github.com/MuxiKeStack/be-evaluation/ioc"
+	"github.com/MuxiKeStack/be-evaluation/pkg/grpcx"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	kgrpc "github.com/go-kratos/kratos/v2/transport/grpc"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 管理员命令，指定了就执行完退出，不启动服务
+var (
+	mergeSourceCourseId = pflag.Int64("merge-source", 0, "合并课程：源课程id，课评会挪到目标课程")
+	mergeTargetCourseId = pflag.Int64("merge-target", 0, "合并课程：目标课程id")
+	importFile          = pflag.String("import-file", "", "导入历史课评：.csv 按 CSV 解析，其它按 JSON Lines 解析")
+	importUserMap       = pflag.String("import-user-map", "", "导入历史课评：用户id映射文件，每行 旧id,新id，不指定则不转换")
+	importCourseMap     = pflag.String("import-course-map", "", "导入历史课评：课程id映射文件，每行 旧id,新id，不指定则不转换")
+	exportFormat        = pflag.String("export-format", "", "导出课评：csv 或者 jsonl，通过正在运行的服务的管理端口导出，只导出公开的课评，令牌从环境变量 "+ioc.AdminTokenEnv+" 读")
+	exportAddr          = pflag.String("export-addr", "", "导出课评：管理端口地址，默认连本机的 grpc.admin.addr")
+	exportCourseId      = pflag.Int64("export-course", 0, "导出课评：课程id，不指定则不限")
+	exportProperty      = pflag.Int32("export-property", 0, "导出课评：课程性质，不指定则不限")
+	exportStart         = pflag.String("export-start", "", "导出课评：发布时间下限（含），格式 2006-01-02 或 RFC3339")
+	exportEnd           = pflag.String("export-end", "", "导出课评：发布时间上限（不含），格式同上")
+	exportOut           = pflag.String("export-out", "", "导出课评：输出文件，默认标准输出")
+	validateBase        = pflag.String("validate-base", "", "迁移校验：以哪边为准，src 或者 dst")
+	validateRepair      = pflag.Bool("validate-repair", false, "迁移校验：把另一边修成和基准一致")
+	deadLetters         = pflag.String("dead-letters", "", "待校验课评的死信：list 列出，requeue 全部放回去重新校验")
+)
+
+// runAdmin 有管理员命令就执行，返回是否执行了
+func runAdmin() bool {
+	switch {
+	case *mergeSourceCourseId != 0 || *mergeTargetCourseId != 0:
+		runMergeCourse()
+	case *importFile != "":
+		runImport()
+	case *exportFormat != "":
+		runExport()
+	case *validateBase != "":
+		runValidate()
+	case *deadLetters != "":
+		runDeadLetters()
+	default:
+		return false
+	}
+	return true
+}
+
+func runMergeCourse() {
+	svc := InitCourseMergeService()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	err := svc.Merge(ctx, *mergeSourceCourseId, *mergeTargetCourseId)
+	if err != nil {
+		// 进度已经记下来了，重新执行同样的命令会接着合并
+		log.Fatalf("合并课程失败: %v", err)
+	}
+	log.Printf("合并课程完成: %d -> %d", *mergeSourceCourseId, *mergeTargetCourseId)
+}
+
+func runImport() {
+	userMap, err := importer.LoadIdMapping(*importUserMap)
+	if err != nil {
+		log.Fatalf("读取用户id映射失败: %v", err)
+	}
+	courseMap, err := importer.LoadIdMapping(*importCourseMap)
+	if err != nil {
+		log.Fatalf("读取课程id映射失败: %v", err)
+	}
+	f, err := os.Open(*importFile)
+	if err != nil {
+		log.Fatalf("打开导入文件失败: %v", err)
+	}
+	defer f.Close()
+	var r importer.Reader
+	if strings.EqualFold(filepath.Ext(*importFile), ".csv") {
+		r, err = importer.NewCSVReader(f)
+		if err != nil {
+			log.Fatalf("解析导入文件失败: %v", err)
+		}
+	} else {
+		r = importer.NewJSONLReader(f)
+	}
+	imp := importer.NewImporter(InitEvaluationRepository(), userMap, courseMap, ioc.InitLogger())
+	summary, err := imp.Run(context.Background(), r)
+	log.Printf("导入课评结束: %s", summary)
+	if err != nil {
+		log.Fatalf("导入课评中断: %v", err)
+	}
+}
+
+func runExport() {
+	startTime, err := parseExportTime(*exportStart)
+	if err != nil {
+		log.Fatalf("不合法的开始时间: %v", err)
+	}
+	endTime, err := parseExportTime(*exportEnd)
+	if err != nil {
+		log.Fatalf("不合法的结束时间: %v", err)
+	}
+	addr := *exportAddr
+	if addr == "" {
+		addr = viper.GetString("grpc.admin.addr")
+		if strings.HasPrefix(addr, ":") {
+			addr = "127.0.0.1" + addr
+		}
+	}
+	cc, err := kgrpc.DialInsecure(context.Background(), kgrpc.WithEndpoint(addr))
+	if err != nil {
+		log.Fatalf("连接服务失败: %v", err)
+	}
+	defer cc.Close()
+	out := os.Stdout
+	if *exportOut != "" {
+		out, err = os.Create(*exportOut)
+		if err != nil {
+			log.Fatalf("创建输出文件失败: %v", err)
+		}
+		defer out.Close()
+	}
+	ctx := grpcx.WithToken(context.Background(), os.Getenv(ioc.AdminTokenEnv))
+	stream, err := exportv1.NewExportServiceClient(cc).Export(ctx, &exportv1.ExportRequest{
+		CourseId:  *exportCourseId,
+		Property:  *exportProperty,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Format:    *exportFormat,
+	})
+	if err != nil {
+		log.Fatalf("导出课评失败: %v", err)
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("导出课评中断: %v", err)
+		}
+		if _, err = out.Write(chunk); err != nil {
+			log.Fatalf("写入输出文件失败: %v", err)
+		}
+	}
+}
+
+// parseExportTime 返回毫秒时间戳，空字符串表示不限
+func parseExportTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, s)
+	}
+	return t.UnixMilli(), err
+}
+
+func runValidate() {
+	l := ioc.InitLogger()
+	lm := ioc.InitLimiter(ioc.InitRedis())
+	src, dst := ioc.InitDB(l, lm), ioc.InitDstDB(l, lm)
+	if dst == nil {
+		log.Fatalf("没有配置迁移目标库 migrator.dst.dsn")
+	}
+	var base, target *gorm.DB
+	switch *validateBase {
+	case "src":
+		base, target = src, dst
+	case "dst":
+		base, target = dst, src
+	default:
+		log.Fatalf("validate-base 只能是 src 或者 dst")
+	}
+	v := dao.NewEvaluationValidator(base, target, *validateRepair, func(diff dao.EvaluationDiff) {
+		log.Printf("课评不一致: %s %d", diff.Type, diff.EvaluationId)
+	})
+	res, err := v.Validate(context.Background())
+	log.Printf("迁移校验结束: 检查 %d 条，不一致 %d 条，修复 %d 条", res.Checked, res.Diffs, res.Repaired)
+	if err != nil {
+		log.Fatalf("迁移校验中断: %v", err)
+	}
+}
+
+func runDeadLetters() {
+	if *deadLetters != "list" && *deadLetters != "requeue" {
+		log.Fatalf("dead-letters 只能是 list 或者 requeue")
+	}
+	l := ioc.InitLogger()
+	lm := ioc.InitLimiter(ioc.InitRedis())
+	// 不会新建待校验课评，用不到id生成器
+	repo := repository.NewPendingEvaluationRepository(dao.NewGORMPendingEvaluationDAO(ioc.InitDB(l, lm), nil))
+	ctx := context.Background()
+	var (
+		afterId int64
+		total   int64
+	)
+	for {
+		ps, err := repo.FindDead(ctx, afterId, 100)
+		if err != nil {
+			log.Fatalf("查询死信失败: %v", err)
+		}
+		if len(ps) == 0 {
+			break
+		}
+		ids := make([]int64, 0, len(ps))
+		for _, p := range ps {
+			ids = append(ids, p.Id)
+			if *deadLetters == "list" {
+				log.Printf("死信 %d: 课评 %d 用户 %d 课程 %d 重试 %d 次，%s 转入，最后的错误: %s",
+					p.Id, p.Evaluation.Id, p.Evaluation.PublisherId, p.Evaluation.CourseId, p.Attempts,
+					p.DeadTime.Format(time.DateTime), p.LastError)
+			}
+		}
+		afterId = ids[len(ids)-1]
+		if *deadLetters == "list" {
+			total += int64(len(ids))
+			continue
+		}
+		n, err := repo.Requeue(ctx, ids)
+		if err != nil {
+			log.Fatalf("放回死信失败: %v", err)
+		}
+		total += n
+	}
+	if *deadLetters == "list" {
+		log.Printf("共 %d 条死信", total)
+		return
+	}
+	log.Printf("放回死信 %d 条，会在下一轮校验", total)
+}
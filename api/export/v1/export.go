@@ -0,0 +1,124 @@
+// Package exportv1 课评导出的 gRPC 接口。
+// be-api 里面没有这个服务，这里手写 ServiceDesc，请求和响应都用 BytesValue 承载，
+// 请求体是 JSON 编码的 ExportRequest，响应是一段一段的 CSV 或者 JSON Lines
+package exportv1
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	ServiceName      = "evaluation.export.v1.ExportService"
+	ExportFullMethod = "/" + ServiceName + "/Export"
+	FormatCSV        = "csv"
+	FormatJSONLines  = "jsonl"
+)
+
+type ExportRequest struct {
+	CourseId int64 `json:"course_id"`
+	Property int32 `json:"property"`
+	// 只能是公开，私密和折叠的课评不能导出
+	Status int32 `json:"status"`
+	// 按发布时间过滤，毫秒时间戳，左闭右开，0表示不限
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+	// csv 或者 jsonl
+	Format string `json:"format"`
+}
+
+type ExportServiceServer interface {
+	Export(req *ExportRequest, stream ExportService_ExportServer) error
+}
+
+type ExportService_ExportServer interface {
+	Send(chunk []byte) error
+	Context() context.Context
+}
+
+type exportServiceExportServer struct {
+	grpc.ServerStream
+}
+
+func (x *exportServiceExportServer) Send(chunk []byte) error {
+	return x.ServerStream.SendMsg(wrapperspb.Bytes(chunk))
+}
+
+func RegisterExportServiceServer(s grpc.ServiceRegistrar, srv ExportServiceServer) {
+	s.RegisterService(&ExportService_ServiceDesc, srv)
+}
+
+func exportHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(wrapperspb.BytesValue)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	var req ExportRequest
+	if err := json.Unmarshal(in.GetValue(), &req); err != nil {
+		return err
+	}
+	return srv.(ExportServiceServer).Export(&req, &exportServiceExportServer{stream})
+}
+
+var ExportService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ExportServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Export",
+			Handler:       exportHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "evaluation/export/v1/export.proto",
+}
+
+type ExportServiceClient interface {
+	Export(ctx context.Context, req *ExportRequest, opts ...grpc.CallOption) (ExportService_ExportClient, error)
+}
+
+type ExportService_ExportClient interface {
+	// Recv 读完返回 io.EOF
+	Recv() ([]byte, error)
+}
+
+type exportServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExportServiceClient(cc grpc.ClientConnInterface) ExportServiceClient {
+	return &exportServiceClient{cc: cc}
+}
+
+func (c *exportServiceClient) Export(ctx context.Context, req *ExportRequest, opts ...grpc.CallOption) (ExportService_ExportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ExportService_ServiceDesc.Streams[0], ExportFullMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SendMsg(wrapperspb.Bytes(data)); err != nil {
+		return nil, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &exportServiceExportClient{stream}, nil
+}
+
+type exportServiceExportClient struct {
+	grpc.ClientStream
+}
+
+func (x *exportServiceExportClient) Recv() ([]byte, error) {
+	m := new(wrapperspb.BytesValue)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m.GetValue(), nil
+}
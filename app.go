@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/events"
+	"github.com/MuxiKeStack/be-evaluation/pkg/grpcx"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/robfig/cron/v3"
+)
+
+type App struct {
+	server    grpcx.Server
+	cron      *cron.Cron
+	consumers []events.Consumer
+	l         logger.Logger
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"google.golang.org/grpc"
+)
+
+// CachedCourseServiceClient 在课程服务前面加一层缓存，只缓存课评服务用到的课程详情和选课关系，
+// 其它方法直接调用课程服务。缓存出错的时候照常调用课程服务
+type CachedCourseServiceClient struct {
+	coursev1.CourseServiceClient
+	cache cache.CourseCache
+	l     logger.Logger
+}
+
+func NewCachedCourseServiceClient(client coursev1.CourseServiceClient, cache cache.CourseCache,
+	l logger.Logger) *CachedCourseServiceClient {
+	return &CachedCourseServiceClient{CourseServiceClient: client, cache: cache, l: l}
+}
+
+func (c *CachedCourseServiceClient) GetDetailById(ctx context.Context, in *coursev1.GetDetailByIdRequest,
+	opts ...grpc.CallOption) (*coursev1.GetDetailByIdResponse, error) {
+	course, err := c.cache.GetCourse(ctx, in.GetCourseId())
+	if err == nil {
+		return &coursev1.GetDetailByIdResponse{Course: course}, nil
+	}
+	if err != cache.ErrKeyNotExists {
+		c.l.Error("查询课程缓存失败", logger.Error(err), logger.Int64("courseId", in.GetCourseId()))
+	}
+	res, err := c.CourseServiceClient.GetDetailById(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if er := c.cache.SetCourse(ctx, res.GetCourse()); er != nil {
+		c.l.Error("回写课程缓存失败", logger.Error(er), logger.Int64("courseId", in.GetCourseId()))
+	}
+	return res, nil
+}
+
+func (c *CachedCourseServiceClient) Subscribed(ctx context.Context, in *coursev1.SubscribedRequest,
+	opts ...grpc.CallOption) (*coursev1.SubscribedResponse, error) {
+	subscribed, err := c.cache.GetSubscribed(ctx, in.GetUid(), in.GetCourseId())
+	if err == nil {
+		return &coursev1.SubscribedResponse{Subscribed: subscribed}, nil
+	}
+	if err != cache.ErrKeyNotExists {
+		c.l.Error("查询选课缓存失败", logger.Error(err), logger.Int64("uid", in.GetUid()),
+			logger.Int64("courseId", in.GetCourseId()))
+	}
+	res, err := c.CourseServiceClient.Subscribed(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if er := c.cache.SetSubscribed(ctx, in.GetUid(), in.GetCourseId(), res.GetSubscribed()); er != nil {
+		c.l.Error("回写选课缓存失败", logger.Error(er), logger.Int64("uid", in.GetUid()),
+			logger.Int64("courseId", in.GetCourseId()))
+	}
+	return res, nil
+}
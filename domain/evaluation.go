@@ -15,8 +15,10 @@ type Evaluation struct {
 	Content        string
 	Status         evaluationv1.EvaluationStatus
 	IsAnonymous    bool
-	Utime          time.Time
-	Ctime          time.Time
+	// 修改的时候是调用方读到的版本号，0表示不检查
+	Version int64
+	Utime   time.Time
+	Ctime   time.Time
 }
 
 type CompositeScore struct {
@@ -24,3 +26,41 @@ type CompositeScore struct {
 	Score    float64
 	RaterCnt int64
 }
+
+// ExportFilter 导出课评的条件，零值表示不过滤。只导出公开的课评
+type ExportFilter struct {
+	CourseId       int64
+	CourseProperty coursev1.CourseProperty
+	// 按发布时间过滤，左闭右开
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// ExportRecord 导出的一条课评，匿名课评的发布者是化名
+type ExportRecord struct {
+	EvaluationId   int64
+	Publisher      string
+	CourseId       int64
+	CourseProperty coursev1.CourseProperty
+	StarRating     uint8
+	Content        string
+	Status         evaluationv1.EvaluationStatus
+	IsAnonymous    bool
+	Utime          time.Time
+	Ctime          time.Time
+}
+
+// PendingEvaluation 等待校验选课关系的课评，校验通过之前不可见
+type PendingEvaluation struct {
+	Id         int64
+	Evaluation Evaluation
+	// 修改已有的课评，否则是新建，课评id是预先分配的
+	IsUpdate bool
+	// 已经校验过几次
+	Attempts int
+	NextTime time.Time
+	// 转成死信的时间，零值表示还在重试
+	DeadTime  time.Time
+	LastError string
+	Ctime     time.Time
+}
@@ -0,0 +1,84 @@
+package course
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/IBM/sarama"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/MuxiKeStack/be-evaluation/events"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"github.com/MuxiKeStack/be-evaluation/service"
+	"time"
+)
+
+// CourseUpdatedConsumer 课程更新之后删掉课程缓存，同步课评上冗余的课程性质。
+// 处理失败只记日志然后提交，漏掉的由定时全量核对兜底，不让一条坏消息堵住整个分区
+type CourseUpdatedConsumer struct {
+	client sarama.Client
+	svc    service.CoursePropertyService
+	cache  cache.CourseCache
+	l      logger.Logger
+}
+
+func NewCourseUpdatedConsumer(client sarama.Client, svc service.CoursePropertyService, cache cache.CourseCache,
+	l logger.Logger) *CourseUpdatedConsumer {
+	return &CourseUpdatedConsumer{client: client, svc: svc, cache: cache, l: l}
+}
+
+func (c *CourseUpdatedConsumer) Start() error {
+	cg, err := sarama.NewConsumerGroupFromClient("evaluation_course_updated", c.client)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			// 重平衡之后Consume会返回，需要重新进入
+			er := cg.Consume(context.Background(), []string{events.TopicCourseUpdated}, c)
+			if er == sarama.ErrClosedConsumerGroup {
+				return
+			}
+			if er != nil {
+				c.l.Error("消费课程更新事件出错", logger.Error(er))
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *CourseUpdatedConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *CourseUpdatedConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (c *CourseUpdatedConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		c.consume(msg)
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+func (c *CourseUpdatedConsumer) consume(msg *sarama.ConsumerMessage) {
+	var evt events.CourseUpdatedEvent
+	err := json.Unmarshal(msg.Value, &evt)
+	if err != nil {
+		c.l.Error("反序列化课程更新事件失败", logger.Error(err),
+			logger.String("topic", msg.Topic), logger.Int64("offset", msg.Offset))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	err = c.cache.DeleteCourse(ctx, evt.CourseId)
+	if err != nil {
+		c.l.Error("删除课程缓存失败", logger.Error(err), logger.Int64("courseId", evt.CourseId))
+	}
+	err = c.svc.SyncCourse(ctx, evt.CourseId, coursev1.CourseProperty(evt.Property))
+	if err != nil {
+		c.l.Error("同步课程性质失败", logger.Error(err), logger.Int64("courseId", evt.CourseId))
+	}
+}
@@ -0,0 +1,102 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryProducerCapacity 每种事件最多留多少条，单机部署会一直跑下去，满了丢掉最早的
+const memoryProducerCapacity = 1000
+
+// MemoryProducer 把事件存在内存里面，测试和本地调试用
+type MemoryProducer struct {
+	mu            sync.RWMutex
+	capacity      int
+	created       []EvaluationCreatedEvent
+	updated       []EvaluationUpdatedEvent
+	statusChanged []EvaluationStatusChangedEvent
+	rejected      []EvaluationRejectedEvent
+	courseMerged  []EvaluationCourseMergedEvent
+}
+
+func NewMemoryProducer() *MemoryProducer {
+	return NewMemoryProducerWithCapacity(memoryProducerCapacity)
+}
+
+func NewMemoryProducerWithCapacity(capacity int) *MemoryProducer {
+	return &MemoryProducer{capacity: capacity}
+}
+
+func (m *MemoryProducer) ProduceEvaluationCreatedEvent(ctx context.Context, evt EvaluationCreatedEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.created = appendCapped(m.created, evt, m.capacity)
+	return nil
+}
+
+func (m *MemoryProducer) ProduceEvaluationUpdatedEvent(ctx context.Context, evt EvaluationUpdatedEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updated = appendCapped(m.updated, evt, m.capacity)
+	return nil
+}
+
+func (m *MemoryProducer) ProduceEvaluationStatusChangedEvent(ctx context.Context, evt EvaluationStatusChangedEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statusChanged = appendCapped(m.statusChanged, evt, m.capacity)
+	return nil
+}
+
+func (m *MemoryProducer) ProduceEvaluationRejectedEvent(ctx context.Context, evt EvaluationRejectedEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rejected = appendCapped(m.rejected, evt, m.capacity)
+	return nil
+}
+
+func (m *MemoryProducer) ProduceEvaluationCourseMergedEvent(ctx context.Context, evt EvaluationCourseMergedEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.courseMerged = appendCapped(m.courseMerged, evt, m.capacity)
+	return nil
+}
+
+func (m *MemoryProducer) CreatedEvents() []EvaluationCreatedEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]EvaluationCreatedEvent(nil), m.created...)
+}
+
+func (m *MemoryProducer) UpdatedEvents() []EvaluationUpdatedEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]EvaluationUpdatedEvent(nil), m.updated...)
+}
+
+func (m *MemoryProducer) StatusChangedEvents() []EvaluationStatusChangedEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]EvaluationStatusChangedEvent(nil), m.statusChanged...)
+}
+
+func (m *MemoryProducer) RejectedEvents() []EvaluationRejectedEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]EvaluationRejectedEvent(nil), m.rejected...)
+}
+
+func (m *MemoryProducer) CourseMergedEvents() []EvaluationCourseMergedEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]EvaluationCourseMergedEvent(nil), m.courseMerged...)
+}
+
+func appendCapped[T any](evts []T, evt T, capacity int) []T {
+	if len(evts) < capacity {
+		return append(evts, evt)
+	}
+	copy(evts, evts[1:])
+	evts[len(evts)-1] = evt
+	return evts
+}
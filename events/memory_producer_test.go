@@ -0,0 +1,21 @@
+package events
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryProducer_Capacity(t *testing.T) {
+	p := NewMemoryProducerWithCapacity(2)
+	for i := int64(1); i <= 3; i++ {
+		err := p.ProduceEvaluationCreatedEvent(context.Background(), EvaluationCreatedEvent{EvaluationId: i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []EvaluationCreatedEvent{{EvaluationId: 2}, {EvaluationId: 3}}
+	if got := p.CreatedEvents(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("满了要丢掉最早的: %+v", got)
+	}
+}
@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/IBM/sarama"
+	"strconv"
+)
+
+// SaramaSyncProducer 任何兼容 kafka 协议的消息队列都可以用
+type SaramaSyncProducer struct {
+	producer sarama.SyncProducer
+}
+
+func NewSaramaSyncProducer(producer sarama.SyncProducer) Producer {
+	return &SaramaSyncProducer{producer: producer}
+}
+
+func (s *SaramaSyncProducer) ProduceEvaluationCreatedEvent(ctx context.Context, evt EvaluationCreatedEvent) error {
+	return s.produce(TopicEvaluationCreated, evt.EvaluationId, evt)
+}
+
+func (s *SaramaSyncProducer) ProduceEvaluationUpdatedEvent(ctx context.Context, evt EvaluationUpdatedEvent) error {
+	return s.produce(TopicEvaluationUpdated, evt.EvaluationId, evt)
+}
+
+func (s *SaramaSyncProducer) ProduceEvaluationStatusChangedEvent(ctx context.Context, evt EvaluationStatusChangedEvent) error {
+	return s.produce(TopicEvaluationStatusChanged, evt.EvaluationId, evt)
+}
+
+func (s *SaramaSyncProducer) ProduceEvaluationRejectedEvent(ctx context.Context, evt EvaluationRejectedEvent) error {
+	return s.produce(TopicEvaluationRejected, evt.EvaluationId, evt)
+}
+
+func (s *SaramaSyncProducer) ProduceEvaluationCourseMergedEvent(ctx context.Context, evt EvaluationCourseMergedEvent) error {
+	return s.produce(TopicEvaluationCourseMerged, evt.EvaluationId, evt)
+}
+
+// produce 用课评id做key，同一条课评的事件落在同一个分区里，保证顺序
+func (s *SaramaSyncProducer) produce(topic string, evaluationId int64, evt any) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(strconv.FormatInt(evaluationId, 10)),
+		Value: sarama.ByteEncoder(data),
+	})
+	return err
+}
@@ -0,0 +1,103 @@
+package events
+
+import "context"
+
+const (
+	TopicEvaluationCreated       = "evaluation_created"
+	TopicEvaluationUpdated       = "evaluation_updated"
+	TopicEvaluationStatusChanged = "evaluation_status_changed"
+	TopicEvaluationRejected      = "evaluation_rejected"
+	TopicEvaluationCourseMerged  = "evaluation_course_merged"
+	TopicCourseUpdated           = "course_updated"
+)
+
+// Consumer 消费其它服务的事件，Start不阻塞
+type Consumer interface {
+	Start() error
+}
+
+// Producer 课评生命周期事件的发送方。
+// 事件先和业务数据在同一个事务里面写进发件箱，再由投递任务发出去，所以是至少一次语义，
+// 消费方需要按 EventId 去重
+type Producer interface {
+	ProduceEvaluationCreatedEvent(ctx context.Context, evt EvaluationCreatedEvent) error
+	ProduceEvaluationUpdatedEvent(ctx context.Context, evt EvaluationUpdatedEvent) error
+	ProduceEvaluationStatusChangedEvent(ctx context.Context, evt EvaluationStatusChangedEvent) error
+	ProduceEvaluationRejectedEvent(ctx context.Context, evt EvaluationRejectedEvent) error
+	ProduceEvaluationCourseMergedEvent(ctx context.Context, evt EvaluationCourseMergedEvent) error
+}
+
+// EvaluationCreatedEvent 发布了一条课评
+type EvaluationCreatedEvent struct {
+	EventId      int64 `json:"event_id"`
+	EvaluationId int64 `json:"evaluation_id"`
+	PublisherId  int64 `json:"publisher_id"`
+	CourseId     int64 `json:"course_id"`
+	StarRating   uint8 `json:"star_rating"`
+	Status       int32 `json:"status"`
+	Ctime        int64 `json:"ctime"`
+}
+
+// EvaluationUpdatedEvent 编辑了一条课评
+type EvaluationUpdatedEvent struct {
+	EventId      int64 `json:"event_id"`
+	EvaluationId int64 `json:"evaluation_id"`
+	PublisherId  int64 `json:"publisher_id"`
+	CourseId     int64 `json:"course_id"`
+	OldRating    uint8 `json:"old_rating"`
+	NewRating    uint8 `json:"new_rating"`
+	Status       int32 `json:"status"`
+	Ctime        int64 `json:"ctime"`
+}
+
+// EvaluationStatusChangedEvent 课评被公开、隐藏或者折叠
+type EvaluationStatusChangedEvent struct {
+	EventId      int64 `json:"event_id"`
+	EvaluationId int64 `json:"evaluation_id"`
+	PublisherId  int64 `json:"publisher_id"`
+	CourseId     int64 `json:"course_id"`
+	OldStatus    int32 `json:"old_status"`
+	NewStatus    int32 `json:"new_status"`
+	StarRating   uint8 `json:"star_rating"`
+	Ctime        int64 `json:"ctime"`
+}
+
+// EvaluationCourseMergedEvent 管理员合并了重复的课程，源课程上的课评挪到了目标课程。
+// 作者在两门课上都评过的时候只保留较新的一条，另一条 Dropped 是 true，课评已经不存在了
+type EvaluationCourseMergedEvent struct {
+	EventId        int64 `json:"event_id"`
+	EvaluationId   int64 `json:"evaluation_id"`
+	PublisherId    int64 `json:"publisher_id"`
+	SourceCourseId int64 `json:"source_course_id"`
+	TargetCourseId int64 `json:"target_course_id"`
+	Dropped        bool  `json:"dropped"`
+	StarRating     uint8 `json:"star_rating"`
+	Status         int32 `json:"status"`
+	Ctime          int64 `json:"ctime"`
+}
+
+const (
+	RejectReasonNotSubscribed  = "not_subscribed"
+	RejectReasonCourseNotFound = "course_not_found"
+	// RejectReasonDuplicate 校验期间作者已经评过这门课了，比如在课程服务不可用的时候又同步提交了一条
+	RejectReasonDuplicate = "duplicate"
+	// RejectReasonVersionConflict 校验期间作者在别的地方改过这条课评，这次的修改作废
+	RejectReasonVersionConflict = "version_conflict"
+)
+
+// EvaluationRejectedEvent 待校验的课评没有通过校验，被丢弃了，通知服务据此通知作者。
+// 不经过发件箱，EventId 是待校验记录的id
+type EvaluationRejectedEvent struct {
+	EventId      int64  `json:"event_id"`
+	EvaluationId int64  `json:"evaluation_id"`
+	PublisherId  int64  `json:"publisher_id"`
+	CourseId     int64  `json:"course_id"`
+	Reason       string `json:"reason"`
+	Ctime        int64  `json:"ctime"`
+}
+
+// CourseUpdatedEvent 课程服务在课程信息变更之后发出，这里只关心课程性质
+type CourseUpdatedEvent struct {
+	CourseId int64 `json:"course_id"`
+	Property int32 `json:"property"`
+}
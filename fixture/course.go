@@ -0,0 +1,71 @@
+package fixture
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CourseFixture 本地开发用的课程和选课数据，yaml和json都可以
+type CourseFixture struct {
+	Courses []struct {
+		Id       int64 `yaml:"id"`
+		Property int32 `yaml:"property"`
+	} `yaml:"courses"`
+	Subscriptions []struct {
+		Uid       int64   `yaml:"uid"`
+		CourseIds []int64 `yaml:"courseIds"`
+	} `yaml:"subscriptions"`
+}
+
+// CourseServiceClient 不连课程服务，从文件里面读课程，只实现课评服务用到的方法，
+// 其它方法调用会panic
+type CourseServiceClient struct {
+	coursev1.CourseServiceClient
+	courses    map[int64]*coursev1.Course
+	subscribed map[int64]map[int64]bool
+}
+
+func NewCourseServiceClient(path string) (*CourseServiceClient, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var f CourseFixture
+	if err := v.Unmarshal(&f); err != nil {
+		return nil, err
+	}
+	res := &CourseServiceClient{
+		courses:    make(map[int64]*coursev1.Course, len(f.Courses)),
+		subscribed: make(map[int64]map[int64]bool, len(f.Subscriptions)),
+	}
+	for _, c := range f.Courses {
+		res.courses[c.Id] = &coursev1.Course{Id: c.Id, Property: coursev1.CourseProperty(c.Property)}
+	}
+	for _, s := range f.Subscriptions {
+		courseIds := make(map[int64]bool, len(s.CourseIds))
+		for _, courseId := range s.CourseIds {
+			courseIds[courseId] = true
+		}
+		res.subscribed[s.Uid] = courseIds
+	}
+	return res, nil
+}
+
+func (c *CourseServiceClient) Subscribed(ctx context.Context, in *coursev1.SubscribedRequest,
+	opts ...grpc.CallOption) (*coursev1.SubscribedResponse, error) {
+	return &coursev1.SubscribedResponse{Subscribed: c.subscribed[in.GetUid()][in.GetCourseId()]}, nil
+}
+
+func (c *CourseServiceClient) GetDetailById(ctx context.Context, in *coursev1.GetDetailByIdRequest,
+	opts ...grpc.CallOption) (*coursev1.GetDetailByIdResponse, error) {
+	course, ok := c.courses[in.GetCourseId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "课程 %d 不存在", in.GetCourseId())
+	}
+	return &coursev1.GetDetailByIdResponse{Course: course}, nil
+}
@@ -0,0 +1,100 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"github.com/MuxiKeStack/be-evaluation/service"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"strconv"
+)
+
+// be-api 的 proto 里面还没有这几个原因，先在这里定义，加到 proto 之后换成生成的函数
+const (
+	ReasonPermissionDenied        = "PERMISSION_DENIED"
+	ReasonIllegalStatusTransition = "ILLEGAL_STATUS_TRANSITION"
+	ReasonDuplicateEvaluation     = "DUPLICATE_EVALUATION"
+	ReasonDependencyUnavailable   = "DEPENDENCY_UNAVAILABLE"
+	ReasonVersionConflict         = "VERSION_CONFLICT"
+)
+
+// EvaluationVersionHeader be-api 的 Evaluation 还没有版本号字段，先用 header 传：
+// Detail 在响应 header 里面返回当前版本号，Save 修改课评时在请求 header 里面带上读到的版本号
+const EvaluationVersionHeader = "x-evaluation-version"
+
+// ErrorMapping 把 service 层的错误统一转换成带 reason 的 grpc 错误，handler 里面直接返回 service 的错误就可以
+func ErrorMapping() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			reply, err := handler(ctx, req)
+			return reply, MapError(err)
+		}
+	}
+}
+
+// MapError 不认识的错误原样返回，由 kratos 按未知错误处理。
+// 流式接口不经过 middleware，要自己调用
+func MapError(err error) error {
+	var (
+		ve *service.ValidationError
+		ce *service.VersionConflictError
+		ke *kerrors.Error
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &ve):
+		return invalidInput(ve)
+	case errors.As(err, &ce):
+		return versionConflict(ce)
+	case errors.Is(err, service.ErrEvaluationNotFound):
+		return evaluationv1.ErrorEvaluationNotFound("课评不存在")
+	case errors.Is(err, service.ErrPermissionDenied):
+		return kerrors.Forbidden(ReasonPermissionDenied, "没有权限修改这条课评")
+	case errors.Is(err, service.ErrCannotEvaluateUnattendedCourse):
+		return evaluationv1.ErrorCanNotEvaluateUnattendedCourse("不能评价未上过的课程")
+	case errors.Is(err, service.ErrIllegalStatusTransition):
+		return kerrors.Conflict(ReasonIllegalStatusTransition, "课评当前的状态不允许这样变更")
+	case errors.Is(err, service.ErrDuplicateEvaluation):
+		return kerrors.Conflict(ReasonDuplicateEvaluation, "已经评价过这门课了")
+	case errors.Is(err, service.ErrTooManyRequests):
+		return evaluationv1.ErrorGormTooManyRequest("请求太多，请稍后再试")
+	case errors.Is(err, service.ErrDependencyUnavailable),
+		errors.Is(err, repository.ErrCompositeScoreUnavailable),
+		errors.Is(err, idgen.ErrNoWorkerId):
+		// 错误详情里面可能有下游的地址，不返回给调用方
+		return kerrors.ServiceUnavailable(ReasonDependencyUnavailable, "服务暂时不可用，请稍后再试")
+	case errors.As(err, &ke):
+		// 已经是 grpc 错误了。放在最后，包着下游错误的哨兵错误要先按自己的原因转换，不能把下游的原因透出去
+		return err
+	}
+	return err
+}
+
+// invalidInput 字段级别的错误放在 metadata 里面，key 是字段名，value 是原因
+func invalidInput(ve *service.ValidationError) error {
+	md := make(map[string]string, len(ve.Fields))
+	for _, f := range ve.Fields {
+		if msg, ok := md[f.Field]; ok {
+			md[f.Field] = msg + "; " + f.Message
+			continue
+		}
+		md[f.Field] = f.Message
+	}
+	return evaluationv1.ErrorInvalidInput("%s", ve.Error()).WithMetadata(md)
+}
+
+// versionConflict 最新的课评放在 metadata 里面，客户端可以提示用户合并之后带上新的版本号重新提交
+func versionConflict(ce *service.VersionConflictError) error {
+	cur := ce.Current
+	return kerrors.Conflict(ReasonVersionConflict, ce.Error()).WithMetadata(map[string]string{
+		"version":      strconv.FormatInt(cur.Version, 10),
+		"star_rating":  strconv.FormatUint(uint64(cur.StarRating), 10),
+		"content":      cur.Content,
+		"status":       strconv.FormatInt(int64(cur.Status), 10),
+		"is_anonymous": strconv.FormatBool(cur.IsAnonymous),
+	})
+}
@@ -0,0 +1,94 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/service"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware/circuitbreaker"
+	"testing"
+)
+
+func TestMapError(t *testing.T) {
+	testCases := []struct {
+		name       string
+		err        error
+		wantCode   int
+		wantReason string
+		wantMd     map[string]string
+	}{
+		{
+			name:       "课程服务熔断，不能把下游的原因透出去",
+			err:        fmt.Errorf("%w: %w", service.ErrDependencyUnavailable, circuitbreaker.ErrNotAllowed),
+			wantCode:   503,
+			wantReason: ReasonDependencyUnavailable,
+		},
+		{
+			name:       "课程服务超时",
+			err:        fmt.Errorf("%w: %w", service.ErrDependencyUnavailable, kerrors.GatewayTimeout("TIMEOUT", "超时")),
+			wantCode:   503,
+			wantReason: ReasonDependencyUnavailable,
+		},
+		{
+			name:       "已经是grpc错误的原样返回",
+			err:        kerrors.Unauthorized("UNAUTHENTICATED", "没有登录"),
+			wantCode:   401,
+			wantReason: "UNAUTHENTICATED",
+		},
+		{
+			name:       "没有权限",
+			err:        fmt.Errorf("修改课评: %w", service.ErrPermissionDenied),
+			wantCode:   403,
+			wantReason: ReasonPermissionDenied,
+		},
+		{
+			name:       "重复评价",
+			err:        service.ErrDuplicateEvaluation,
+			wantCode:   409,
+			wantReason: ReasonDuplicateEvaluation,
+		},
+		{
+			name: "参数错误带上字段",
+			err: &service.ValidationError{Fields: []service.FieldError{
+				{Field: "content", Message: "太长了"},
+				{Field: "content", Message: "不是合法的UTF-8"},
+			}},
+			wantCode: 400,
+			wantMd:   map[string]string{"content": "太长了; 不是合法的UTF-8"},
+		},
+		{
+			name:       "版本冲突带上最新的版本号",
+			err:        &service.VersionConflictError{Current: domain.Evaluation{Version: 3}},
+			wantCode:   409,
+			wantReason: ReasonVersionConflict,
+			wantMd:     map[string]string{"version": "3"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ke := kerrors.FromError(MapError(tc.err))
+			if int(ke.Code) != tc.wantCode {
+				t.Fatalf("code = %d, 想要 %d: %v", ke.Code, tc.wantCode, ke)
+			}
+			if tc.wantReason != "" && ke.Reason != tc.wantReason {
+				t.Fatalf("reason = %s, 想要 %s", ke.Reason, tc.wantReason)
+			}
+			for k, v := range tc.wantMd {
+				if ke.Metadata[k] != v {
+					t.Fatalf("metadata[%s] = %q, 想要 %q", k, ke.Metadata[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestMapErrorUnknown(t *testing.T) {
+	err := errors.New("不认识的错误")
+	if MapError(err) != err {
+		t.Fatal("不认识的错误要原样返回")
+	}
+	if MapError(nil) != nil {
+		t.Fatal("nil 要返回 nil")
+	}
+}
@@ -6,13 +6,16 @@ import (
 	"github.com/MuxiKeStack/be-evaluation/domain"
 	"github.com/MuxiKeStack/be-evaluation/service"
 	"github.com/ecodeclub/ekit/slice"
+	"github.com/go-kratos/kratos/v2/transport"
 	"google.golang.org/grpc"
 	"math"
+	"strconv"
 )
 
 type EvaluationServiceServer struct {
 	evaluationv1.UnimplementedEvaluationServiceServer
-	svc service.EvaluationService
+	svc       service.EvaluationService
+	validator service.EvaluationValidator
 }
 
 func (s *EvaluationServiceServer) CompositeScoreCourse(ctx context.Context,
@@ -34,8 +37,10 @@ func (s *EvaluationServiceServer) VisiblePublishersCourse(ctx context.Context,
 
 func (s *EvaluationServiceServer) Detail(ctx context.Context, request *evaluationv1.DetailRequest) (*evaluationv1.DetailResponse, error) {
 	evaluation, err := s.svc.Detail(ctx, request.GetEvaluationId())
-	if err == service.ErrEvaluationNotFound {
-		return &evaluationv1.DetailResponse{}, evaluationv1.ErrorEvaluationNotFound("课评不存在: %d", request.GetEvaluationId())
+	if err == nil {
+		if tr, ok := transport.FromServerContext(ctx); ok {
+			tr.ReplyHeader().Set(EvaluationVersionHeader, strconv.FormatInt(evaluation.Version, 10))
+		}
 	}
 	return &evaluationv1.DetailResponse{Evaluation: convertToV(evaluation)}, err
 }
@@ -98,8 +103,8 @@ func (s *EvaluationServiceServer) ListRecent(ctx context.Context,
 	}, err
 }
 
-func NewEvaluationServiceServer(svc service.EvaluationService) *EvaluationServiceServer {
-	return &EvaluationServiceServer{svc: svc}
+func NewEvaluationServiceServer(svc service.EvaluationService, validator service.EvaluationValidator) *EvaluationServiceServer {
+	return &EvaluationServiceServer{svc: svc, validator: validator}
 }
 
 func (s *EvaluationServiceServer) Register(server grpc.ServiceRegistrar) {
@@ -116,32 +121,58 @@ func (s *EvaluationServiceServer) Evaluated(ctx context.Context,
 
 func (s *EvaluationServiceServer) Save(ctx context.Context,
 	request *evaluationv1.SaveRequest) (*evaluationv1.SaveResponse, error) {
-	if request.GetEvaluation().GetId() == 0 && request.GetEvaluation().GetStatus() != evaluationv1.EvaluationStatus_Public {
-		return nil, evaluationv1.ErrorInvalidInput("不可以非公开状态发布课评")
+	evaluation := convertDomain(request.GetEvaluation())
+	version, err := expectedVersion(ctx)
+	if err != nil {
+		return &evaluationv1.SaveResponse{}, err
 	}
-	id, err := s.svc.Save(ctx, convertDomain(request.GetEvaluation()))
-	if err == service.ErrCannotEvaluateUnattendedCourse {
-		return &evaluationv1.SaveResponse{}, evaluationv1.ErrorCanNotEvaluateUnattendedCourse("不能评价未上过的课程")
+	evaluation.Version = version
+	// 转换成uint8之前校验评分，否则300会变成44
+	err = s.validator.ValidateSave(evaluation, request.GetEvaluation().GetStarRating())
+	if err != nil {
+		return &evaluationv1.SaveResponse{}, err
 	}
+	id, err := s.svc.Save(ctx, evaluation)
 	return &evaluationv1.SaveResponse{EvaluationId: id}, err
 }
 
-// UpdateStatus TODO 如果是权限不够这个错误要抛上去，需要在这里判断RecordNotFound并在proto里面定义然后往上抛
 func (s *EvaluationServiceServer) UpdateStatus(ctx context.Context,
 	request *evaluationv1.UpdateStatusRequest) (*evaluationv1.UpdateStatusResponse, error) {
-	err := s.svc.UpdateStatus(ctx, request.GetEvaluationId(), request.GetStatus(), request.GetUid())
+	err := s.validator.ValidateUpdateStatus(request.GetEvaluationId(), request.GetStatus(), request.GetUid())
+	if err != nil {
+		return &evaluationv1.UpdateStatusResponse{}, err
+	}
+	err = s.svc.UpdateStatus(ctx, request.GetEvaluationId(), request.GetStatus(), request.GetUid())
 	return &evaluationv1.UpdateStatusResponse{}, err
 }
 
+// expectedVersion 修改课评时调用方读到的版本号，没有带的话是0，不检查
+func expectedVersion(ctx context.Context) (int64, error) {
+	tr, ok := transport.FromServerContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	val := tr.RequestHeader().Get(EvaluationVersionHeader)
+	if val == "" {
+		return 0, nil
+	}
+	version, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, &service.ValidationError{Fields: []service.FieldError{{Field: "version", Message: "不是合法的整数"}}}
+	}
+	return version, nil
+}
+
+// convertDomain 请求里面可能没有带课评，用Get方法取字段
 func convertDomain(e *evaluationv1.Evaluation) domain.Evaluation {
 	return domain.Evaluation{
-		Id:          e.Id,
-		PublisherId: e.PublisherId,
-		CourseId:    e.CourseId,
-		StarRating:  uint8(e.StarRating),
-		Content:     e.Content,
-		Status:      e.Status,
-		IsAnonymous: e.IsAnonymous,
+		Id:          e.GetId(),
+		PublisherId: e.GetPublisherId(),
+		CourseId:    e.GetCourseId(),
+		StarRating:  uint8(e.GetStarRating()),
+		Content:     e.GetContent(),
+		Status:      e.GetStatus(),
+		IsAnonymous: e.GetIsAnonymous(),
 	}
 }
 
@@ -0,0 +1,177 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	exportv1 "github.com/MuxiKeStack/be-evaluation/api/export/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/service"
+	"google.golang.org/grpc"
+	"strconv"
+	"time"
+)
+
+// 攒够这么多字节再发一次，减少消息数量
+const exportChunkSize = 32 * 1024
+
+var exportCSVHeader = []string{"evaluation_id", "publisher", "course_id", "course_property", "star_rating",
+	"content", "status", "is_anonymous", "ctime", "utime"}
+
+type ExportServiceServer struct {
+	svc service.ExportService
+}
+
+func NewExportServiceServer(svc service.ExportService) *ExportServiceServer {
+	return &ExportServiceServer{svc: svc}
+}
+
+func (s *ExportServiceServer) Register(server grpc.ServiceRegistrar) {
+	exportv1.RegisterExportServiceServer(server, s)
+}
+
+func (s *ExportServiceServer) Export(req *exportv1.ExportRequest, stream exportv1.ExportService_ExportServer) error {
+	filter, err := convertExportFilter(req)
+	if err != nil {
+		return err
+	}
+	var (
+		buf bytes.Buffer
+		enc exportEncoder
+	)
+	switch req.Format {
+	case exportv1.FormatCSV:
+		enc = newCSVExportEncoder(&buf)
+	case exportv1.FormatJSONLines, "":
+		enc = &jsonExportEncoder{enc: json.NewEncoder(&buf)}
+	default:
+		return evaluationv1.ErrorInvalidInput("不支持的导出格式: %s", req.Format)
+	}
+	err = s.svc.Export(stream.Context(), filter, func(rec domain.ExportRecord) error {
+		if er := enc.Encode(rec); er != nil {
+			return er
+		}
+		if buf.Len() < exportChunkSize {
+			return nil
+		}
+		er := stream.Send(buf.Bytes())
+		buf.Reset()
+		return er
+	})
+	if err != nil {
+		// 流式接口不经过 ErrorMapping
+		return MapError(err)
+	}
+	if err = enc.Flush(); err != nil {
+		return err
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return stream.Send(buf.Bytes())
+}
+
+func convertExportFilter(req *exportv1.ExportRequest) (domain.ExportFilter, error) {
+	// 私密和折叠的课评作者不希望被别人看到，不能导出
+	if evaluationv1.EvaluationStatus(req.Status) != evaluationv1.EvaluationStatus_Public {
+		return domain.ExportFilter{}, evaluationv1.ErrorInvalidInput("只能导出公开的课评: %d", req.Status)
+	}
+	if req.StartTime < 0 || req.EndTime < 0 || (req.EndTime > 0 && req.EndTime <= req.StartTime) {
+		return domain.ExportFilter{}, evaluationv1.ErrorInvalidInput("不合法的时间范围: [%d, %d)", req.StartTime, req.EndTime)
+	}
+	filter := domain.ExportFilter{
+		CourseId:       req.CourseId,
+		CourseProperty: coursev1.CourseProperty(req.Property),
+	}
+	if req.StartTime > 0 {
+		filter.StartTime = time.UnixMilli(req.StartTime)
+	}
+	if req.EndTime > 0 {
+		filter.EndTime = time.UnixMilli(req.EndTime)
+	}
+	return filter, nil
+}
+
+type exportEncoder interface {
+	Encode(rec domain.ExportRecord) error
+	Flush() error
+}
+
+type csvExportEncoder struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVExportEncoder(buf *bytes.Buffer) *csvExportEncoder {
+	return &csvExportEncoder{w: csv.NewWriter(buf)}
+}
+
+func (e *csvExportEncoder) Encode(rec domain.ExportRecord) error {
+	if !e.wroteHeader {
+		e.wroteHeader = true
+		if err := e.w.Write(exportCSVHeader); err != nil {
+			return err
+		}
+	}
+	err := e.w.Write([]string{
+		strconv.FormatInt(rec.EvaluationId, 10),
+		rec.Publisher,
+		strconv.FormatInt(rec.CourseId, 10),
+		strconv.Itoa(int(rec.CourseProperty)),
+		strconv.Itoa(int(rec.StarRating)),
+		rec.Content,
+		strconv.Itoa(int(rec.Status)),
+		strconv.FormatBool(rec.IsAnonymous),
+		strconv.FormatInt(rec.Ctime.UnixMilli(), 10),
+		strconv.FormatInt(rec.Utime.UnixMilli(), 10),
+	})
+	if err != nil {
+		return err
+	}
+	// csv.Writer 自己也有缓冲，要刷到 buf 里面才能按大小分段
+	return e.Flush()
+}
+
+func (e *csvExportEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonExportEncoder struct {
+	enc *json.Encoder
+}
+
+type exportJSONRecord struct {
+	EvaluationId   int64  `json:"evaluation_id"`
+	Publisher      string `json:"publisher"`
+	CourseId       int64  `json:"course_id"`
+	CourseProperty int32  `json:"course_property"`
+	StarRating     uint8  `json:"star_rating"`
+	Content        string `json:"content"`
+	Status         int32  `json:"status"`
+	IsAnonymous    bool   `json:"is_anonymous"`
+	Ctime          int64  `json:"ctime"`
+	Utime          int64  `json:"utime"`
+}
+
+func (e *jsonExportEncoder) Encode(rec domain.ExportRecord) error {
+	// Encoder 每条后面自带换行，正好是 JSON Lines
+	return e.enc.Encode(exportJSONRecord{
+		EvaluationId:   rec.EvaluationId,
+		Publisher:      rec.Publisher,
+		CourseId:       rec.CourseId,
+		CourseProperty: int32(rec.CourseProperty),
+		StarRating:     rec.StarRating,
+		Content:        rec.Content,
+		Status:         int32(rec.Status),
+		IsAnonymous:    rec.IsAnonymous,
+		Ctime:          rec.Ctime.UnixMilli(),
+		Utime:          rec.Utime.UnixMilli(),
+	})
+}
+
+func (e *jsonExportEncoder) Flush() error {
+	return nil
+}
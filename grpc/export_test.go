@@ -0,0 +1,38 @@
+package grpc
+
+import (
+	exportv1 "github.com/MuxiKeStack/be-evaluation/api/export/v1"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"testing"
+)
+
+func TestConvertExportFilter(t *testing.T) {
+	testCases := []struct {
+		name    string
+		req     *exportv1.ExportRequest
+		wantErr bool
+	}{
+		{name: "公开", req: &exportv1.ExportRequest{Status: 0, CourseId: 1}},
+		{name: "私密", req: &exportv1.ExportRequest{Status: 1}, wantErr: true},
+		{name: "折叠", req: &exportv1.ExportRequest{Status: 2}, wantErr: true},
+		{name: "不认识的状态", req: &exportv1.ExportRequest{Status: 100}, wantErr: true},
+		{name: "时间范围反了", req: &exportv1.ExportRequest{StartTime: 2000, EndTime: 1000}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := convertExportFilter(tc.req)
+			if tc.wantErr {
+				if kerrors.Code(err) != 400 {
+					t.Fatalf("应该是参数错误，实际是 %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filter.CourseId != tc.req.CourseId {
+				t.Errorf("课程id是 %d，应该是 %d", filter.CourseId, tc.req.CourseId)
+			}
+		})
+	}
+}
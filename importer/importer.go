@@ -0,0 +1,107 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"io"
+	"time"
+)
+
+type Summary struct {
+	Imported int
+	// 已经导入过的，重复执行的时候会出现
+	Skipped int
+	Failed  int
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("导入 %d 条，跳过 %d 条，失败 %d 条", s.Imported, s.Skipped, s.Failed)
+}
+
+// Importer 把旧系统的课评导入进来，可以重复执行，已经导入的会被跳过
+type Importer struct {
+	repo      repository.EvaluationRepository
+	userMap   IdMapping
+	courseMap IdMapping
+	l         logger.Logger
+}
+
+func NewImporter(repo repository.EvaluationRepository, userMap IdMapping, courseMap IdMapping, l logger.Logger) *Importer {
+	return &Importer{repo: repo, userMap: userMap, courseMap: courseMap, l: l}
+}
+
+func (i *Importer) Run(ctx context.Context, r Reader) (Summary, error) {
+	var s Summary
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return s, nil
+		}
+		var re *ReadError
+		if errors.As(err, &re) {
+			return s, err
+		}
+		if err == nil {
+			err = i.importRecord(ctx, rec)
+		}
+		switch {
+		case err == nil:
+			s.Imported++
+		case errors.Is(err, repository.ErrDuplicateEvaluation):
+			s.Skipped++
+		case ctx.Err() != nil:
+			return s, ctx.Err()
+		default:
+			s.Failed++
+			i.l.Error("导入课评失败", logger.Error(err), logger.Int64("line", int64(r.Line())))
+		}
+	}
+}
+
+func (i *Importer) importRecord(ctx context.Context, rec Record) error {
+	evaluation, err := i.toDomain(rec)
+	if err != nil {
+		return err
+	}
+	_, err = i.repo.Import(ctx, evaluation)
+	return err
+}
+
+func (i *Importer) toDomain(rec Record) (domain.Evaluation, error) {
+	if rec.StarRating < 1 || rec.StarRating > 5 {
+		return domain.Evaluation{}, fmt.Errorf("不合法的星级 %d", rec.StarRating)
+	}
+	switch evaluationv1.EvaluationStatus(rec.Status) {
+	case evaluationv1.EvaluationStatus_Public, evaluationv1.EvaluationStatus_Private, evaluationv1.EvaluationStatus_Folded:
+	default:
+		return domain.Evaluation{}, fmt.Errorf("不合法的课评状态 %d", rec.Status)
+	}
+	if rec.Ctime <= 0 || rec.Utime < rec.Ctime {
+		return domain.Evaluation{}, fmt.Errorf("不合法的时间 ctime %d, utime %d", rec.Ctime, rec.Utime)
+	}
+	publisherId, ok := i.userMap.Map(rec.PublisherId)
+	if !ok || publisherId <= 0 {
+		return domain.Evaluation{}, fmt.Errorf("找不到用户 %d 的新id", rec.PublisherId)
+	}
+	courseId, ok := i.courseMap.Map(rec.CourseId)
+	if !ok || courseId <= 0 {
+		return domain.Evaluation{}, fmt.Errorf("找不到课程 %d 的新id", rec.CourseId)
+	}
+	return domain.Evaluation{
+		PublisherId:    publisherId,
+		CourseId:       courseId,
+		CourseProperty: coursev1.CourseProperty(rec.CourseProperty),
+		StarRating:     uint8(rec.StarRating),
+		Content:        rec.Content,
+		Status:         evaluationv1.EvaluationStatus(rec.Status),
+		IsAnonymous:    rec.IsAnonymous,
+		Ctime:          time.UnixMilli(rec.Ctime),
+		Utime:          time.UnixMilli(rec.Utime),
+	}, nil
+}
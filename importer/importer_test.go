@@ -0,0 +1,105 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+type importRepo struct {
+	repository.EvaluationRepository
+	imported []domain.Evaluation
+}
+
+func (r *importRepo) Import(ctx context.Context, evaluation domain.Evaluation) (int64, error) {
+	for _, e := range r.imported {
+		if e.PublisherId == evaluation.PublisherId && e.CourseId == evaluation.CourseId {
+			return 0, repository.ErrDuplicateEvaluation
+		}
+	}
+	r.imported = append(r.imported, evaluation)
+	return int64(len(r.imported)), nil
+}
+
+func TestImporter_Run(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    Summary
+		wantErr bool
+	}{
+		{
+			name: "正常导入",
+			input: `{"publisher_id":1,"course_id":1,"star_rating":5,"status":0,"ctime":1000,"utime":1000}
+
+{"publisher_id":2,"course_id":1,"star_rating":4,"status":1,"ctime":1000,"utime":2000}
+`,
+			want: Summary{Imported: 2},
+		},
+		{
+			name: "重复的跳过，不合法的行不影响后面的行",
+			input: `{"publisher_id":1,"course_id":1,"star_rating":5,"status":0,"ctime":1000,"utime":1000}
+{"publisher_id":1,"course_id":1,"star_rating":5,"status":0,"ctime":1000,"utime":1000}
+not json
+{"publisher_id":3,"course_id":1,"star_rating":9,"status":0,"ctime":1000,"utime":1000}
+{"publisher_id":4,"course_id":1,"star_rating":3,"status":0,"ctime":1000,"utime":1000}
+`,
+			want: Summary{Imported: 2, Skipped: 1, Failed: 2},
+		},
+		{
+			name: "一行太长就停下来，不能一直失败下去",
+			input: `{"publisher_id":1,"course_id":1,"star_rating":5,"status":0,"ctime":1000,"utime":1000}
+{"publisher_id":2,"course_id":1,"star_rating":5,"status":0,"ctime":1000,"utime":1000,"content":"` +
+				strings.Repeat("长", maxJSONLLineSize) + `"}
+{"publisher_id":3,"course_id":1,"star_rating":5,"status":0,"ctime":1000,"utime":1000}
+`,
+			want:    Summary{Imported: 1},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			imp := NewImporter(&importRepo{}, nil, nil, logger.NewNopLogger())
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			s, err := imp.Run(ctx, NewJSONLReader(strings.NewReader(tc.input)))
+			var re *ReadError
+			if tc.wantErr != errors.As(err, &re) {
+				t.Fatalf("错误是 %v，应该返回 ReadError 吗: %v", err, tc.wantErr)
+			}
+			if s != tc.want {
+				t.Fatalf("结果是 %s，应该是 %s", s, tc.want)
+			}
+		})
+	}
+}
+
+func TestCSVReader_Next(t *testing.T) {
+	input := `publisher_id,course_id,star_rating,status,ctime,utime,content
+1,2,5,0,1000,1000,好课
+1,x,5,0,1000,1000,列不是整数
+3,4,5,0,1000,1000,"引号没有闭合
+`
+	r, err := NewCSVReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.Next()
+	if err != nil || rec.CourseId != 2 || rec.Content != "好课" {
+		t.Fatalf("第一行是 %+v, %v", rec, err)
+	}
+	_, err = r.Next()
+	var re *ReadError
+	if err == nil || errors.As(err, &re) {
+		t.Fatalf("第二行应该是可以跳过的解析错误，实际是 %v", err)
+	}
+	_, err = r.Next()
+	if err == nil || errors.As(err, &re) {
+		t.Fatalf("第三行应该是可以跳过的格式错误，实际是 %v", err)
+	}
+}
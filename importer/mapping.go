@@ -0,0 +1,52 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IdMapping 旧系统id到新系统id的映射，nil表示id不需要转换
+type IdMapping map[int64]int64
+
+// LoadIdMapping 文件每行是 旧id,新id，path为空返回nil
+func LoadIdMapping(path string) (IdMapping, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	res := IdMapping{}
+	for line := 1; ; line++ {
+		fields, err := r.Read()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		legacy, err1 := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64)
+		id, err2 := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s 第%d行不是合法的id映射", path, line)
+		}
+		res[legacy] = id
+	}
+}
+
+// Map 没有映射的旧id返回false
+func (m IdMapping) Map(legacy int64) (int64, bool) {
+	if m == nil {
+		return legacy, true
+	}
+	id, ok := m[legacy]
+	return id, ok
+}
@@ -0,0 +1,182 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Record 旧系统导出的一条课评，id都是旧系统的
+type Record struct {
+	PublisherId    int64  `json:"publisher_id"`
+	CourseId       int64  `json:"course_id"`
+	CourseProperty int32  `json:"course_property"`
+	StarRating     int64  `json:"star_rating"`
+	Content        string `json:"content"`
+	Status         int32  `json:"status"`
+	IsAnonymous    bool   `json:"is_anonymous"`
+	// 毫秒时间戳
+	Ctime int64 `json:"ctime"`
+	Utime int64 `json:"utime"`
+}
+
+// Reader 逐行读取，读完返回 io.EOF。单行解析失败不影响后面的行，
+// 读文件本身出错返回 *ReadError，这之后就读不下去了
+type Reader interface {
+	Next() (Record, error)
+	// Line 刚刚读到的行号，从1开始，用于报告失败的行
+	Line() int
+}
+
+// ReadError 读文件本身出错了，比如某一行太长或者磁盘读失败，要停止导入
+type ReadError struct {
+	Line int
+	Err  error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("读取第 %d 行失败: %v", e.Line, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// 一行最长多少字节，课评内容可能很长
+const maxJSONLLineSize = 4 * 1024 * 1024
+
+type JSONLReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewJSONLReader(r io.Reader) *JSONLReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxJSONLLineSize)
+	return &JSONLReader{scanner: scanner}
+}
+
+func (r *JSONLReader) Next() (Record, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" {
+			continue
+		}
+		var rec Record
+		err := json.Unmarshal([]byte(text), &rec)
+		return rec, err
+	}
+	if err := r.scanner.Err(); err != nil {
+		// Scanner 出错之后不会再往下读，每次都返回同一个错误
+		return Record{}, &ReadError{Line: r.line + 1, Err: err}
+	}
+	return Record{}, io.EOF
+}
+
+func (r *JSONLReader) Line() int {
+	return r.line
+}
+
+// CSVReader 第一行是表头，列名和 JSONL 的字段名一致，列的顺序随意
+type CSVReader struct {
+	r       *csv.Reader
+	columns map[string]int
+	line    int
+}
+
+func NewCSVReader(r io.Reader) (*CSVReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("读取表头失败: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"publisher_id", "course_id", "star_rating", "status", "ctime", "utime"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("缺少列 %s", name)
+		}
+	}
+	return &CSVReader{r: cr, columns: columns, line: 1}, nil
+}
+
+func (r *CSVReader) Next() (Record, error) {
+	fields, err := r.r.Read()
+	if err == io.EOF {
+		return Record{}, err
+	}
+	r.line++
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		// 这一行的格式不对，后面的行还能接着读
+		return Record{}, err
+	}
+	if err != nil {
+		return Record{}, &ReadError{Line: r.line, Err: err}
+	}
+	p := csvRow{fields: fields, columns: r.columns}
+	rec := Record{
+		PublisherId:    p.int64("publisher_id"),
+		CourseId:       p.int64("course_id"),
+		CourseProperty: int32(p.int64("course_property")),
+		StarRating:     p.int64("star_rating"),
+		Content:        p.string("content"),
+		Status:         int32(p.int64("status")),
+		IsAnonymous:    p.bool("is_anonymous"),
+		Ctime:          p.int64("ctime"),
+		Utime:          p.int64("utime"),
+	}
+	return rec, p.err
+}
+
+func (r *CSVReader) Line() int {
+	return r.line
+}
+
+// csvRow 记下第一个解析错误，省得每一列都判断一次
+type csvRow struct {
+	fields  []string
+	columns map[string]int
+	err     error
+}
+
+func (p *csvRow) string(name string) string {
+	idx, ok := p.columns[name]
+	if !ok || idx >= len(p.fields) {
+		return ""
+	}
+	return p.fields[idx]
+}
+
+func (p *csvRow) int64(name string) int64 {
+	val := strings.TrimSpace(p.string(name))
+	if val == "" {
+		return 0
+	}
+	res, err := strconv.ParseInt(val, 10, 64)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("列 %s 不是整数: %q", name, val)
+	}
+	return res
+}
+
+func (p *csvRow) bool(name string) bool {
+	val := strings.TrimSpace(p.string(name))
+	if val == "" {
+		return false
+	}
+	res, err := strconv.ParseBool(val)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("列 %s 不是布尔值: %q", name, val)
+	}
+	return res
+}
@@ -0,0 +1,141 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/pkg/hotkey"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"time"
+)
+
+// InitEvaluationCache 没有配置redis的时候用进程内的缓存，只适合单机部署
+func InitEvaluationCache(client redis.UniversalClient, l logger.Logger) cache.EvaluationCache {
+	if !viper.IsSet("redis") {
+		size := 100000
+		if viper.IsSet("memory.compositeScore.size") {
+			size = viper.GetInt("memory.compositeScore.size")
+		}
+		return cache.NewMemoryEvaluationCache(size)
+	}
+	type Config struct {
+		Window    time.Duration `yaml:"window"`
+		Threshold int64         `yaml:"threshold"`
+		LocalTTL  time.Duration `yaml:"localTTL"`
+	}
+	cfg := Config{
+		Window:    time.Second,
+		Threshold: 500,
+		LocalTTL:  time.Second * 3,
+	}
+	err := viper.UnmarshalKey("hotkey.compositeScore", &cfg)
+	if err != nil {
+		panic(err)
+	}
+	detector := hotkey.NewDetector(cfg.Window, cfg.Threshold)
+	return cache.NewHotKeyEvaluationCache(cache.NewRedisEvaluationCache(client), detector, cfg.LocalTTL, client,
+		prometheus.DefaultRegisterer, l)
+}
+
+func InitRecentEvaluationCache(cmd redis.Cmdable) cache.RecentEvaluationCache {
+	if !viper.IsSet("redis") {
+		return cache.NewMemoryRecentEvaluationCache()
+	}
+	return cache.NewRedisRecentEvaluationCache(cmd)
+}
+
+func InitEvaluationCountCache(cmd redis.Cmdable) cache.EvaluationCountCache {
+	if !viper.IsSet("redis") {
+		return cache.NewMemoryEvaluationCountCache(100000)
+	}
+	return cache.NewRedisEvaluationCountCache(cmd)
+}
+
+func InitEvaluationDetailCache(client redis.UniversalClient, l logger.Logger) cache.EvaluationDetailCache {
+	if !viper.IsSet("redis") {
+		// 只有一个实例，不需要失效广播
+		return cache.NewLocalEvaluationDetailCache(100000)
+	}
+	// 本地只放最热的一小部分课评
+	local := cache.NewLocalEvaluationDetailCache(10000)
+	remote := cache.NewRedisEvaluationDetailCache(client)
+	return cache.NewTwoLevelEvaluationDetailCache(local, remote, client, l)
+}
+
+func InitPrimaryStickyCache(cmd redis.Cmdable) cache.PrimaryStickyCache {
+	// 要比从库的复制延迟长
+	window := time.Second * 3
+	if viper.IsSet("mysql.stickyWindow") {
+		window = viper.GetDuration("mysql.stickyWindow")
+	}
+	if !viper.IsSet("redis") {
+		return cache.NewMemoryPrimaryStickyCache(window)
+	}
+	return cache.NewRedisPrimaryStickyCache(cmd, window)
+}
+
+func InitCourseBloomFilter(cmd redis.Cmdable, l logger.Logger) cache.CourseBloomFilter {
+	cfg := bloomConfigOf("course", 100000)
+	if !viper.IsSet("redis") {
+		return cache.NewMemoryBloomFilter(cfg.N, cfg.P)
+	}
+	return cache.NewRedisBloomFilter(cmd, "course", cfg.N, cfg.P, l)
+}
+
+func InitEvaluationBloomFilter(cmd redis.Cmdable, l logger.Logger) cache.EvaluationBloomFilter {
+	cfg := bloomConfigOf("evaluation", 1000000)
+	if !viper.IsSet("redis") {
+		return cache.NewMemoryBloomFilter(cfg.N, cfg.P)
+	}
+	return cache.NewRedisBloomFilter(cmd, "evaluation", cfg.N, cfg.P, l)
+}
+
+type bloomConfig struct {
+	N uint    `yaml:"n"`
+	P float64 `yaml:"p"`
+}
+
+// bloomConfigOf 管理员命令和服务的位图必须一样大，读同一份配置
+func bloomConfigOf(name string, n uint) bloomConfig {
+	cfg := bloomConfig{N: n, P: 0.001}
+	err := viper.UnmarshalKey("bloom."+name, &cfg)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// 下面是管理员命令用的缓存，命令跑完就退出，不需要本地缓存、热点探测和各种后台goroutine，
+// 但是写完数据要和服务一样删掉redis里面的缓存，并通知正在运行的实例。
+// 没有配置redis的时候管理员命令没法通知正在运行的服务，跑完命令要重启服务，否则最多要等缓存过期
+
+func InitAdminEvaluationCache(client redis.UniversalClient) cache.EvaluationCache {
+	if !viper.IsSet("redis") {
+		return cache.NewMemoryEvaluationCache(1)
+	}
+	return cache.NewPublishingEvaluationCache(cache.NewRedisEvaluationCache(client), client)
+}
+
+func InitAdminEvaluationDetailCache(client redis.UniversalClient) cache.EvaluationDetailCache {
+	if !viper.IsSet("redis") {
+		return cache.NewLocalEvaluationDetailCache(1)
+	}
+	return cache.NewPublishingEvaluationDetailCache(cache.NewRedisEvaluationDetailCache(client), client)
+}
+
+func InitAdminCourseBloomFilter(cmd redis.Cmdable, l logger.Logger) cache.CourseBloomFilter {
+	cfg := bloomConfigOf("course", 100000)
+	if !viper.IsSet("redis") {
+		return cache.NewMemoryBloomFilter(cfg.N, cfg.P)
+	}
+	return cache.NewRemoteRedisBloomFilter(cmd, "course", cfg.N, cfg.P, l)
+}
+
+func InitAdminEvaluationBloomFilter(cmd redis.Cmdable, l logger.Logger) cache.EvaluationBloomFilter {
+	cfg := bloomConfigOf("evaluation", 1000000)
+	if !viper.IsSet("redis") {
+		return cache.NewMemoryBloomFilter(cfg.N, cfg.P)
+	}
+	return cache.NewRemoteRedisBloomFilter(cmd, "evaluation", cfg.N, cfg.P, l)
+}
@@ -3,31 +3,155 @@ package ioc
 import (
 	"context"
 	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/MuxiKeStack/be-evaluation/client"
+	"github.com/MuxiKeStack/be-evaluation/fixture"
+	"github.com/MuxiKeStack/be-evaluation/pkg/grpcx"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"github.com/MuxiKeStack/be-evaluation/service"
+	aegis "github.com/go-kratos/aegis/circuitbreaker"
+	"github.com/go-kratos/aegis/circuitbreaker/sre"
 	"github.com/go-kratos/kratos/contrib/registry/etcd/v2"
+	"github.com/go-kratos/kratos/v2/middleware/circuitbreaker"
 	"github.com/go-kratos/kratos/v2/transport/grpc"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"time"
 )
 
-func InitCourseClient(ecli *clientv3.Client) coursev1.CourseServiceClient {
+// InitCourseClient 配置了 fixture 就从文件里面读课程，不连课程服务；
+// 没有etcd的时候 endpoint 要配置成课程服务的地址
+func InitCourseClient(ecli *clientv3.Client, courseCache cache.CourseCache, l logger.Logger) coursev1.CourseServiceClient {
+	type Breaker struct {
+		// 窗口里面成功率低于这个值开始按比例拒绝请求
+		Success float64       `yaml:"success"`
+		Request int64         `yaml:"request"`
+		Window  time.Duration `yaml:"window"`
+	}
 	type Config struct {
 		Endpoint string `yaml:"endpoint"`
+		Fixture  string `yaml:"fixture"`
+		// 一次调用的总超时，包括重试
+		Timeout time.Duration                 `yaml:"timeout"`
+		Methods map[string]grpcx.MethodPolicy `yaml:"methods"`
+		Backoff grpcx.RetryBackoff            `yaml:"backoff"`
+		Breaker Breaker                       `yaml:"breaker"`
+	}
+	// 课评服务只调用这两个只读的方法，都可以重试。viper会把key转成小写，默认值也要写小写
+	cfg := Config{
+		Timeout: time.Second * 3,
+		Methods: map[string]grpcx.MethodPolicy{
+			"getdetailbyid": {Timeout: time.Millisecond * 500, Retries: 2},
+			"subscribed":    {Timeout: time.Millisecond * 500, Retries: 2},
+		},
+		Backoff: grpcx.RetryBackoff{Base: time.Millisecond * 50, Max: time.Millisecond * 500},
+		Breaker: Breaker{Success: 0.6, Request: 100, Window: time.Second * 3},
 	}
-	var cfg Config
 	err := viper.UnmarshalKey("grpc.client.course", &cfg)
 	if err != nil {
 		panic(err)
 	}
-	r := etcd.New(ecli)
-	cc, err := grpc.DialInsecure(context.Background(),
+	if cfg.Fixture != "" {
+		fixtureClient, er := fixture.NewCourseServiceClient(cfg.Fixture)
+		if er != nil {
+			panic(er)
+		}
+		return fixtureClient
+	}
+	opts := []grpc.ClientOption{
 		grpc.WithEndpoint(cfg.Endpoint),
-		grpc.WithDiscovery(r),
-		grpc.WithTimeout(10*time.Second), // TODO
-	)
+		grpc.WithTimeout(cfg.Timeout),
+		// 每一次尝试都经过熔断器，熔断之后不再重试
+		grpc.WithMiddleware(
+			grpcx.RetryClient(cfg.Methods, cfg.Backoff),
+			circuitbreaker.Client(circuitbreaker.WithCircuitBreaker(func() aegis.CircuitBreaker {
+				return sre.NewBreaker(
+					sre.WithSuccess(cfg.Breaker.Success),
+					sre.WithRequest(cfg.Breaker.Request),
+					sre.WithWindow(cfg.Breaker.Window),
+				)
+			})),
+			grpcx.TimeoutClient(cfg.Methods),
+		),
+	}
+	if ecli != nil {
+		opts = append(opts, grpc.WithDiscovery(etcd.New(ecli)))
+	}
+	cc, err := grpc.DialInsecure(context.Background(), opts...)
 	if err != nil {
 		panic(err)
 	}
-	client := coursev1.NewCourseServiceClient(cc)
-	return client
+	// 课程性质定期核对的时候也会读到缓存，最多晚一个缓存过期时间
+	return client.NewCachedCourseServiceClient(coursev1.NewCourseServiceClient(cc), courseCache, l)
+}
+
+// InitCourseCache 没有配置redis的时候只用进程内的缓存
+func InitCourseCache(redisClient redis.UniversalClient, l logger.Logger) cache.CourseCache {
+	type Config struct {
+		LocalSize           int                         `yaml:"localSize"`
+		SubscribedLocalSize int                         `yaml:"subscribedLocalSize"`
+		Expiration          cache.CourseCacheExpiration `yaml:"expiration"`
+	}
+	cfg := Config{
+		LocalSize:           10000,
+		SubscribedLocalSize: 100000,
+		Expiration: cache.CourseCacheExpiration{
+			Course:       time.Minute * 10,
+			Subscribed:   time.Minute,
+			Unsubscribed: time.Second * 10,
+		},
+	}
+	err := viper.UnmarshalKey("courseCache", &cfg)
+	if err != nil {
+		panic(err)
+	}
+	local := cache.NewLocalCourseCache(cfg.LocalSize, cfg.SubscribedLocalSize, cfg.Expiration)
+	if !viper.IsSet("redis") {
+		return local
+	}
+	return cache.NewTwoLevelCourseCache(local, cache.NewRedisCourseCache(redisClient, cfg.Expiration), redisClient, l)
+}
+
+// InitAdminCourseCache 管理员命令只用进程内的缓存，不订阅其它实例的失效广播
+func InitAdminCourseCache() cache.CourseCache {
+	return cache.NewLocalCourseCache(100, 100, cache.CourseCacheExpiration{
+		Course:       time.Minute,
+		Subscribed:   time.Minute,
+		Unsubscribed: time.Second * 10,
+	})
+}
+
+// InitEvaluationService grpc.client.course.fallback 配置成 pending 的时候，
+// 课程服务不可用时提交的课评先收下来，等课程服务恢复之后再校验；
+// verification.mode 配置成 async 的时候，新提交的课评都先收下来，由后台任务校验
+func InitEvaluationService(repo repository.EvaluationRepository, pendingRepo repository.PendingEvaluationRepository,
+	courseClient coursev1.CourseServiceClient, validator service.EvaluationValidator) service.EvaluationService {
+	policy := service.CourseUnavailablePolicy(viper.GetString("grpc.client.course.fallback"))
+	switch policy {
+	case "":
+		policy = service.CourseUnavailableReject
+	case service.CourseUnavailableReject, service.CourseUnavailablePending:
+	default:
+		panic("不支持的课程服务降级策略 " + string(policy))
+	}
+	mode := service.VerificationMode(viper.GetString("verification.mode"))
+	switch mode {
+	case "":
+		mode = service.VerificationSync
+	case service.VerificationSync, service.VerificationAsync:
+	default:
+		panic("不支持的选课校验模式 " + string(mode))
+	}
+	return service.NewEvaluationService(repo, pendingRepo, courseClient, validator, policy, mode)
+}
+
+// InitEvaluationValidator validation.maxContentLength 课评内容最多多少个字
+func InitEvaluationValidator() service.EvaluationValidator {
+	maxContentLength := 2000
+	if viper.IsSet("validation.maxContentLength") {
+		maxContentLength = viper.GetInt("validation.maxContentLength")
+	}
+	return service.NewEvaluationValidator(maxContentLength)
 }
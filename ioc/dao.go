@@ -0,0 +1,69 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"github.com/ecodeclub/ekit/slice"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// InitEvaluationDAO 配置了分库就按课程分库；配置了迁移目标库就走双写，模式可以在运行时通过修改配置文件切换
+func InitEvaluationDAO(src *gorm.DB, dst DstDB, shards ShardDBs, idGen idgen.Generator, l logger.Logger) dao.EvaluationDAO {
+	if len(shards) > 0 {
+		if dst != nil {
+			panic("分库和在线迁移不能同时开启")
+		}
+		return dao.NewShardingEvaluationDAO(slice.Map(shards, func(idx int, db *gorm.DB) dao.EvaluationDAO {
+			return dao.NewGORMEvaluationDAO(db, idGen)
+		}), initShardRouter(len(shards)))
+	}
+	if dst == nil {
+		return dao.NewGORMEvaluationDAO(src, idGen)
+	}
+	res, err := dao.NewDoubleWriteDAO(dao.NewGORMEvaluationDAO(src, idGen), dao.NewGORMEvaluationDAO(dst, idGen),
+		viper.GetString("migrator.pattern"), l)
+	if err != nil {
+		panic(err)
+	}
+	viper.OnConfigChange(func(in fsnotify.Event) {
+		pattern := viper.GetString("migrator.pattern")
+		if pattern == res.Pattern() {
+			return
+		}
+		if er := res.UpdatePattern(pattern); er != nil {
+			// 配错了保持原来的模式
+			l.Error("切换双写模式失败", logger.Error(er), logger.String("pattern", pattern))
+			return
+		}
+		l.Info("切换双写模式", logger.String("pattern", pattern))
+	})
+	return res
+}
+
+// initShardRouter sharding.slots 按槽位的顺序配置分片号，不配置的时候槽位按分片数取模
+func initShardRouter(shards int) *dao.ShardRouter {
+	var slots []int64
+	if err := viper.UnmarshalKey("sharding.slots", &slots); err != nil {
+		panic(err)
+	}
+	router, err := dao.NewShardRouter(shards, slots)
+	if err != nil {
+		panic(err)
+	}
+	return router
+}
+
+func InitEvaluationEventDAO(src *gorm.DB, dst DstDB, shards ShardDBs) dao.EvaluationEventDAO {
+	if len(shards) > 0 {
+		return dao.NewShardingEvaluationEventDAO(slice.Map(shards, func(idx int, db *gorm.DB) dao.EvaluationEventDAO {
+			return dao.NewGORMEvaluationEventDAO(db)
+		}))
+	}
+	if dst == nil {
+		return dao.NewGORMEvaluationEventDAO(src)
+	}
+	return dao.NewDoubleWriteEvaluationEventDAO(dao.NewGORMEvaluationEventDAO(src), dao.NewGORMEvaluationEventDAO(dst))
+}
@@ -0,0 +1,23 @@
+package ioc
+
+import (
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+)
+
+func TestInitShardRouter(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	// 槽位5搬到了分片1，其余的槽位都在分片0
+	slots := strings.Repeat("0,", 5) + "1" + strings.Repeat(",0", 58)
+	err := viper.ReadConfig(strings.NewReader("sharding:\n  slots: [" + slots + "]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initShardRouter(2)
+	if router.Course(5) != 1 || router.Course(6) != 0 {
+		t.Fatalf("课程5在分片 %d，课程6在分片 %d", router.Course(5), router.Course(6))
+	}
+}
@@ -3,22 +3,60 @@ package ioc
 import (
 	"context"
 	"database/sql"
-	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/pkg/gormx"
 	"github.com/MuxiKeStack/be-evaluation/pkg/limiter"
 	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
 	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"github.com/glebarez/sqlite"
+	"github.com/seata/seata-go/pkg/client"
 	sql2 "github.com/seata/seata-go/pkg/datasource/sql"
 	"github.com/spf13/viper"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	glogger "gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
 	"time"
 )
 
+// InitDB db.driver 选择数据库，默认mysql。
+// mysql.mode 配置成 AT 的时候通过seata的AT数据源连接，写操作可以加入调用方开启的全局事务
 func InitDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
+	switch viper.GetString("db.driver") {
+	case "postgres":
+		return InitPostgresDB(l, lm)
+	case "sqlite":
+		return InitSQLiteDB(l, lm)
+	}
+	if viper.GetString("mysql.mode") == "AT" {
+		return InitATMysqlDB(l, lm)
+	}
 	return InitMysqlDB(l, lm)
 }
 
+func InitPostgresDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
+	type Config struct {
+		DSN string `yaml:"dsn"`
+	}
+	var cfg Config
+	if err := viper.UnmarshalKey("postgres", &cfg); err != nil {
+		panic(err)
+	}
+	return openDB(postgres.Open(cfg.DSN), l, lm)
+}
+
+// InitSQLiteDB 本地开发用，不需要装数据库
+func InitSQLiteDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
+	type Config struct {
+		DSN string `yaml:"dsn"`
+	}
+	cfg := Config{DSN: "file:kstack.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"}
+	if err := viper.UnmarshalKey("sqlite", &cfg); err != nil {
+		panic(err)
+	}
+	return openDB(sqlite.Open(cfg.DSN), l, lm)
+}
+
 func InitMysqlDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
 	type Config struct {
 		DSN string `yaml:"dsn"`
@@ -27,7 +65,117 @@ func InitMysqlDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
 	if err := viper.UnmarshalKey("mysql", &cfg); err != nil {
 		panic(err)
 	}
-	db, err := gorm.Open(mysql.Open(cfg.DSN), &gorm.Config{
+	db := openMysqlDB(cfg.DSN, l, lm)
+	useReplicas(db, cfg.DSN, mysqlReplicaConfig(), l)
+	return db
+}
+
+type replicaConfig struct {
+	// 从库，不配置的时候读写都在主库上
+	Replicas []string `yaml:"replicas"`
+	// 从库探活的间隔
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval"`
+}
+
+func mysqlReplicaConfig() replicaConfig {
+	cfg := replicaConfig{HealthCheckInterval: time.Second * 5}
+	if err := viper.UnmarshalKey("mysql", &cfg); err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// useReplicas 配置了从库就把读请求分到从库上，从库只读，不需要走AT数据源
+func useReplicas(db *gorm.DB, primaryDSN string, cfg replicaConfig, l logger.Logger) {
+	if len(cfg.Replicas) == 0 {
+		return
+	}
+	replicas := make([]*sql.DB, 0, len(cfg.Replicas))
+	dialectors := make([]gorm.Dialector, 0, len(cfg.Replicas)+1)
+	for _, dsn := range cfg.Replicas {
+		r, err := sql.Open("mysql", dsn)
+		if err != nil {
+			panic(err)
+		}
+		replicas = append(replicas, r)
+		dialectors = append(dialectors, mysql.New(mysql.Config{Conn: r}))
+	}
+	// 从库全挂了的时候读主库，单独一个连接池，不和写抢连接
+	fallback, err := sql.Open("mysql", primaryDSN)
+	if err != nil {
+		panic(err)
+	}
+	dialectors = append(dialectors, mysql.New(mysql.Config{Conn: fallback}))
+	policy := gormx.NewHealthyReplicaPolicy(replicas, fallback, l)
+	err = db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: dialectors,
+		Policy:   policy,
+	}))
+	if err != nil {
+		panic(err)
+	}
+	policy.Start(cfg.HealthCheckInterval)
+}
+
+// DstDB 在线迁移的目标库，没有在迁移的时候是nil
+type DstDB *gorm.DB
+
+func InitDstDB(l logger.Logger, lm limiter.Limiter) DstDB {
+	type Config struct {
+		DSN string `yaml:"dsn"`
+	}
+	var cfg Config
+	if err := viper.UnmarshalKey("migrator.dst", &cfg); err != nil {
+		panic(err)
+	}
+	if cfg.DSN == "" {
+		return nil
+	}
+	return openMysqlDB(cfg.DSN, l, lm)
+}
+
+// ShardDBs 分库之后的各个分片，按课程的槽位路由，没有分库的时候是nil
+type ShardDBs []*gorm.DB
+
+// InitShardDBs 每个分片可以有自己的从库，读请求和主库一样分到健康的从库上
+func InitShardDBs(l logger.Logger, lm limiter.Limiter) ShardDBs {
+	type Shard struct {
+		DSN      string   `yaml:"dsn"`
+		Replicas []string `yaml:"replicas"`
+	}
+	type Config struct {
+		// 顺序就是分片号，只能在末尾追加，追加之后改 sharding.slots 把槽位搬过去
+		Shards []Shard `yaml:"shards"`
+		// 各个分片的从库探活的间隔
+		HealthCheckInterval time.Duration `yaml:"healthCheckInterval"`
+	}
+	cfg := Config{HealthCheckInterval: time.Second * 5}
+	if err := viper.UnmarshalKey("sharding", &cfg); err != nil {
+		panic(err)
+	}
+	if len(cfg.Shards) == 0 {
+		return nil
+	}
+	res := make(ShardDBs, 0, len(cfg.Shards))
+	for _, shard := range cfg.Shards {
+		db := openMysqlDB(shard.DSN, l, lm)
+		useReplicas(db, shard.DSN, replicaConfig{
+			Replicas:            shard.Replicas,
+			HealthCheckInterval: cfg.HealthCheckInterval,
+		}, l)
+		res = append(res, db)
+	}
+	return res
+}
+
+func openMysqlDB(dsn string, l logger.Logger, lm limiter.Limiter) *gorm.DB {
+	return openDB(mysql.Open(dsn), l, lm)
+}
+
+func openDB(dialector gorm.Dialector, l logger.Logger, lm limiter.Limiter) *gorm.DB {
+	db, err := gorm.Open(dialector, &gorm.Config{
+		// 唯一键冲突统一翻译成 gorm.ErrDuplicatedKey，dao不用关心是哪个数据库
+		TranslateError: true,
 		Logger: glogger.New(gormLoggerFunc(l.Debug), glogger.Config{
 			SlowThreshold: 0,
 			LogLevel:      glogger.Info, // 以Debug模式打印所有Info级别能产生的gorm日志
@@ -46,7 +194,7 @@ func InitMysqlDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
 		ok, er := lm.Limit(ctx, "kstack:evaluation:sql-gorm:query")
 		if er == nil && ok {
 			// 触发限流
-			_ = d.AddError(evaluationv1.ErrorGormTooManyRequest("GORM-SQL请求限流"))
+			_ = d.AddError(dao.ErrTooManyRequests)
 			return
 		}
 		if er != nil {
@@ -59,6 +207,8 @@ func InitMysqlDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
 	return db
 }
 
+// InitATMysqlDB 全局事务里面的写会记录undo_log，全局回滚的时候由seata按undo_log恢复，
+// 课评和综合得分在同一个分支事务里面，一起提交一起回滚
 func InitATMysqlDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
 	type Config struct {
 		DSN string `yaml:"dsn"`
@@ -67,41 +217,20 @@ func InitATMysqlDB(l logger.Logger, lm limiter.Limiter) *gorm.DB {
 	if err := viper.UnmarshalKey("mysql", &cfg); err != nil {
 		panic(err)
 	}
+	// AT数据源要先连上seata
+	client.InitPath(viper.GetString("seata.configPath"))
 	sqlDB, err := sql.Open(sql2.SeataATMySQLDriver, cfg.DSN)
 	if err != nil {
 		panic(err)
 	}
-	db, err := gorm.Open(mysql.New(mysql.Config{
+	db := openDB(mysql.New(mysql.Config{
 		Conn: sqlDB,
-	}), &gorm.Config{
-		Logger: glogger.New(gormLoggerFunc(l.Debug), glogger.Config{
-			SlowThreshold: 0,
-			LogLevel:      glogger.Info, // 以Debug模式打印所有Info级别能产生的gorm日志
-		}),
-	})
-	if err != nil {
-		panic(err)
-	}
-	err = dao.InitTables(db)
-	if err != nil {
-		panic(err)
-	}
-	err = db.Callback().Query().Before("*").Register("RateLimitGormMiddleware", func(d *gorm.DB) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-		defer cancel()
-		ok, er := lm.Limit(ctx, "kstack:evaluation:sql-gorm:query")
-		if er == nil && ok {
-			// 触发限流
-			_ = d.AddError(evaluationv1.ErrorGormTooManyRequest("GORM-SQL请求限流"))
-			return
-		}
-		if er != nil {
-			l.Error("限流失败", logger.Error(er))
-		}
-	})
+	}), l, lm)
+	err = dao.InitUndoLogTable(db)
 	if err != nil {
 		panic(err)
 	}
+	useReplicas(db, cfg.DSN, mysqlReplicaConfig(), l)
 	return db
 }
 
@@ -5,7 +5,11 @@ import (
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+// InitEtcdClient 没有配置etcd的时候返回nil，服务不注册，课程服务直连，只适合单机开发
 func InitEtcdClient() *clientv3.Client {
+	if !viper.IsSet("etcd") {
+		return nil
+	}
 	var cfg clientv3.Config
 	err := viper.UnmarshalKey("etcd", &cfg)
 	if err != nil {
@@ -0,0 +1,19 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"github.com/MuxiKeStack/be-evaluation/service"
+	"os"
+)
+
+// ExportPseudonymKeyEnv 匿名课评发布者化名用的密钥，是机密，不放在配置文件里面
+const ExportPseudonymKeyEnv = "EVALUATION_EXPORT_PSEUDONYM_KEY"
+
+func InitExportService(repo repository.EvaluationRepository, l logger.Logger) service.ExportService {
+	key := os.Getenv(ExportPseudonymKeyEnv)
+	if key == "" {
+		l.Warn("没有配置化名密钥，导出的匿名课评不带发布者", logger.String("env", ExportPseudonymKeyEnv))
+	}
+	return service.NewExportService(repo, []byte(key))
+}
@@ -9,10 +9,12 @@ import (
 	grpc2 "github.com/seata/seata-go/pkg/integration/grpc"
 	"github.com/spf13/viper"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"os"
 	"time"
 )
 
-func InitGRPCxKratosServer(evaluationServer *grpc.EvaluationServiceServer, ecli *clientv3.Client, l logger.Logger) grpcx.Server {
+func InitGRPCxKratosServer(evaluationServer *grpc.EvaluationServiceServer, exportServer *grpc.ExportServiceServer,
+	ecli *clientv3.Client, l logger.Logger) grpcx.Server {
 	type Config struct {
 		Name    string `yaml:"name"`
 		Weight  int    `yaml:"weight"`
@@ -26,13 +28,14 @@ func InitGRPCxKratosServer(evaluationServer *grpc.EvaluationServiceServer, ecli
 	}
 	server := kgrpc.NewServer(
 		kgrpc.Address(cfg.Addr),
-		kgrpc.Middleware(recovery.Recovery()),
+		kgrpc.Middleware(recovery.Recovery(), grpc.ErrorMapping()),
 		kgrpc.UnaryInterceptor(grpc2.ServerTransactionInterceptor),
 		kgrpc.Timeout(100*time.Second), // TODO
 	)
 	evaluationServer.Register(server)
 	return &grpcx.KratosServer{
 		Server:     server,
+		Admin:      initAdminServer(exportServer, l),
 		Name:       cfg.Name,
 		Weight:     cfg.Weight,
 		EtcdTTL:    time.Second * time.Duration(cfg.EtcdTTL),
@@ -40,3 +43,24 @@ func InitGRPCxKratosServer(evaluationServer *grpc.EvaluationServiceServer, ecli
 		L:          l,
 	}
 }
+
+// AdminTokenEnv 调用管理接口的令牌，是机密，不放在配置文件里面
+const AdminTokenEnv = "EVALUATION_ADMIN_TOKEN"
+
+// initAdminServer 导出课评这类管理接口单独监听，要带令牌才能调用，没有配置端口或者令牌的时候不提供
+func initAdminServer(exportServer *grpc.ExportServiceServer, l logger.Logger) *kgrpc.Server {
+	addr := viper.GetString("grpc.admin.addr")
+	token := os.Getenv(AdminTokenEnv)
+	if addr == "" || token == "" {
+		l.Warn("没有配置管理端口或者令牌，不提供管理接口", logger.String("env", AdminTokenEnv))
+		return nil
+	}
+	server := kgrpc.NewServer(
+		kgrpc.Address(addr),
+		kgrpc.Middleware(recovery.Recovery(), grpc.ErrorMapping()),
+		kgrpc.UnaryInterceptor(grpcx.TokenUnaryInterceptor(token)),
+		kgrpc.StreamInterceptor(grpcx.TokenStreamInterceptor(token)),
+	)
+	exportServer.Register(server)
+	return server
+}
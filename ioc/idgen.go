@@ -0,0 +1,38 @@
+package ioc
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/spf13/viper"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"time"
+)
+
+// InitIdGenerator 实例号通过etcd租约分配，实例不需要单独配置。
+// 没有etcd的时候只能单机部署，实例号固定
+func InitIdGenerator(client *clientv3.Client, l logger.Logger) idgen.Generator {
+	if client == nil {
+		gen, err := idgen.NewSnowflake(viper.GetInt64("idgen.workerId"))
+		if err != nil {
+			panic(err)
+		}
+		return gen
+	}
+	type Config struct {
+		Prefix string `yaml:"prefix"`
+		// 租约的秒数，实例挂掉之后要过这么久实例号才能被复用
+		LeaseTTL int64 `yaml:"leaseTTL"`
+	}
+	cfg := Config{Prefix: "/kstack/evaluation/idgen", LeaseTTL: 10}
+	if err := viper.UnmarshalKey("idgen", &cfg); err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	gen, err := idgen.NewEtcdSnowflake(ctx, client, cfg.Prefix, cfg.LeaseTTL, l)
+	if err != nil {
+		panic(err)
+	}
+	return gen
+}
@@ -0,0 +1,65 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/job"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"time"
+)
+
+func InitJobs(l logger.Logger, bloomBuildJob *job.BloomBuildJob, bloomJob *job.BloomRebuildJob, relayJob *job.EventRelayJob,
+	resyncJob *job.CoursePropertyResyncJob, pendingJob *job.PendingVerificationJob) *cron.Cron {
+	type Config struct {
+		BloomRebuild string `yaml:"bloomRebuild"`
+		EventRelay   string `yaml:"eventRelay"`
+		CourseResync string `yaml:"courseResync"`
+		// 课程服务不可用的时候收下的课评，隔多久补做一次选课校验
+		PendingVerification string `yaml:"pendingVerification"`
+	}
+	cfg := Config{
+		BloomRebuild:        "@every 6h",
+		EventRelay:          "@every 1s",
+		CourseResync:        "@every 24h",
+		PendingVerification: "@every 5s",
+	}
+	err := viper.UnmarshalKey("cron", &cfg)
+	if err != nil {
+		panic(err)
+	}
+	res := cron.New(cron.WithSeconds())
+	builder := job.NewCronJobBuilder(l)
+	_, err = res.AddJob(cfg.BloomRebuild, builder.Build(bloomJob))
+	if err != nil {
+		panic(err)
+	}
+	res.Schedule(&onceSchedule{}, builder.Build(bloomBuildJob))
+	// 上一轮还没投递完就跳过这一轮，避免同一个实例并发投递
+	_, err = res.AddJob(cfg.EventRelay, cron.NewChain(cron.SkipIfStillRunning(cron.DiscardLogger)).Then(builder.Build(relayJob)))
+	if err != nil {
+		panic(err)
+	}
+	_, err = res.AddJob(cfg.CourseResync, builder.Build(resyncJob))
+	if err != nil {
+		panic(err)
+	}
+	_, err = res.AddJob(cfg.PendingVerification, cron.NewChain(cron.SkipIfStillRunning(cron.DiscardLogger)).Then(builder.Build(pendingJob)))
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// onceSchedule cron 启动之后马上跑一次，之后再也不跑
+type onceSchedule struct {
+	done bool
+}
+
+func (s *onceSchedule) Next(t time.Time) time.Time {
+	if s.done {
+		// cron 不会运行下一次时间是零值的任务
+		return time.Time{}
+	}
+	s.done = true
+	return t
+}
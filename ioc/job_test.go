@@ -0,0 +1,17 @@
+package ioc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnceSchedule(t *testing.T) {
+	s := &onceSchedule{}
+	now := time.Now()
+	if got := s.Next(now); !got.Equal(now) {
+		t.Fatalf("第一次要马上跑: %v", got)
+	}
+	if got := s.Next(now); !got.IsZero() {
+		t.Fatalf("之后不再跑: %v", got)
+	}
+}
@@ -0,0 +1,56 @@
+package ioc
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/MuxiKeStack/be-evaluation/events"
+	"github.com/MuxiKeStack/be-evaluation/events/course"
+	"github.com/spf13/viper"
+)
+
+// InitSaramaClient 没有配置kafka的时候返回nil，事件只投递到内存，也不消费课程事件
+func InitSaramaClient() sarama.Client {
+	if !viper.IsSet("kafka") {
+		return nil
+	}
+	type Config struct {
+		Addrs []string `yaml:"addrs"`
+	}
+	var cfg Config
+	err := viper.UnmarshalKey("kafka", &cfg)
+	if err != nil {
+		panic(err)
+	}
+	scfg := sarama.NewConfig()
+	scfg.Producer.Return.Successes = true
+	scfg.Producer.RequiredAcks = sarama.WaitForAll
+	client, err := sarama.NewClient(cfg.Addrs, scfg)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func InitSyncProducer(client sarama.Client) sarama.SyncProducer {
+	if client == nil {
+		return nil
+	}
+	res, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func InitEventProducer(producer sarama.SyncProducer) events.Producer {
+	if producer == nil {
+		return events.NewMemoryProducer()
+	}
+	return events.NewSaramaSyncProducer(producer)
+}
+
+func InitConsumers(client sarama.Client, courseConsumer *course.CourseUpdatedConsumer) []events.Consumer {
+	if client == nil {
+		return nil
+	}
+	return []events.Consumer{courseConsumer}
+}
@@ -3,10 +3,14 @@ package ioc
 import (
 	"github.com/MuxiKeStack/be-evaluation/pkg/limiter"
 	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
 	"time"
 )
 
 func InitLimiter(cmd redis.Cmdable) limiter.Limiter {
 	// 最近一秒的内的请求不能多余以前
+	if !viper.IsSet("redis") {
+		return limiter.NewLocalSlideWindowLimiter(time.Second, 1000)
+	}
 	return limiter.NewRedisSlideWindowLimiter(cmd, time.Second, 1000)
 }
@@ -5,7 +5,11 @@ import (
 	"github.com/spf13/viper"
 )
 
-func InitRedis() redis.Cmdable {
+// InitRedis 没有配置redis的时候返回nil，所有用到redis的组件都换成进程内的实现，只适合单机部署
+func InitRedis() redis.UniversalClient {
+	if !viper.IsSet("redis") {
+		return nil
+	}
 	type Config struct {
 		Addr     string `yaml:"addr"`
 		Password string `yaml:"password"`
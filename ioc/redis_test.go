@@ -0,0 +1,34 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/pkg/limiter"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"github.com/spf13/viper"
+	"testing"
+)
+
+// 单机配置不能依赖redis
+func TestStandaloneWithoutRedis(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigFile("../config/standalone.yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	cmd := InitRedis()
+	if cmd != nil {
+		t.Fatal("单机配置不应该连redis")
+	}
+	if _, ok := InitLimiter(cmd).(*limiter.LocalSlideWindowLimiter); !ok {
+		t.Fatal("限流要用进程内的")
+	}
+	if _, ok := InitEvaluationDetailCache(cmd, nil).(*cache.LocalEvaluationDetailCache); !ok {
+		t.Fatal("课评详情要用进程内的缓存")
+	}
+	if _, ok := InitCourseBloomFilter(cmd, nil).(*cache.MemoryBloomFilter); !ok {
+		t.Fatal("布隆过滤器要用进程内的")
+	}
+	if _, ok := InitRecentEvaluationCache(cmd).(*cache.MemoryRecentEvaluationCache); !ok {
+		t.Fatal("时间线要用进程内的缓存")
+	}
+}
@@ -0,0 +1,47 @@
+package job
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"time"
+)
+
+// BloomRebuildJob 定期从数据库重建布隆过滤器，清掉已经删除的id，也修正运行中漏加的id
+type BloomRebuildJob struct {
+	repo    repository.EvaluationRepository
+	timeout time.Duration
+}
+
+func NewBloomRebuildJob(repo repository.EvaluationRepository) *BloomRebuildJob {
+	return &BloomRebuildJob{repo: repo, timeout: time.Minute * 5}
+}
+
+func (j *BloomRebuildJob) Name() string {
+	return "bloom_rebuild"
+}
+
+func (j *BloomRebuildJob) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+	return j.repo.RebuildBloomFilters(ctx)
+}
+
+// BloomBuildJob 启动的时候建好还不存在的布隆过滤器，否则要等到第一次定期重建才能挡住不存在的id
+type BloomBuildJob struct {
+	repo    repository.EvaluationRepository
+	timeout time.Duration
+}
+
+func NewBloomBuildJob(repo repository.EvaluationRepository) *BloomBuildJob {
+	return &BloomBuildJob{repo: repo, timeout: time.Minute * 5}
+}
+
+func (j *BloomBuildJob) Name() string {
+	return "bloom_build"
+}
+
+func (j *BloomBuildJob) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+	return j.repo.BuildBloomFiltersIfAbsent(ctx)
+}
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"testing"
+)
+
+type bloomRepo struct {
+	repository.EvaluationRepository
+	built int
+}
+
+func (r *bloomRepo) BuildBloomFiltersIfAbsent(ctx context.Context) error {
+	r.built++
+	return nil
+}
+
+func TestBloomBuildJob(t *testing.T) {
+	repo := &bloomRepo{}
+	if err := NewBloomBuildJob(repo).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if repo.built != 1 {
+		t.Fatalf("调用了 %d 次", repo.built)
+	}
+}
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/service"
+	"time"
+)
+
+// CoursePropertyResyncJob 定期向课程服务核对课程性质，修正消费事件时漏掉的课程
+type CoursePropertyResyncJob struct {
+	svc     service.CoursePropertyService
+	timeout time.Duration
+}
+
+func NewCoursePropertyResyncJob(svc service.CoursePropertyService) *CoursePropertyResyncJob {
+	return &CoursePropertyResyncJob{svc: svc, timeout: time.Hour}
+}
+
+func (j *CoursePropertyResyncJob) Name() string {
+	return "course_property_resync"
+}
+
+func (j *CoursePropertyResyncJob) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+	return j.svc.ResyncAll(ctx)
+}
@@ -0,0 +1,30 @@
+package job
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/robfig/cron/v3"
+	"time"
+)
+
+// CronJobBuilder 把 Job 适配成 cron 的任务，统一打日志
+type CronJobBuilder struct {
+	l logger.Logger
+}
+
+func NewCronJobBuilder(l logger.Logger) *CronJobBuilder {
+	return &CronJobBuilder{l: l}
+}
+
+func (b *CronJobBuilder) Build(job Job) cron.Job {
+	name := job.Name()
+	return cron.FuncJob(func() {
+		start := time.Now()
+		b.l.Debug("开始运行任务", logger.String("name", name))
+		err := job.Run()
+		if err != nil {
+			b.l.Error("运行任务失败", logger.Error(err), logger.String("name", name))
+		}
+		b.l.Debug("结束运行任务", logger.String("name", name),
+			logger.Int64("duration", time.Since(start).Milliseconds()))
+	})
+}
@@ -0,0 +1,38 @@
+package job
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"time"
+)
+
+// EventRelayJob 把发件箱里面的课评事件投递到消息队列
+type EventRelayJob struct {
+	repo      repository.EvaluationEventRepository
+	batchSize int
+	timeout   time.Duration
+}
+
+func NewEventRelayJob(repo repository.EvaluationEventRepository) *EventRelayJob {
+	return &EventRelayJob{repo: repo, batchSize: 100, timeout: time.Second * 30}
+}
+
+func (j *EventRelayJob) Name() string {
+	return "event_relay"
+}
+
+func (j *EventRelayJob) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+	// 一批满了说明还有积压，接着投递，直到清空或者超时
+	for ctx.Err() == nil {
+		n, err := j.repo.ProducePending(ctx, j.batchSize)
+		if err != nil {
+			return err
+		}
+		if n < j.batchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,37 @@
+package job
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/service"
+	"time"
+)
+
+// PendingVerificationJob 课程服务不可用的时候收下的课评，定期补做选课校验
+type PendingVerificationJob struct {
+	svc       service.PendingVerificationService
+	batchSize int
+	timeout   time.Duration
+}
+
+func NewPendingVerificationJob(svc service.PendingVerificationService) *PendingVerificationJob {
+	return &PendingVerificationJob{svc: svc, batchSize: 100, timeout: time.Second * 30}
+}
+
+func (j *PendingVerificationJob) Name() string {
+	return "pending_verification"
+}
+
+func (j *PendingVerificationJob) Run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+	for ctx.Err() == nil {
+		n, err := j.svc.VerifyDue(ctx, j.batchSize)
+		if err != nil {
+			return err
+		}
+		if n < j.batchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
@@ -1,15 +1,33 @@
 package main
 
 import (
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"net"
+	"net/http"
 )
 
 func main() {
 	initViper()
-	//client.InitPath("config/seatago.yaml")
-	server := InitGRPCServer()
-	err := server.Serve()
+	if runAdmin() {
+		return
+	}
+	app := InitApp()
+	initPrometheus(app.l)
+	for _, c := range app.consumers {
+		err := c.Start()
+		if err != nil {
+			panic(err)
+		}
+	}
+	app.cron.Start()
+	defer func() {
+		// 等待正在运行的任务结束
+		<-app.cron.Stop().Done()
+	}()
+	err := app.server.Serve()
 	if err != nil {
 		panic(err)
 	}
@@ -25,4 +43,33 @@ func initViper() {
 	if err != nil {
 		panic(err)
 	}
+	// 双写模式之类的配置需要在运行时生效
+	viper.WatchConfig()
+}
+
+// initPrometheus 在开始处理请求之前监听，端口被占用直接启动失败
+func initPrometheus(l logger.Logger) {
+	err := startPrometheus(viper.GetString("prometheus.addr"), l)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// startPrometheus 专门给 prometheus 用的端口，监听失败返回错误，之后停止服务记日志
+func startPrometheus(addr string, l logger.Logger) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go servePrometheus(lis, l)
+	return nil
+}
+
+func servePrometheus(lis net.Listener, l logger.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	err := http.Serve(lis, mux)
+	if err != nil {
+		l.Error("prometheus 端口停止服务", logger.Error(err), logger.String("addr", lis.Addr().String()))
+	}
 }
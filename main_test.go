@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"net"
+	"testing"
+)
+
+func TestStartPrometheus_AddrInUse(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if err = startPrometheus(lis.Addr().String(), logger.NewNopLogger()); err == nil {
+		t.Fatal("端口被占用的时候要返回错误")
+	}
+}
+
+// errorLogger 记下打了哪些错误日志
+type errorLogger struct {
+	logger.Logger
+	msgs []string
+}
+
+func (l *errorLogger) Error(msg string, args ...logger.Field) {
+	l.msgs = append(l.msgs, msg)
+}
+
+// 停止服务的错误要打到服务统一的日志里面
+func TestServePrometheus_LogError(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = lis.Close()
+	l := &errorLogger{Logger: logger.NewNopLogger()}
+	servePrometheus(lis, l)
+	if len(l.msgs) != 1 {
+		t.Fatalf("打了 %d 条错误日志", len(l.msgs))
+	}
+}
@@ -0,0 +1,66 @@
+package gormx
+
+import (
+	"context"
+	"database/sql"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"gorm.io/gorm"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// HealthyReplicaPolicy 只在健康的从库之间随机选，从库全都不健康的时候退回到 fallback。
+// fallback 一般是连主库的连接池，要作为最后一个从库注册进 dbresolver
+type HealthyReplicaPolicy struct {
+	replicas []*sql.DB
+	fallback *sql.DB
+	mu       sync.RWMutex
+	healthy  []gorm.ConnPool
+	l        logger.Logger
+}
+
+func NewHealthyReplicaPolicy(replicas []*sql.DB, fallback *sql.DB, l logger.Logger) *HealthyReplicaPolicy {
+	healthy := make([]gorm.ConnPool, 0, len(replicas))
+	for _, r := range replicas {
+		healthy = append(healthy, r)
+	}
+	return &HealthyReplicaPolicy{replicas: replicas, fallback: fallback, healthy: healthy, l: l}
+}
+
+func (p *HealthyReplicaPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.healthy) == 0 {
+		return p.fallback
+	}
+	return p.healthy[rand.IntN(len(p.healthy))]
+}
+
+// Start 定期探活，不健康的从库摘掉，恢复之后再加回来
+func (p *HealthyReplicaPolicy) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.check()
+		}
+	}()
+}
+
+func (p *HealthyReplicaPolicy) check() {
+	healthy := make([]gorm.ConnPool, 0, len(p.replicas))
+	for i, r := range p.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := r.PingContext(ctx)
+		cancel()
+		if err != nil {
+			p.l.Error("从库不可用", logger.Error(err), logger.Int64("replica", int64(i)))
+			continue
+		}
+		healthy = append(healthy, r)
+	}
+	p.mu.Lock()
+	p.healthy = healthy
+	p.mu.Unlock()
+}
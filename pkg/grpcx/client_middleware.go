@@ -0,0 +1,96 @@
+package grpcx
+
+import (
+	"context"
+	"errors"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/middleware/circuitbreaker"
+	"github.com/go-kratos/kratos/v2/transport"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"time"
+)
+
+// MethodPolicy 单个方法的调用策略，按方法名配置，不带服务前缀，全部小写，比如 getdetailbyid
+type MethodPolicy struct {
+	// 每一次尝试的超时，0表示只受整体超时限制
+	Timeout time.Duration `yaml:"timeout"`
+	// 失败之后最多重试几次，只能给幂等的方法配置
+	Retries int `yaml:"retries"`
+}
+
+// RetryBackoff 第n次重试前等待 Base*2^(n-1)，不超过Max，再加上最多一半的随机抖动
+type RetryBackoff struct {
+	Base time.Duration `yaml:"base"`
+	Max  time.Duration `yaml:"max"`
+}
+
+// TimeoutClient 按方法设置每一次尝试的超时，要放在 RetryClient 里面
+func TimeoutClient(policies map[string]MethodPolicy) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			p := policies[method(ctx)]
+			if p.Timeout <= 0 {
+				return handler(ctx, req)
+			}
+			ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+			defer cancel()
+			return handler(ctx, req)
+		}
+	}
+}
+
+// RetryClient 对方不可用或者超时的时候按策略重试，熔断器拒绝的请求不重试
+func RetryClient(policies map[string]MethodPolicy, backoff RetryBackoff) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			p := policies[method(ctx)]
+			reply, err := handler(ctx, req)
+			for i := 1; i <= p.Retries && IsUnavailable(err) && !errors.Is(err, circuitbreaker.ErrNotAllowed); i++ {
+				select {
+				case <-ctx.Done():
+					return reply, err
+				case <-time.After(backoff.wait(i)):
+				}
+				reply, err = handler(ctx, req)
+			}
+			return reply, err
+		}
+	}
+}
+
+// IsUnavailable 对方服务不可用、超时或者被熔断，换句话说是依赖的问题而不是请求本身的问题
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// 调用方自己取消的不算
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	return kerrors.IsServiceUnavailable(err) || kerrors.IsGatewayTimeout(err)
+}
+
+func (b RetryBackoff) wait(attempt int) time.Duration {
+	d := b.Base << (attempt - 1)
+	if d > b.Max || d <= 0 {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d + rand.N(d/2+1)
+}
+
+func method(ctx context.Context) string {
+	tr, ok := transport.FromClientContext(ctx)
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(path.Base(tr.Operation()))
+}
@@ -12,6 +12,8 @@ import (
 
 type KratosServer struct {
 	*grpc.Server
+	// Admin 管理接口单独监听，不注册到服务发现，可以是nil
+	Admin      *grpc.Server
 	Name       string
 	Weight     int
 	EtcdTTL    time.Duration
@@ -20,10 +22,9 @@ type KratosServer struct {
 	L          logger.Logger
 }
 
-// Serve 启动服务器并且阻塞
+// Serve 启动服务器并且阻塞，EtcdClient 是nil的时候不注册服务，调用方直连
 func (s *KratosServer) Serve() error {
-	r := etcd.New(s.EtcdClient, etcd.RegisterTTL(s.EtcdTTL))
-	app := kratos.New(
+	opts := []kratos.Option{
 		kratos.Metadata(map[string]string{
 			"weight": strconv.Itoa(s.Weight),
 		}),
@@ -31,16 +32,29 @@ func (s *KratosServer) Serve() error {
 		kratos.Server(
 			s.Server,
 		),
-		kratos.Registrar(r),
-	)
+	}
+	if s.Admin != nil {
+		// 只注册业务端口，管理端口不能通过服务发现找到
+		endpoint, err := s.Server.Endpoint()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, kratos.Server(s.Server, s.Admin), kratos.Endpoint(endpoint))
+	}
+	if s.EtcdClient != nil {
+		opts = append(opts, kratos.Registrar(etcd.New(s.EtcdClient, etcd.RegisterTTL(s.EtcdTTL))))
+	}
+	app := kratos.New(opts...)
 	s.stop = app.Stop
 	return app.Run()
 }
 
 func (s *KratosServer) Close() error {
-	err := s.EtcdClient.Close()
-	if err != nil {
-		return err
+	if s.EtcdClient != nil {
+		err := s.EtcdClient.Close()
+		if err != nil {
+			return err
+		}
 	}
 	return s.stop()
 }
@@ -0,0 +1,58 @@
+package grpcx
+
+import (
+	"context"
+	"crypto/subtle"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+const (
+	authorizationKey = "authorization"
+	bearerPrefix     = "Bearer "
+)
+
+// ErrUnauthenticated 没有带令牌或者令牌不对
+var ErrUnauthenticated = kerrors.Unauthorized("UNAUTHENTICATED", "令牌不正确")
+
+// TokenUnaryInterceptor 管理接口的鉴权，metadata 里面的 authorization 要是 "Bearer <token>"
+func TokenUnaryInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !checkToken(ctx, token) {
+			return nil, ErrUnauthenticated
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TokenStreamInterceptor 同 TokenUnaryInterceptor，给流式接口用
+func TokenStreamInterceptor(token string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !checkToken(ss.Context(), token) {
+			return ErrUnauthenticated
+		}
+		return handler(srv, ss)
+	}
+}
+
+// WithToken 调用管理接口的客户端用这个带上令牌
+func WithToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, authorizationKey, bearerPrefix+token)
+}
+
+func checkToken(ctx context.Context, token string) bool {
+	if token == "" {
+		// 没有配置令牌的时候谁都不能调用，不能因为漏配置就放开
+		return false
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(authorizationKey) {
+		got, ok := strings.CutPrefix(v, bearerPrefix)
+		if ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
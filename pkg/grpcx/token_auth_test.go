@@ -0,0 +1,58 @@
+package grpcx
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+type tokenStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tokenStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestTokenStreamInterceptor(t *testing.T) {
+	testCases := []struct {
+		name   string
+		token  string
+		header []string
+		wantOK bool
+	}{
+		{name: "令牌正确", token: "t0ken", header: []string{"authorization", "Bearer t0ken"}, wantOK: true},
+		{name: "令牌错误", token: "t0ken", header: []string{"authorization", "Bearer t0kem"}},
+		{name: "没有Bearer前缀", token: "t0ken", header: []string{"authorization", "t0ken"}},
+		{name: "没有带令牌", token: "t0ken"},
+		{name: "服务端没有配置令牌", header: []string{"authorization", "Bearer "}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tc.header...))
+			called := false
+			err := TokenStreamInterceptor(tc.token)(nil, &tokenStream{ctx: ctx}, &grpc.StreamServerInfo{},
+				func(srv any, stream grpc.ServerStream) error {
+					called = true
+					return nil
+				})
+			if called != tc.wantOK {
+				t.Fatalf("handler 调用了吗: %v，应该是 %v", called, tc.wantOK)
+			}
+			if !tc.wantOK && err != ErrUnauthenticated {
+				t.Fatalf("错误是 %v，应该是 %v", err, ErrUnauthenticated)
+			}
+		})
+	}
+}
+
+func TestWithToken(t *testing.T) {
+	ctx := WithToken(context.Background(), "t0ken")
+	md, _ := metadata.FromOutgoingContext(ctx)
+	// 客户端带上的令牌服务端能认出来
+	if !checkToken(metadata.NewIncomingContext(context.Background(), md), "t0ken") {
+		t.Fatalf("令牌没有通过校验: %v", md)
+	}
+}
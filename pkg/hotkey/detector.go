@@ -0,0 +1,101 @@
+package hotkey
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const shardCnt = 32
+
+// Detector 在进程内统计每个key在最近一个窗口内的访问次数，超过阈值就认为是热点。
+// 用前后两个固定窗口加权来近似滑动窗口，每个key只需要常数大小的内存
+type Detector struct {
+	window    time.Duration
+	threshold int64
+	shards    [shardCnt]shard
+}
+
+type shard struct {
+	mu       sync.Mutex
+	counters map[string]*counter
+}
+
+type counter struct {
+	start int64 // 当前窗口的开始时间，纳秒
+	cur   int64
+	prev  int64
+}
+
+func NewDetector(window time.Duration, threshold int64) *Detector {
+	d := &Detector{window: window, threshold: threshold}
+	for i := range d.shards {
+		d.shards[i].counters = make(map[string]*counter)
+	}
+	return d
+}
+
+// Hit 记录一次访问，返回这个key现在是不是热点
+func (d *Detector) Hit(key string) bool {
+	now := time.Now().UnixNano()
+	s := d.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok {
+		c = &counter{start: now}
+		s.counters[key] = c
+	}
+	d.roll(c, now)
+	c.cur++
+	return d.estimate(c, now) >= d.threshold
+}
+
+// HotKeys 返回当前所有热点key和它们在一个窗口内的估计访问量，顺便清理已经冷掉的计数器
+func (d *Detector) HotKeys() map[string]int64 {
+	now := time.Now().UnixNano()
+	res := make(map[string]int64)
+	for i := range d.shards {
+		s := &d.shards[i]
+		s.mu.Lock()
+		for key, c := range s.counters {
+			d.roll(c, now)
+			cnt := d.estimate(c, now)
+			switch {
+			case cnt >= d.threshold:
+				res[key] = cnt
+			case c.cur == 0 && c.prev == 0:
+				delete(s.counters, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return res
+}
+
+func (d *Detector) roll(c *counter, now int64) {
+	window := d.window.Nanoseconds()
+	elapsed := now - c.start
+	switch {
+	case elapsed < window:
+	case elapsed < 2*window:
+		c.prev, c.cur = c.cur, 0
+		c.start += window
+	default:
+		// 中间空了一整个窗口以上，之前的计数都作废
+		c.prev, c.cur = 0, 0
+		c.start = now
+	}
+}
+
+func (d *Detector) estimate(c *counter, now int64) int64 {
+	window := d.window.Nanoseconds()
+	// 上一个窗口按照还落在滑动窗口里的比例计入
+	return c.prev*(window-(now-c.start))/window + c.cur
+}
+
+func (d *Detector) shard(key string) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &d.shards[h.Sum32()%shardCnt]
+}
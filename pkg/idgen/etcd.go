@@ -0,0 +1,168 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// leaseSafetyMargin 本地算出来的租约到期时间提前这么久，留给续约请求在路上的时间和两边时钟的误差
+const leaseSafetyMargin = time.Second
+
+var (
+	ErrNoWorkerId        = errors.New("实例号租约已经失效，暂时不能生成id")
+	ErrWorkerIdExhausted = errors.New("实例号已经全部被占用")
+)
+
+// EtcdSnowflake 实例号通过etcd租约分配，实例挂了租约过期之后实例号可以被别的实例复用。
+// 续约失败就立刻停止生成id，因为别的实例可能已经拿到了同一个实例号。
+// 续约的响应可能迟迟不来，所以本地还记着租约最晚什么时候到期，到期之前就停止生成id
+type EtcdSnowflake struct {
+	client *clientv3.Client
+	prefix string
+	// 租约的秒数
+	ttl int64
+	l   logger.Logger
+	// nil表示当前没有可用的实例号
+	sf atomic.Pointer[Snowflake]
+	// 本地估计的租约到期时间，UnixNano
+	deadline atomic.Int64
+}
+
+func NewEtcdSnowflake(ctx context.Context, client *clientv3.Client, prefix string, ttl int64,
+	l logger.Logger) (*EtcdSnowflake, error) {
+	g := &EtcdSnowflake{client: client, prefix: prefix, ttl: ttl, l: l}
+	return g, g.acquire(ctx)
+}
+
+func (g *EtcdSnowflake) Next(ctx context.Context) (int64, error) {
+	return g.NextTagged(ctx, 0)
+}
+
+func (g *EtcdSnowflake) NextTagged(ctx context.Context, tag int64) (int64, error) {
+	sf := g.sf.Load()
+	if sf == nil || time.Now().UnixNano() >= g.deadline.Load() {
+		return 0, ErrNoWorkerId
+	}
+	return sf.NextTagged(ctx, tag)
+}
+
+// renewed 租约在 at 的时候还有 ttl 秒，提前 leaseSafetyMargin 当作到期
+func (g *EtcdSnowflake) renewed(at time.Time, ttl int64) {
+	g.deadline.Store(at.Add(time.Duration(ttl)*time.Second - leaseSafetyMargin).UnixNano())
+}
+
+// acquire 从小到大找一个没人占用的实例号，用租约占住。没有拿到实例号就撤销租约，否则租约会一直挂到过期
+func (g *EtcdSnowflake) acquire(ctx context.Context) (err error) {
+	start := time.Now()
+	lease, err := g.client.Grant(ctx, g.ttl)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// ctx可能已经超时了，换一个
+		rctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, er := g.client.Revoke(rctx, lease.ID); er != nil {
+			g.l.Error("撤销实例号租约失败", logger.Error(er), logger.Int64("leaseId", int64(lease.ID)))
+		}
+	}()
+	for workerId := int64(0); workerId <= MaxWorkerId; workerId++ {
+		key := g.workerKey(workerId)
+		resp, err := g.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, "", clientv3.WithLease(lease.ID))).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if !resp.Succeeded {
+			continue
+		}
+		lastTs, err := g.loadLastTs(ctx, workerId)
+		if err != nil {
+			return err
+		}
+		if lastTs-time.Now().UnixMilli() > maxClockBackwards {
+			// 上一个使用者的时钟比本机快太多，用这个实例号要等很久，换一个
+			_, err = g.client.Delete(ctx, key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		sf, err := newSnowflake(workerId, lastTs)
+		if err != nil {
+			return err
+		}
+		ch, err := g.client.KeepAlive(context.Background(), lease.ID)
+		if err != nil {
+			return err
+		}
+		g.renewed(start, lease.TTL)
+		g.sf.Store(sf)
+		g.l.Info("获得实例号", logger.Int64("workerId", workerId))
+		go g.keepAlive(ch, sf)
+		return nil
+	}
+	return ErrWorkerIdExhausted
+}
+
+func (g *EtcdSnowflake) keepAlive(ch <-chan *clientv3.LeaseKeepAliveResponse, sf *Snowflake) {
+	for resp := range ch {
+		// 响应收到的时候续约请求已经发出去一会了，renewed 里面留了余量
+		g.renewed(time.Now(), resp.TTL)
+		// 顺带记下最后生成id的时间，实例号被别的实例接手的时候据此判断它的时钟是不是落后了
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := g.saveLastTs(ctx, sf.workerId, sf.LastTs())
+		cancel()
+		if err != nil {
+			g.l.Error("记录实例号时间戳失败", logger.Error(err), logger.Int64("workerId", sf.workerId))
+		}
+	}
+	g.sf.CompareAndSwap(sf, nil)
+	g.l.Error("实例号租约失效，重新申请", logger.Int64("workerId", sf.workerId))
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		err := g.acquire(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+		g.l.Error("申请实例号失败", logger.Error(err))
+		time.Sleep(time.Second)
+	}
+}
+
+func (g *EtcdSnowflake) workerKey(workerId int64) string {
+	return fmt.Sprintf("%s/workers/%d", g.prefix, workerId)
+}
+
+// lastTsKey 不挂租约，实例号释放之后还要保留
+func (g *EtcdSnowflake) lastTsKey(workerId int64) string {
+	return fmt.Sprintf("%s/last_ts/%d", g.prefix, workerId)
+}
+
+func (g *EtcdSnowflake) loadLastTs(ctx context.Context, workerId int64) (int64, error) {
+	resp, err := g.client.Get(ctx, g.lastTsKey(workerId))
+	if err != nil || len(resp.Kvs) == 0 {
+		return 0, err
+	}
+	return strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+}
+
+func (g *EtcdSnowflake) saveLastTs(ctx context.Context, workerId int64, ts int64) error {
+	if ts == 0 {
+		return nil
+	}
+	_, err := g.client.Put(ctx, g.lastTsKey(workerId), strconv.FormatInt(ts, 10))
+	return err
+}
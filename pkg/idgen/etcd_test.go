@@ -0,0 +1,57 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEtcdSnowflake_Deadline(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 最后一次续约成功的时间
+		renewedAt time.Time
+		ttl       int64
+		wantErr   error
+	}{
+		{
+			name:      "租约还没到期",
+			renewedAt: time.Now(),
+			ttl:       10,
+		},
+		{
+			name:      "续约的响应一直没来，租约已经到期",
+			renewedAt: time.Now().Add(-time.Second * 11),
+			ttl:       10,
+			wantErr:   ErrNoWorkerId,
+		},
+		{
+			name:      "快要到期了，留出余量提前停止",
+			renewedAt: time.Now().Add(-time.Second*10 + leaseSafetyMargin/2),
+			ttl:       10,
+			wantErr:   ErrNoWorkerId,
+		},
+		{
+			name:      "etcd告知租约已经过期",
+			renewedAt: time.Now(),
+			ttl:       0,
+			wantErr:   ErrNoWorkerId,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := &EtcdSnowflake{}
+			sf, err := newSnowflake(1, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.renewed(tc.renewedAt, tc.ttl)
+			g.sf.Store(sf)
+			_, err = g.Next(context.Background())
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, 想要 %v", err, tc.wantErr)
+			}
+		})
+	}
+}
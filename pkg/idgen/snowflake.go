@@ -0,0 +1,95 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	workerIdBits = 10
+	sequenceBits = 12
+	// TagBits 序列号的最低这么多位留给调用方的标记，分库的时候记课程所在的槽位，按id就能找到分片
+	TagBits = 6
+	MaxTag  = 1<<TagBits - 1
+	// MaxWorkerId 同时最多这么多个实例在生成id
+	MaxWorkerId = 1<<workerIdBits - 1
+	// 去掉标记之后，每个实例每毫秒最多生成这么多个id
+	maxSequence = 1<<(sequenceBits-TagBits) - 1
+	// 回拨在这个范围内就等时钟追上来，NTP校时一般只会差几毫秒
+	maxClockBackwards = 10
+)
+
+// epoch 2024-01-01 00:00:00 UTC，41位毫秒时间戳够用到2093年
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+var ErrClockBackwards = errors.New("时钟回拨，拒绝生成id")
+
+// Tag 取出id里面的标记
+func Tag(id int64) int64 {
+	return id & MaxTag
+}
+
+// Snowflake 1位符号 + 41位毫秒时间戳 + 10位实例号 + 6位序列号 + 6位标记，同一个实例号不能同时被两个实例使用
+type Snowflake struct {
+	mu       sync.Mutex
+	workerId int64
+	lastTs   int64
+	sequence int64
+	now      func() int64
+}
+
+func NewSnowflake(workerId int64) (*Snowflake, error) {
+	return newSnowflake(workerId, 0)
+}
+
+// newSnowflake lastTs 是这个实例号上一次生成id的时间，本机时钟比它慢的时候按时钟回拨处理
+func newSnowflake(workerId int64, lastTs int64) (*Snowflake, error) {
+	if workerId < 0 || workerId > MaxWorkerId {
+		return nil, fmt.Errorf("实例号 %d 超出范围 [0, %d]", workerId, MaxWorkerId)
+	}
+	return &Snowflake{workerId: workerId, lastTs: lastTs, now: func() int64 {
+		return time.Now().UnixMilli()
+	}}, nil
+}
+
+func (s *Snowflake) LastTs() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastTs
+}
+
+func (s *Snowflake) Next(ctx context.Context) (int64, error) {
+	return s.NextTagged(ctx, 0)
+}
+
+func (s *Snowflake) NextTagged(ctx context.Context, tag int64) (int64, error) {
+	if tag < 0 || tag > MaxTag {
+		return 0, fmt.Errorf("标记 %d 超出范围 [0, %d]", tag, MaxTag)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.now()
+	for ts < s.lastTs {
+		if s.lastTs-ts > maxClockBackwards {
+			return 0, ErrClockBackwards
+		}
+		time.Sleep(time.Duration(s.lastTs-ts) * time.Millisecond)
+		ts = s.now()
+	}
+	if ts == s.lastTs {
+		s.sequence = (s.sequence + 1) & maxSequence
+		if s.sequence == 0 {
+			// 这一毫秒的序列号用完了，等到下一毫秒
+			for ts <= s.lastTs {
+				ts = s.now()
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastTs = ts
+	return (ts-epoch)<<(workerIdBits+sequenceBits) | s.workerId<<sequenceBits | s.sequence<<TagBits | tag, nil
+}
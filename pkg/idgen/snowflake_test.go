@@ -0,0 +1,76 @@
+package idgen
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSnowflake_NextTagged(t *testing.T) {
+	testCases := []struct {
+		name    string
+		tags    []int64
+		wantErr bool
+	}{
+		{
+			name: "标记原样留在id的最低几位",
+			tags: []int64{0, 1, MaxTag, 5},
+		},
+		{
+			name:    "标记超出范围",
+			tags:    []int64{MaxTag + 1},
+			wantErr: true,
+		},
+		{
+			name:    "标记是负数",
+			tags:    []int64{-1},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSnowflake(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tag := range tc.tags {
+				id, err := s.NextTagged(context.Background(), tag)
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+				}
+				if err == nil && Tag(id) != tag {
+					t.Fatalf("Tag(%d) = %d, want %d", id, Tag(id), tag)
+				}
+			}
+		})
+	}
+}
+
+// 同一毫秒里面序列号用完之后等到下一毫秒，不管带的是什么标记，id都不重复而且递增
+func TestSnowflake_SequenceExhausted(t *testing.T) {
+	s, err := NewSnowflake(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int64
+	s.now = func() int64 {
+		// 每生成一个id时钟只前进一点点，前面几百个id都在同一毫秒
+		calls++
+		return epoch + 1 + calls/200
+	}
+	seen := make(map[int64]struct{})
+	var last int64
+	for i := 0; i < (maxSequence+1)*3; i++ {
+		id, err := s.NextTagged(context.Background(), int64(i%2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := seen[id]; ok {
+			t.Fatalf("id %d 重复", id)
+		}
+		if id <= last {
+			t.Fatalf("id %d 没有比上一个 %d 大", id, last)
+		}
+		seen[id] = struct{}{}
+		last = id
+	}
+}
@@ -0,0 +1,10 @@
+package idgen
+
+import "context"
+
+// Generator 生成全局唯一的id，分库之后不能再依赖数据库的自增主键
+type Generator interface {
+	Next(ctx context.Context) (int64, error)
+	// NextTagged 生成的id最低 TagBits 位是 tag，可以用 Tag 取出来
+	NextTagged(ctx context.Context, tag int64) (int64, error)
+}
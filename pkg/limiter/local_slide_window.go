@@ -0,0 +1,55 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LocalSlideWindowLimiter 单机部署没有redis的时候用，语义和 slide_window.lua 一致
+type LocalSlideWindowLimiter struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	thresholds int // 阈值
+	// 每个限流对象窗口里面的请求时间，从早到晚
+	windows   map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewLocalSlideWindowLimiter(interval time.Duration, thresholds int) Limiter {
+	return &LocalSlideWindowLimiter{
+		interval:   interval,
+		thresholds: thresholds,
+		windows:    map[string][]time.Time{},
+	}
+}
+
+func (l *LocalSlideWindowLimiter) Limit(ctx context.Context, key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	min := now.Add(-l.interval)
+	if now.Sub(l.lastSweep) > l.interval {
+		// 对应 PEXPIRE，每个窗口长度清理一次不再访问的限流对象，map不会无限增长
+		for k, w := range l.windows {
+			if len(l.evict(w, min)) == 0 {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	w := l.evict(l.windows[key], min)
+	if len(w) >= l.thresholds {
+		return true, nil
+	}
+	l.windows[key] = append(w, now)
+	return false, nil
+}
+
+func (l *LocalSlideWindowLimiter) evict(w []time.Time, min time.Time) []time.Time {
+	i := 0
+	for i < len(w) && !w[i].After(min) {
+		i++
+	}
+	return w[i:]
+}
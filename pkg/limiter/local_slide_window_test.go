@@ -0,0 +1,26 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalSlideWindowLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocalSlideWindowLimiter(time.Millisecond*100, 2)
+	for i, want := range []bool{false, false, true} {
+		limited, err := l.Limit(ctx, "a")
+		if err != nil || limited != want {
+			t.Fatalf("第 %d 次: %v, %v", i, limited, err)
+		}
+	}
+	// 不同的限流对象互不影响
+	if limited, _ := l.Limit(ctx, "b"); limited {
+		t.Fatal("b 不应该被限流")
+	}
+	time.Sleep(time.Millisecond * 110)
+	if limited, _ := l.Limit(ctx, "a"); limited {
+		t.Fatal("窗口滑过去之后不应该被限流")
+	}
+}
@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/redis/go-redis/v9"
+	"math/rand/v2"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBloomRebuildLockLost 重建的时间超过了锁的过期时间，这次重建作废
+var ErrBloomRebuildLockLost = errors.New("布隆过滤器重建锁已丢失")
+
+const (
+	bloomRebuildLockExpiration = time.Minute * 10
+	bloomLocalSyncInterval     = time.Second * 30
+)
+
+var (
+	//go:embed lua/bloom_add.lua
+	bloomAddLuaScript string
+	//go:embed lua/bloom_might_contain.lua
+	bloomMightContainLuaScript string
+	//go:embed lua/bloom_start_rebuild.lua
+	bloomStartRebuildLuaScript string
+	//go:embed lua/bloom_finish_rebuild.lua
+	bloomFinishRebuildLuaScript string
+)
+
+// BloomFilter 用来挡住一定不存在的id，防止缓存穿透。只会误判存在，不会误判不存在，
+// 任何拿不准的情况（还没建好、redis出错）都按存在处理
+type BloomFilter interface {
+	// Built 有没有建好的位图，没有的话什么都挡不住，要尽快重建
+	Built(ctx context.Context) (bool, error)
+	MightContain(ctx context.Context, id int64) (bool, error)
+	Add(ctx context.Context, ids ...int64) error
+	// TryStartRebuild 多个实例只会有一个拿到重建权，返回的token在完成重建的时候带上
+	TryStartRebuild(ctx context.Context) (string, bool, error)
+	NewBitmap() *BloomBitmap
+	FinishRebuild(ctx context.Context, token string, bitmap *BloomBitmap) error
+}
+
+// CourseBloomFilter 有课评的课程id
+type CourseBloomFilter interface {
+	BloomFilter
+}
+
+// EvaluationBloomFilter 存在过的课评id
+type EvaluationBloomFilter interface {
+	BloomFilter
+}
+
+// BloomBitmap 在本地构建的位图，位序和redis的位图一致，可以直接SET过去
+type BloomBitmap struct {
+	m    uint
+	k    uint
+	bits []byte
+}
+
+func (b *BloomBitmap) Add(id int64) {
+	for _, offset := range bloomOffsets(id, b.m, b.k) {
+		b.bits[offset>>3] |= 0x80 >> (offset & 7)
+	}
+}
+
+func (b *BloomBitmap) test(id int64) bool {
+	for _, offset := range bloomOffsets(id, b.m, b.k) {
+		if int(offset>>3) >= len(b.bits) || b.bits[offset>>3]&(0x80>>(offset&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RedisBloomFilter 位图放在redis里面给所有实例共享，每个实例再拉一份到本地。
+// 本地副本只用来快速确认存在，本地判定不存在的还要去redis确认，因为本地副本可能是旧的。
+// 位图有一两兆，每次重建完成版本号加一，实例只定期查版本号，变了才重新拉位图
+type RedisBloomFilter struct {
+	cmd  redis.Cmdable
+	name string
+	m    uint
+	k    uint
+	// 本地副本，没有同步到的时候是nil
+	local atomic.Pointer[BloomBitmap]
+	// 本地副本对应的版本号
+	localVersion int64
+	l            logger.Logger
+}
+
+// NewRedisBloomFilter n是预计的元素个数，p是期望的误判率
+func NewRedisBloomFilter(cmd redis.Cmdable, name string, n uint, p float64, l logger.Logger) *RedisBloomFilter {
+	res := NewRemoteRedisBloomFilter(cmd, name, n, p, l)
+	go res.syncLoop()
+	return res
+}
+
+// NewRemoteRedisBloomFilter 不在本地同步副本，每次都查redis。管理员命令只跑一次，不需要副本
+func NewRemoteRedisBloomFilter(cmd redis.Cmdable, name string, n uint, p float64, l logger.Logger) *RedisBloomFilter {
+	m, k := bloom.EstimateParameters(n, p)
+	return &RedisBloomFilter{cmd: cmd, name: name, m: m, k: k, l: l}
+}
+
+func (f *RedisBloomFilter) Built(ctx context.Context) (bool, error) {
+	n, err := f.cmd.Exists(ctx, f.key()).Result()
+	return n > 0, err
+}
+
+func (f *RedisBloomFilter) MightContain(ctx context.Context, id int64) (bool, error) {
+	if local := f.local.Load(); local != nil && local.test(id) {
+		return true, nil
+	}
+	res, err := f.cmd.Eval(ctx, bloomMightContainLuaScript, []string{f.key()}, f.offsetArgs(id)...).Int()
+	if err != nil {
+		return true, err
+	}
+	return res == 1, nil
+}
+
+func (f *RedisBloomFilter) Add(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(ids)*int(f.k))
+	for _, id := range ids {
+		args = append(args, f.offsetArgs(id)...)
+	}
+	return f.cmd.Eval(ctx, bloomAddLuaScript, []string{f.key(), f.pendingKey(), f.lockKey()}, args...).Err()
+}
+
+func (f *RedisBloomFilter) TryStartRebuild(ctx context.Context) (string, bool, error) {
+	token := strconv.FormatUint(rand.Uint64(), 36)
+	res, err := f.cmd.Eval(ctx, bloomStartRebuildLuaScript, []string{f.lockKey(), f.pendingKey()},
+		token, int(bloomRebuildLockExpiration.Seconds())).Int()
+	if err != nil {
+		return "", false, err
+	}
+	return token, res == 1, nil
+}
+
+func (f *RedisBloomFilter) NewBitmap() *BloomBitmap {
+	return &BloomBitmap{m: f.m, k: f.k, bits: make([]byte, (f.m+7)/8)}
+}
+
+func (f *RedisBloomFilter) FinishRebuild(ctx context.Context, token string, bitmap *BloomBitmap) error {
+	err := f.cmd.Set(ctx, f.tmpKey(), bitmap.bits, bloomRebuildLockExpiration).Err()
+	if err != nil {
+		return err
+	}
+	res, err := f.cmd.Eval(ctx, bloomFinishRebuildLuaScript,
+		[]string{f.key(), f.tmpKey(), f.pendingKey(), f.lockKey(), f.versionKey()}, token).Int()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrBloomRebuildLockLost
+	}
+	return nil
+}
+
+// syncLoop 定期查版本号，重建过了才把redis里面的位图拉到本地
+func (f *RedisBloomFilter) syncLoop() {
+	ticker := time.NewTicker(bloomLocalSyncInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		err := f.sync(ctx)
+		cancel()
+		if err != nil {
+			f.l.Error("同步布隆过滤器失败", logger.Error(err), logger.String("name", f.name))
+		}
+		<-ticker.C
+	}
+}
+
+func (f *RedisBloomFilter) sync(ctx context.Context) error {
+	version, err := f.cmd.Get(ctx, f.versionKey()).Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if err == nil && version == f.localVersion && f.local.Load() != nil {
+		return nil
+	}
+	// 先读版本号再读位图，中间又重建了的话拿到的是更新的位图，下一轮再拉一次
+	data, err := f.cmd.Get(ctx, f.key()).Bytes()
+	switch err {
+	case nil:
+		f.local.Store(&BloomBitmap{m: f.m, k: f.k, bits: data})
+		f.localVersion = version
+		return nil
+	case redis.Nil:
+		f.local.Store(nil)
+		f.localVersion = 0
+		return nil
+	default:
+		return err
+	}
+}
+
+func (f *RedisBloomFilter) offsetArgs(id int64) []any {
+	offsets := bloomOffsets(id, f.m, f.k)
+	args := make([]any, 0, len(offsets))
+	for _, offset := range offsets {
+		args = append(args, offset)
+	}
+	return args
+}
+
+// key 里面带上m和k，参数调整之后旧的位图自然失效，不会因为哈希位置不一致产生误判。
+// 同一个过滤器的key用hash tag放到同一个slot里面，lua脚本在集群模式下也能一起操作
+func (f *RedisBloomFilter) key() string {
+	return fmt.Sprintf("kstack:evaluation:bloom:{%s:%d:%d}", f.name, f.m, f.k)
+}
+
+func (f *RedisBloomFilter) pendingKey() string {
+	return f.key() + ":pending"
+}
+
+func (f *RedisBloomFilter) tmpKey() string {
+	return f.key() + ":tmp"
+}
+
+func (f *RedisBloomFilter) lockKey() string {
+	return f.key() + ":rebuilding"
+}
+
+func (f *RedisBloomFilter) versionKey() string {
+	return f.key() + ":version"
+}
+
+func bloomOffsets(id int64, m uint, k uint) []uint64 {
+	locations := bloom.Locations([]byte(strconv.FormatInt(id, 10)), k)
+	for i := range locations {
+		locations[i] %= uint64(m)
+	}
+	return locations
+}
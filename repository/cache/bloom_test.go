@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newTestRedis(t *testing.T) redis.Cmdable {
+	_, client := newTestMiniRedis(t)
+	return client
+}
+
+// newTestMiniRedis 需要快进时间的测试用
+func newTestMiniRedis(t *testing.T) (*miniredis.Miniredis, redis.Cmdable) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return mr, client
+}
+
+func TestRedisBloomFilter_Rebuild(t *testing.T) {
+	ctx := context.Background()
+	f := NewRemoteRedisBloomFilter(newTestRedis(t), "evaluation", 1000, 0.001, logger.NewNopLogger())
+	built, err := f.Built(ctx)
+	if err != nil || built {
+		t.Fatalf("还没建: %v, %v", built, err)
+	}
+	if ok, _ := f.MightContain(ctx, 1); !ok {
+		t.Fatal("还没建好的时候什么都不能排除")
+	}
+	token, ok, err := f.TryStartRebuild(ctx)
+	if err != nil || !ok {
+		t.Fatalf("拿重建权: %v, %v", ok, err)
+	}
+	bitmap := f.NewBitmap()
+	bitmap.Add(1)
+	// 重建期间加进来的要合并进去
+	if err = f.Add(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.FinishRebuild(ctx, token, bitmap); err != nil {
+		t.Fatal(err)
+	}
+	if built, _ = f.Built(ctx); !built {
+		t.Fatal("应该建好了")
+	}
+	for id, want := range map[int64]bool{1: true, 2: true, 3: false} {
+		if ok, _ = f.MightContain(ctx, id); ok != want {
+			t.Fatalf("%d: 得到 %v", id, ok)
+		}
+	}
+}
+
+// 临时key带着过期时间，改名成正式的过滤器之后不能跟着过期
+func TestRedisBloomFilter_RebuildNotExpire(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestMiniRedis(t)
+	f := NewRemoteRedisBloomFilter(client, "evaluation", 1000, 0.001, logger.NewNopLogger())
+	token, ok, err := f.TryStartRebuild(ctx)
+	if err != nil || !ok {
+		t.Fatalf("拿重建权: %v, %v", ok, err)
+	}
+	bitmap := f.NewBitmap()
+	bitmap.Add(1)
+	if err = f.FinishRebuild(ctx, token, bitmap); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(bloomRebuildLockExpiration + time.Minute)
+	if built, err := f.Built(ctx); err != nil || !built {
+		t.Fatalf("重建之后过了 %v 过滤器不见了: %v, %v", bloomRebuildLockExpiration, built, err)
+	}
+	if ok, _ = f.MightContain(ctx, 3); ok {
+		t.Fatal("过滤器还在，不存在的id要被挡住")
+	}
+}
+
+// 位图只在重建之后重新拉，平时只查版本号
+func TestRedisBloomFilter_Sync(t *testing.T) {
+	ctx := context.Background()
+	f := NewRemoteRedisBloomFilter(newTestRedis(t), "evaluation", 1000, 0.001, logger.NewNopLogger())
+	if err := f.sync(ctx); err != nil || f.local.Load() != nil {
+		t.Fatalf("没建好的时候没有本地副本: %v", err)
+	}
+	rebuild := func(ids ...int64) {
+		token, _, err := f.TryStartRebuild(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bitmap := f.NewBitmap()
+		for _, id := range ids {
+			bitmap.Add(id)
+		}
+		if err = f.FinishRebuild(ctx, token, bitmap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rebuild(1)
+	if err := f.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	local := f.local.Load()
+	if local == nil || !local.test(1) || f.localVersion != 1 {
+		t.Fatalf("重建之后要拉到本地, 版本 %d", f.localVersion)
+	}
+	if err := f.Add(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if f.local.Load() != local {
+		t.Fatal("版本号没变不应该重新拉位图")
+	}
+	rebuild(1, 2)
+	if err := f.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if local = f.local.Load(); !local.test(2) || f.localVersion != 2 {
+		t.Fatalf("版本号变了要重新拉, 版本 %d", f.localVersion)
+	}
+}
+
+// lua脚本一起操作的key必须在同一个slot里面，集群模式才能用
+func TestRedisBloomFilter_HashTag(t *testing.T) {
+	f := NewRemoteRedisBloomFilter(nil, "course", 1000, 0.001, logger.NewNopLogger())
+	tag := regexp.MustCompile(`\{[^}]+\}`)
+	want := tag.FindString(f.key())
+	if want == "" {
+		t.Fatalf("没有hash tag: %s", f.key())
+	}
+	for _, key := range []string{f.pendingKey(), f.tmpKey(), f.lockKey(), f.versionKey()} {
+		if got := tag.FindString(key); got != want {
+			t.Fatalf("%s 的hash tag是 %s, 期望 %s", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/redis/go-redis/v9"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+//go:embed lua/count_change_status.lua
+var countChangeStatusLuaScript string
+
+// EvaluationCountCache 用户各状态的课评数和课程不可见的课评数。
+// 课程不可见的课评数和综合得分在同一个事务里面写进数据库，这里只是它的缓存，写的时候直接删掉
+type EvaluationCountCache interface {
+	GetCountMine(ctx context.Context, uid int64, status evaluationv1.EvaluationStatus) (int64, error)
+	// SetCountMine counts 要包含用户所有状态的计数，没有的状态记为0
+	SetCountMine(ctx context.Context, uid int64, counts map[evaluationv1.EvaluationStatus]int64) error
+	GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error)
+	SetCountCourseInvisible(ctx context.Context, courseId int64, count int64) error
+	// AddIfPresent 新发布了一条课评，用户的计数器存在就加一，课程不可见的计数删掉
+	AddIfPresent(ctx context.Context, uid int64, courseId int64, status evaluationv1.EvaluationStatus) error
+	// ChangeStatusIfPresent 课评从旧状态变成了新状态，用户的计数器在脚本里面原子地更新，课程不可见的计数删掉
+	ChangeStatusIfPresent(ctx context.Context, uid int64, courseId int64, oldStatus, newStatus evaluationv1.EvaluationStatus) error
+	// Delete 更新失败的时候删掉计数器，下次读的时候重建
+	Delete(ctx context.Context, uid int64, courseId int64) error
+}
+
+type RedisEvaluationCountCache struct {
+	cmd redis.Cmdable
+}
+
+func NewRedisEvaluationCountCache(cmd redis.Cmdable) EvaluationCountCache {
+	return &RedisEvaluationCountCache{cmd: cmd}
+}
+
+func (cache *RedisEvaluationCountCache) GetCountMine(ctx context.Context, uid int64, status evaluationv1.EvaluationStatus) (int64, error) {
+	// 字段不存在的时候返回的也是redis.Nil，和整个key不存在一样都需要重建
+	return cache.cmd.HGet(ctx, cache.mineKey(uid), strconv.Itoa(int(status))).Int64()
+}
+
+func (cache *RedisEvaluationCountCache) SetCountMine(ctx context.Context, uid int64, counts map[evaluationv1.EvaluationStatus]int64) error {
+	key := cache.mineKey(uid)
+	vals := make([]any, 0, len(counts)*2)
+	for status, cnt := range counts {
+		vals = append(vals, strconv.Itoa(int(status)), cnt)
+	}
+	_, err := cache.cmd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, vals...)
+		pipe.Expire(ctx, key, cache.expiration())
+		return nil
+	})
+	return err
+}
+
+func (cache *RedisEvaluationCountCache) GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
+	return cache.cmd.Get(ctx, cache.courseInvisibleKey(courseId)).Int64()
+}
+
+func (cache *RedisEvaluationCountCache) SetCountCourseInvisible(ctx context.Context, courseId int64, count int64) error {
+	return cache.cmd.Set(ctx, cache.courseInvisibleKey(courseId), count, cache.expiration()).Err()
+}
+
+func (cache *RedisEvaluationCountCache) AddIfPresent(ctx context.Context, uid int64, courseId int64, status evaluationv1.EvaluationStatus) error {
+	return cache.changeStatus(ctx, uid, courseId, "", strconv.Itoa(int(status)))
+}
+
+func (cache *RedisEvaluationCountCache) ChangeStatusIfPresent(ctx context.Context, uid int64, courseId int64,
+	oldStatus, newStatus evaluationv1.EvaluationStatus) error {
+	if oldStatus == newStatus {
+		return nil
+	}
+	return cache.changeStatus(ctx, uid, courseId, strconv.Itoa(int(oldStatus)), strconv.Itoa(int(newStatus)))
+}
+
+// changeStatus 两个key不在同一个slot上，分开操作，脚本只碰一个key，集群模式下也能用
+func (cache *RedisEvaluationCountCache) changeStatus(ctx context.Context, uid int64, courseId int64, oldStatus, newStatus string) error {
+	err := cache.cmd.Del(ctx, cache.courseInvisibleKey(courseId)).Err()
+	if err != nil {
+		return err
+	}
+	return cache.cmd.Eval(ctx, countChangeStatusLuaScript, []string{cache.mineKey(uid)}, oldStatus, newStatus).Err()
+}
+
+// Delete 两个key分开删，集群模式下一个DEL不能跨slot
+func (cache *RedisEvaluationCountCache) Delete(ctx context.Context, uid int64, courseId int64) error {
+	err := cache.cmd.Del(ctx, cache.mineKey(uid)).Err()
+	if err != nil {
+		return err
+	}
+	return cache.cmd.Del(ctx, cache.courseInvisibleKey(courseId)).Err()
+}
+
+func (cache *RedisEvaluationCountCache) expiration() time.Duration {
+	n := rand.IntN(181) // 随机偏移的秒数[0, 180]，防止缓存雪崩，过期重建也顺便对一次账
+	return time.Minute*15 + time.Second*time.Duration(n)
+}
+
+func (cache *RedisEvaluationCountCache) mineKey(uid int64) string {
+	return fmt.Sprintf("kstack:evaluation:count:mine:%d", uid)
+}
+
+func (cache *RedisEvaluationCountCache) courseInvisibleKey(courseId int64) string {
+	return fmt.Sprintf("kstack:evaluation:count:course_invisible:%d", courseId)
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/redis/go-redis/v9"
+	"math/rand/v2"
+	"time"
+)
+
+// CourseCache 课程服务的数据在课评服务这边的缓存，课程信息和选课关系都以课程服务为准，
+// 这里只是为了少调用课程服务
+type CourseCache interface {
+	// GetCourse 未命中返回 ErrKeyNotExists
+	GetCourse(ctx context.Context, courseId int64) (*coursev1.Course, error)
+	SetCourse(ctx context.Context, course *coursev1.Course) error
+	DeleteCourse(ctx context.Context, courseId int64) error
+	// GetSubscribed 未命中返回 ErrKeyNotExists
+	GetSubscribed(ctx context.Context, uid int64, courseId int64) (bool, error)
+	SetSubscribed(ctx context.Context, uid int64, courseId int64, subscribed bool) error
+}
+
+// CourseCacheExpiration 选课关系没有变更事件，只能靠过期，没选的要过期得更快，刚选上课的学生不用等太久
+type CourseCacheExpiration struct {
+	Course       time.Duration `yaml:"course"`
+	Subscribed   time.Duration `yaml:"subscribed"`
+	Unsubscribed time.Duration `yaml:"unsubscribed"`
+}
+
+type RedisCourseCache struct {
+	cmd        redis.Cmdable
+	expiration CourseCacheExpiration
+}
+
+func NewRedisCourseCache(cmd redis.Cmdable, expiration CourseCacheExpiration) *RedisCourseCache {
+	return &RedisCourseCache{cmd: cmd, expiration: expiration}
+}
+
+func (cache *RedisCourseCache) GetCourse(ctx context.Context, courseId int64) (*coursev1.Course, error) {
+	data, err := cache.cmd.Get(ctx, cache.courseKey(courseId)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var course coursev1.Course
+	err = json.Unmarshal(data, &course)
+	return &course, err
+}
+
+func (cache *RedisCourseCache) SetCourse(ctx context.Context, course *coursev1.Course) error {
+	data, err := json.Marshal(course)
+	if err != nil {
+		return err
+	}
+	n := rand.IntN(181) // 随机偏移的秒数[0, 180]，防止缓存雪崩
+	return cache.cmd.Set(ctx, cache.courseKey(course.GetId()), data,
+		cache.expiration.Course+time.Second*time.Duration(n)).Err()
+}
+
+func (cache *RedisCourseCache) DeleteCourse(ctx context.Context, courseId int64) error {
+	return cache.cmd.Del(ctx, cache.courseKey(courseId)).Err()
+}
+
+func (cache *RedisCourseCache) GetSubscribed(ctx context.Context, uid int64, courseId int64) (bool, error) {
+	return cache.cmd.Get(ctx, cache.subscribedKey(uid, courseId)).Bool()
+}
+
+func (cache *RedisCourseCache) SetSubscribed(ctx context.Context, uid int64, courseId int64, subscribed bool) error {
+	expiration := cache.expiration.Unsubscribed
+	if subscribed {
+		expiration = cache.expiration.Subscribed
+	}
+	return cache.cmd.Set(ctx, cache.subscribedKey(uid, courseId), subscribed, expiration).Err()
+}
+
+func (cache *RedisCourseCache) courseKey(courseId int64) string {
+	return fmt.Sprintf("kstack:evaluation:course:%d", courseId)
+}
+
+func (cache *RedisCourseCache) subscribedKey(uid int64, courseId int64) string {
+	return fmt.Sprintf("kstack:evaluation:course_subscribed:%d:%d", uid, courseId)
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/redis/go-redis/v9"
+	"math/rand/v2"
+	"time"
+)
+
+// ErrEvaluationNotFound 命中了空缓存，课评确实不存在
+var ErrEvaluationNotFound = errors.New("课评不存在")
+
+const (
+	detailExpiration         = time.Minute * 15
+	detailNotFoundExpiration = time.Minute
+	// 空缓存的值，真正的课评序列化之后不可能是空串
+	detailNotFoundValue = ""
+)
+
+// EvaluationDetailCache 课评实体的缓存
+type EvaluationDetailCache interface {
+	// Get 未命中返回 ErrKeyNotExists，命中空缓存返回 ErrEvaluationNotFound
+	Get(ctx context.Context, evaluationId int64) (domain.Evaluation, error)
+	// MGet 只返回命中的课评，空缓存和未命中都不在结果里
+	MGet(ctx context.Context, evaluationIds []int64) (map[int64]domain.Evaluation, error)
+	Set(ctx context.Context, evaluation domain.Evaluation) error
+	SetNotFound(ctx context.Context, evaluationId int64) error
+	Delete(ctx context.Context, evaluationIds ...int64) error
+}
+
+type RedisEvaluationDetailCache struct {
+	cmd redis.Cmdable
+}
+
+func NewRedisEvaluationDetailCache(cmd redis.Cmdable) *RedisEvaluationDetailCache {
+	return &RedisEvaluationDetailCache{cmd: cmd}
+}
+
+func (cache *RedisEvaluationDetailCache) Get(ctx context.Context, evaluationId int64) (domain.Evaluation, error) {
+	data, err := cache.cmd.Get(ctx, cache.detailKey(evaluationId)).Result()
+	if err != nil {
+		return domain.Evaluation{}, err
+	}
+	if data == detailNotFoundValue {
+		return domain.Evaluation{}, ErrEvaluationNotFound
+	}
+	var evaluation domain.Evaluation
+	err = json.Unmarshal([]byte(data), &evaluation)
+	return evaluation, err
+}
+
+func (cache *RedisEvaluationDetailCache) MGet(ctx context.Context, evaluationIds []int64) (map[int64]domain.Evaluation, error) {
+	res := make(map[int64]domain.Evaluation, len(evaluationIds))
+	if len(evaluationIds) == 0 {
+		return res, nil
+	}
+	keys := make([]string, 0, len(evaluationIds))
+	for _, id := range evaluationIds {
+		keys = append(keys, cache.detailKey(id))
+	}
+	vals, err := cache.cmd.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range vals {
+		data, ok := val.(string)
+		if !ok || data == detailNotFoundValue {
+			continue
+		}
+		var evaluation domain.Evaluation
+		if json.Unmarshal([]byte(data), &evaluation) != nil {
+			continue
+		}
+		res[evaluationIds[i]] = evaluation
+	}
+	return res, nil
+}
+
+func (cache *RedisEvaluationDetailCache) Set(ctx context.Context, evaluation domain.Evaluation) error {
+	data, err := json.Marshal(evaluation)
+	if err != nil {
+		return err
+	}
+	n := rand.IntN(181) // 随机偏移的秒数[0, 180]，防止缓存雪崩
+	return cache.cmd.Set(ctx, cache.detailKey(evaluation.Id), data, detailExpiration+time.Second*time.Duration(n)).Err()
+}
+
+func (cache *RedisEvaluationDetailCache) SetNotFound(ctx context.Context, evaluationId int64) error {
+	return cache.cmd.Set(ctx, cache.detailKey(evaluationId), detailNotFoundValue, detailNotFoundExpiration).Err()
+}
+
+func (cache *RedisEvaluationDetailCache) Delete(ctx context.Context, evaluationIds ...int64) error {
+	if len(evaluationIds) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(evaluationIds))
+	for _, id := range evaluationIds {
+		keys = append(keys, cache.detailKey(id))
+	}
+	return cache.cmd.Del(ctx, keys...).Err()
+}
+
+func (cache *RedisEvaluationDetailCache) detailKey(evaluationId int64) string {
+	// v2 开始带版本号，之前缓存的课评没有版本号，换一个前缀让它们自然过期
+	return fmt.Sprintf("kstack:evaluation:detail:v2:%d", evaluationId)
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/MuxiKeStack/be-evaluation/domain"
 	"github.com/redis/go-redis/v9"
-	"golang.org/x/sync/singleflight"
 	"math/rand/v2"
 	"strconv"
 	"time"
@@ -34,11 +33,12 @@ type EvaluationCache interface {
 	UpdateRatingIfCompositeScorePresent(ctx context.Context, courseId int64, oldRating uint8, newRating uint8) error
 	AddRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error
 	DeleteRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error
+	// DeleteCompositeScore 综合得分被整体重算之后删掉，下次读的时候回源
+	DeleteCompositeScore(ctx context.Context, courseIds ...int64) error
 }
 
 type RedisEvaluationCache struct {
 	cmd redis.Cmdable
-	g   singleflight.Group
 }
 
 func NewRedisEvaluationCache(cmd redis.Cmdable) EvaluationCache {
@@ -65,14 +65,12 @@ func (cache *RedisEvaluationCache) GetCompositeScore(ctx context.Context, course
 
 func (cache *RedisEvaluationCache) SetCompositeScore(ctx context.Context, courseId int64, cs domain.CompositeScore) error {
 	key := cache.compositeScoreKey(courseId)
-	// 使用singleflight, 防止缓存击穿
-	_, err, _ := cache.g.Do(key, func() (interface{}, error) {
-		err := cache.cmd.HSet(ctx, key, filedScore, cs.Score, filedRaterCnt, cs.RaterCnt).Err()
-		if err != nil {
-			return nil, err
-		}
-		n := rand.IntN(181) // 随机偏移的秒数[0, 180]，防止缓存雪崩
-		return nil, cache.cmd.Expire(ctx, key, time.Minute*15+time.Second*time.Duration(n)).Err()
+	// 防止缓存击穿的singleflight在仓储层的回源上，这里只负责写
+	n := rand.IntN(181) // 随机偏移的秒数[0, 180]，防止缓存雪崩
+	_, err := cache.cmd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, filedScore, cs.Score, filedRaterCnt, cs.RaterCnt)
+		pipe.Expire(ctx, key, time.Minute*15+time.Second*time.Duration(n))
+		return nil
 	})
 	return err
 }
@@ -92,6 +90,17 @@ func (cache *RedisEvaluationCache) DeleteRatingIfCompositeScorePresent(ctx conte
 	return cache.cmd.Eval(ctx, deleteRatingLuaScript, []string{key}, starRating).Err()
 }
 
+func (cache *RedisEvaluationCache) DeleteCompositeScore(ctx context.Context, courseIds ...int64) error {
+	if len(courseIds) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(courseIds))
+	for _, courseId := range courseIds {
+		keys = append(keys, cache.compositeScoreKey(courseId))
+	}
+	return cache.cmd.Del(ctx, keys...).Err()
+}
+
 func (cache *RedisEvaluationCache) compositeScoreKey(courseId int64) string {
 	return fmt.Sprintf("kstack:evaluation:composite_score:%d", courseId)
 }
@@ -0,0 +1,295 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/hotkey"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	compositeScoreInvalidateChannel = "kstack:evaluation:composite_score:invalidate"
+	// hotKeyLoadTimeout 本地过期之后去redis刷新热点的超时时间，和调用方的超时无关
+	hotKeyLoadTimeout = time.Second * 2
+)
+
+type localCompositeScore struct {
+	cs       domain.CompositeScore
+	expireAt time.Time
+}
+
+// HotKeyEvaluationCache 选课周少数课程的综合得分会打爆同一个redis key，
+// 这里在进程内识别出热点课程，把它们的综合得分提升到本地缓存。
+// 评分变了之后通过redis的发布订阅通知所有实例删掉本地缓存
+type HotKeyEvaluationCache struct {
+	EvaluationCache
+	detector *hotkey.Detector
+	// 本地缓存只放热点，冷下来的课程会被定时清理掉，所以不需要额外的容量限制
+	local    sync.Map
+	localTTL time.Duration
+	g        singleflight.Group
+	client   redis.UniversalClient
+	l        logger.Logger
+
+	requests *prometheus.CounterVec
+	hotKeys  *prometheus.GaugeVec
+
+	// Close 的时候停掉后台的goroutine
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewHotKeyEvaluationCache 指标注册到 reg 上，同一个 reg 上重复构造的时候沿用已经注册的指标
+func NewHotKeyEvaluationCache(cache EvaluationCache, detector *hotkey.Detector, localTTL time.Duration,
+	client redis.UniversalClient, reg prometheus.Registerer, l logger.Logger) *HotKeyEvaluationCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	res := &HotKeyEvaluationCache{
+		EvaluationCache: cache,
+		detector:        detector,
+		localTTL:        localTTL,
+		client:          client,
+		l:               l,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kstack",
+			Subsystem: "evaluation",
+			Name:      "composite_score_requests_total",
+			Help:      "综合得分缓存的请求数，按是否热点、是否命中本地缓存区分",
+		}, []string{"result"}),
+		hotKeys: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "kstack",
+			Subsystem: "evaluation",
+			Name:      "composite_score_hot_key_requests",
+			Help:      "当前热点课程在一个统计窗口内的估计请求数",
+		}, []string{"course_id"}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	res.requests = registerCollector(reg, res.requests)
+	res.hotKeys = registerCollector(reg, res.hotKeys)
+	res.wg.Add(2)
+	go func() {
+		defer res.wg.Done()
+		res.refreshHotKeys()
+	}()
+	go func() {
+		defer res.wg.Done()
+		res.subscribe()
+	}()
+	return res
+}
+
+func registerCollector[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	err := reg.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		return are.ExistingCollector.(T)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Close 停掉刷新热点和订阅失效广播的goroutine，等它们退出之后返回
+func (cache *HotKeyEvaluationCache) Close() error {
+	cache.cancel()
+	cache.wg.Wait()
+	return nil
+}
+
+func (cache *HotKeyEvaluationCache) GetCompositeScore(ctx context.Context, courseId int64) (domain.CompositeScore, error) {
+	key := strconv.FormatInt(courseId, 10)
+	if !cache.detector.Hit(key) {
+		cache.requests.WithLabelValues("cold").Inc()
+		return cache.EvaluationCache.GetCompositeScore(ctx, courseId)
+	}
+	if val, ok := cache.local.Load(courseId); ok {
+		lcs := val.(localCompositeScore)
+		if time.Now().Before(lcs.expireAt) {
+			cache.requests.WithLabelValues("local_hit").Inc()
+			return lcs.cs, nil
+		}
+	}
+	cache.requests.WithLabelValues("local_miss").Inc()
+	// 本地过期之后同一个课程只放一个请求去redis刷新
+	val, err, _ := cache.g.Do(key, func() (interface{}, error) {
+		// 不跟随第一个请求的取消，否则所有等待者都会一起失败
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), hotKeyLoadTimeout)
+		defer cancel()
+		cs, err := cache.EvaluationCache.GetCompositeScore(ctx, courseId)
+		if err != nil {
+			return cs, err
+		}
+		cache.store(courseId, cs)
+		return cs, nil
+	})
+	return val.(domain.CompositeScore), err
+}
+
+func (cache *HotKeyEvaluationCache) SetCompositeScore(ctx context.Context, courseId int64, cs domain.CompositeScore) error {
+	err := cache.EvaluationCache.SetCompositeScore(ctx, courseId, cs)
+	if _, ok := cache.local.Load(courseId); ok && err == nil {
+		cache.store(courseId, cs)
+	}
+	return err
+}
+
+func (cache *HotKeyEvaluationCache) UpdateRatingIfCompositeScorePresent(ctx context.Context, courseId int64, oldRating uint8, newRating uint8) error {
+	cache.local.Delete(courseId)
+	err := cache.EvaluationCache.UpdateRatingIfCompositeScorePresent(ctx, courseId, oldRating, newRating)
+	if err != nil {
+		return err
+	}
+	return publishCompositeScoreInvalidation(ctx, cache.client, []int64{courseId})
+}
+
+func (cache *HotKeyEvaluationCache) AddRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error {
+	cache.local.Delete(courseId)
+	err := cache.EvaluationCache.AddRatingIfCompositeScorePresent(ctx, courseId, starRating)
+	if err != nil {
+		return err
+	}
+	return publishCompositeScoreInvalidation(ctx, cache.client, []int64{courseId})
+}
+
+func (cache *HotKeyEvaluationCache) DeleteRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error {
+	cache.local.Delete(courseId)
+	err := cache.EvaluationCache.DeleteRatingIfCompositeScorePresent(ctx, courseId, starRating)
+	if err != nil {
+		return err
+	}
+	return publishCompositeScoreInvalidation(ctx, cache.client, []int64{courseId})
+}
+
+func (cache *HotKeyEvaluationCache) DeleteCompositeScore(ctx context.Context, courseIds ...int64) error {
+	for _, courseId := range courseIds {
+		cache.local.Delete(courseId)
+	}
+	err := cache.EvaluationCache.DeleteCompositeScore(ctx, courseIds...)
+	if err != nil {
+		return err
+	}
+	return publishCompositeScoreInvalidation(ctx, cache.client, courseIds)
+}
+
+func (cache *HotKeyEvaluationCache) store(courseId int64, cs domain.CompositeScore) {
+	// 过期时间加上抖动，避免所有热点在同一时刻回源
+	jitter := time.Duration(rand.Int64N(int64(cache.localTTL/2) + 1))
+	cache.local.Store(courseId, localCompositeScore{cs: cs, expireAt: time.Now().Add(cache.localTTL + jitter)})
+}
+
+// refreshHotKeys 定时刷新热点指标，并且把已经冷掉的课程从本地缓存里面移除
+func (cache *HotKeyEvaluationCache) refreshHotKeys() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		hot := cache.detector.HotKeys()
+		cache.hotKeys.Reset()
+		for key, cnt := range hot {
+			cache.hotKeys.WithLabelValues(key).Set(float64(cnt))
+		}
+		cache.local.Range(func(k, v any) bool {
+			if _, ok := hot[strconv.FormatInt(k.(int64), 10)]; !ok {
+				cache.local.Delete(k)
+			}
+			return true
+		})
+	}
+}
+
+// subscribe 监听其它实例的失效广播，自己发的也会收到，重复删除没有影响
+func (cache *HotKeyEvaluationCache) subscribe() {
+	pubsub := cache.client.Subscribe(cache.ctx, compositeScoreInvalidateChannel)
+	defer pubsub.Close()
+	ch := pubsub.Channel()
+	// go-redis 断线会自动重连，重连期间丢掉的消息靠本地缓存的短过期时间兜底
+	for {
+		var msg *redis.Message
+		var ok bool
+		select {
+		case <-cache.ctx.Done():
+			return
+		case msg, ok = <-ch:
+			if !ok {
+				return
+			}
+		}
+		for _, s := range strings.Split(msg.Payload, ",") {
+			courseId, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				cache.l.Error("解析综合得分缓存失效消息失败", logger.Error(err), logger.String("payload", msg.Payload))
+				continue
+			}
+			cache.local.Delete(courseId)
+		}
+	}
+}
+
+func publishCompositeScoreInvalidation(ctx context.Context, client redis.UniversalClient, courseIds []int64) error {
+	if len(courseIds) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(courseIds))
+	for _, id := range courseIds {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	return client.Publish(ctx, compositeScoreInvalidateChannel, strings.Join(ids, ",")).Err()
+}
+
+// PublishingEvaluationCache 自己没有本地缓存，只在评分变了的时候通知正在运行的实例删掉它们的本地缓存。
+// 给管理员命令用，不用起订阅的goroutine
+type PublishingEvaluationCache struct {
+	EvaluationCache
+	client redis.UniversalClient
+}
+
+func NewPublishingEvaluationCache(cache EvaluationCache, client redis.UniversalClient) EvaluationCache {
+	return &PublishingEvaluationCache{EvaluationCache: cache, client: client}
+}
+
+func (cache *PublishingEvaluationCache) UpdateRatingIfCompositeScorePresent(ctx context.Context, courseId int64, oldRating uint8, newRating uint8) error {
+	err := cache.EvaluationCache.UpdateRatingIfCompositeScorePresent(ctx, courseId, oldRating, newRating)
+	if err != nil {
+		return err
+	}
+	return publishCompositeScoreInvalidation(ctx, cache.client, []int64{courseId})
+}
+
+func (cache *PublishingEvaluationCache) AddRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error {
+	err := cache.EvaluationCache.AddRatingIfCompositeScorePresent(ctx, courseId, starRating)
+	if err != nil {
+		return err
+	}
+	return publishCompositeScoreInvalidation(ctx, cache.client, []int64{courseId})
+}
+
+func (cache *PublishingEvaluationCache) DeleteRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error {
+	err := cache.EvaluationCache.DeleteRatingIfCompositeScorePresent(ctx, courseId, starRating)
+	if err != nil {
+		return err
+	}
+	return publishCompositeScoreInvalidation(ctx, cache.client, []int64{courseId})
+}
+
+func (cache *PublishingEvaluationCache) DeleteCompositeScore(ctx context.Context, courseIds ...int64) error {
+	err := cache.EvaluationCache.DeleteCompositeScore(ctx, courseIds...)
+	if err != nil {
+		return err
+	}
+	return publishCompositeScoreInvalidation(ctx, cache.client, courseIds)
+}
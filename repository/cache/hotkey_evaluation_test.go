@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/hotkey"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+// newTestHotKeyEvaluationCache 同一个进程里面可以建多个，模拟多个实例，指标注册在同一个 reg 上
+func newTestHotKeyEvaluationCache(t *testing.T, remote EvaluationCache, client redis.UniversalClient,
+	reg prometheus.Registerer) *HotKeyEvaluationCache {
+	// 第一次访问就算热点
+	res := NewHotKeyEvaluationCache(remote, hotkey.NewDetector(time.Minute, 1), time.Minute, client, reg,
+		logger.NewNopLogger())
+	t.Cleanup(func() {
+		_ = res.Close()
+	})
+	return res
+}
+
+func TestHotKeyEvaluationCache_Invalidate(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	remote := NewRedisEvaluationCache(client)
+	err := remote.SetCompositeScore(ctx, 1, domain.CompositeScore{CourseId: 1, Score: 4, RaterCnt: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	writer := newTestHotKeyEvaluationCache(t, remote, client, reg)
+	reader := newTestHotKeyEvaluationCache(t, remote, client, reg)
+	admin := NewPublishingEvaluationCache(remote, client)
+	waitFor(t, func() bool {
+		return mr.PubSubNumSub(compositeScoreInvalidateChannel)[compositeScoreInvalidateChannel] == 2
+	})
+
+	testCases := []struct {
+		name    string
+		write   func() error
+		wantCnt int64
+		wantErr error
+	}{
+		{
+			name: "别的实例改了评分",
+			write: func() error {
+				return writer.AddRatingIfCompositeScorePresent(ctx, 1, 5)
+			},
+			wantCnt: 3,
+		},
+		{
+			name: "管理员命令删了综合得分",
+			write: func() error {
+				return admin.DeleteCompositeScore(ctx, 1)
+			},
+			wantErr: ErrKeyNotExists,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 先读一次进到本地缓存
+			if _, err := reader.GetCompositeScore(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := reader.local.Load(int64(1)); !ok {
+				t.Fatal("热点应该进本地缓存")
+			}
+			if err := tc.write(); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, func() bool {
+				_, ok := reader.local.Load(int64(1))
+				return !ok
+			})
+			// 本地删掉之后读到的是redis里面最新的
+			got, err := reader.GetCompositeScore(ctx, 1)
+			if err != tc.wantErr || got.RaterCnt != tc.wantCnt {
+				t.Fatalf("得到 %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+// blockingEvaluationCache 去redis刷新的请求卡住，直到 release 或者请求被取消
+type blockingEvaluationCache struct {
+	EvaluationCache
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingEvaluationCache) GetCompositeScore(ctx context.Context, courseId int64) (domain.CompositeScore, error) {
+	close(c.entered)
+	select {
+	case <-c.release:
+		return domain.CompositeScore{CourseId: courseId, Score: 4, RaterCnt: 2}, nil
+	case <-ctx.Done():
+		return domain.CompositeScore{}, ctx.Err()
+	}
+}
+
+// 第一个请求取消了，去redis刷新的请求不能跟着失败，否则等在同一个热点上的请求都会失败
+func TestHotKeyEvaluationCache_LoadDetachedFromCaller(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	remote := &blockingEvaluationCache{entered: make(chan struct{}), release: make(chan struct{})}
+	c := newTestHotKeyEvaluationCache(t, remote, client, prometheus.NewRegistry())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	type result struct {
+		cs  domain.CompositeScore
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		cs, err := c.GetCompositeScore(ctx, 1)
+		done <- result{cs: cs, err: err}
+	}()
+	<-remote.entered
+	cancel()
+	close(remote.release)
+	res := <-done
+	if res.err != nil || res.cs.RaterCnt != 2 {
+		t.Fatalf("得到 %+v, %v", res.cs, res.err)
+	}
+	if _, ok := c.local.Load(int64(1)); !ok {
+		t.Fatal("刷新的结果应该进本地缓存")
+	}
+}
+
+// 同一个 reg 上构造多次不会panic，Close 之后不再订阅失效广播
+func TestHotKeyEvaluationCache_Close(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	reg := prometheus.NewRegistry()
+	remote := NewRedisEvaluationCache(client)
+	var caches []*HotKeyEvaluationCache
+	for i := 0; i < 2; i++ {
+		caches = append(caches, NewHotKeyEvaluationCache(remote, hotkey.NewDetector(time.Minute, 1), time.Minute,
+			client, reg, logger.NewNopLogger()))
+	}
+	waitFor(t, func() bool {
+		return mr.PubSubNumSub(compositeScoreInvalidateChannel)[compositeScoreInvalidateChannel] == 2
+	})
+	for _, c := range caches {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		return mr.PubSubNumSub(compositeScoreInvalidateChannel)[compositeScoreInvalidateChannel] == 0
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second * 2)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
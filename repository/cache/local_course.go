@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"time"
+)
+
+type localCourseEntry struct {
+	course   *coursev1.Course
+	expireAt time.Time
+}
+
+type subscribedKey struct {
+	uid      int64
+	courseId int64
+}
+
+type localSubscribedEntry struct {
+	subscribed bool
+	expireAt   time.Time
+}
+
+// LocalCourseCache 进程内的LRU，课程和选课关系分开两个LRU，选课关系的数量大得多，不能把课程挤出去
+type LocalCourseCache struct {
+	courses    *lru.Cache[int64, localCourseEntry]
+	subscribed *lru.Cache[subscribedKey, localSubscribedEntry]
+	expiration CourseCacheExpiration
+}
+
+func NewLocalCourseCache(courseSize int, subscribedSize int, expiration CourseCacheExpiration) *LocalCourseCache {
+	courses, err := lru.New[int64, localCourseEntry](courseSize)
+	if err != nil {
+		panic(err)
+	}
+	subscribed, err := lru.New[subscribedKey, localSubscribedEntry](subscribedSize)
+	if err != nil {
+		panic(err)
+	}
+	return &LocalCourseCache{courses: courses, subscribed: subscribed, expiration: expiration}
+}
+
+func (cache *LocalCourseCache) GetCourse(ctx context.Context, courseId int64) (*coursev1.Course, error) {
+	entry, ok := cache.courses.Get(courseId)
+	if !ok {
+		return nil, ErrKeyNotExists
+	}
+	if time.Now().After(entry.expireAt) {
+		cache.courses.Remove(courseId)
+		return nil, ErrKeyNotExists
+	}
+	return entry.course, nil
+}
+
+func (cache *LocalCourseCache) SetCourse(ctx context.Context, course *coursev1.Course) error {
+	cache.courses.Add(course.GetId(), localCourseEntry{
+		course:   course,
+		expireAt: time.Now().Add(cache.expiration.Course),
+	})
+	return nil
+}
+
+func (cache *LocalCourseCache) DeleteCourse(ctx context.Context, courseId int64) error {
+	cache.courses.Remove(courseId)
+	return nil
+}
+
+func (cache *LocalCourseCache) GetSubscribed(ctx context.Context, uid int64, courseId int64) (bool, error) {
+	key := subscribedKey{uid: uid, courseId: courseId}
+	entry, ok := cache.subscribed.Get(key)
+	if !ok {
+		return false, ErrKeyNotExists
+	}
+	if time.Now().After(entry.expireAt) {
+		cache.subscribed.Remove(key)
+		return false, ErrKeyNotExists
+	}
+	return entry.subscribed, nil
+}
+
+func (cache *LocalCourseCache) SetSubscribed(ctx context.Context, uid int64, courseId int64, subscribed bool) error {
+	expiration := cache.expiration.Unsubscribed
+	if subscribed {
+		expiration = cache.expiration.Subscribed
+	}
+	cache.subscribed.Add(subscribedKey{uid: uid, courseId: courseId}, localSubscribedEntry{
+		subscribed: subscribed,
+		expireAt:   time.Now().Add(expiration),
+	})
+	return nil
+}
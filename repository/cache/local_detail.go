@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"time"
+)
+
+const (
+	// 本地缓存的过期时间要短，兜底广播丢失的情况
+	localDetailExpiration         = time.Minute
+	localDetailNotFoundExpiration = time.Second * 10
+)
+
+type localDetailEntry struct {
+	evaluation domain.Evaluation
+	notFound   bool
+	expireAt   time.Time
+}
+
+// LocalEvaluationDetailCache 进程内的LRU，只缓存热点课评
+type LocalEvaluationDetailCache struct {
+	cache *lru.Cache[int64, localDetailEntry]
+}
+
+func NewLocalEvaluationDetailCache(size int) *LocalEvaluationDetailCache {
+	cache, err := lru.New[int64, localDetailEntry](size)
+	if err != nil {
+		panic(err)
+	}
+	return &LocalEvaluationDetailCache{cache: cache}
+}
+
+func (cache *LocalEvaluationDetailCache) Get(ctx context.Context, evaluationId int64) (domain.Evaluation, error) {
+	entry, ok := cache.get(evaluationId)
+	switch {
+	case !ok:
+		return domain.Evaluation{}, ErrKeyNotExists
+	case entry.notFound:
+		return domain.Evaluation{}, ErrEvaluationNotFound
+	default:
+		return entry.evaluation, nil
+	}
+}
+
+func (cache *LocalEvaluationDetailCache) MGet(ctx context.Context, evaluationIds []int64) (map[int64]domain.Evaluation, error) {
+	res := make(map[int64]domain.Evaluation, len(evaluationIds))
+	for _, id := range evaluationIds {
+		entry, ok := cache.get(id)
+		if ok && !entry.notFound {
+			res[id] = entry.evaluation
+		}
+	}
+	return res, nil
+}
+
+func (cache *LocalEvaluationDetailCache) Set(ctx context.Context, evaluation domain.Evaluation) error {
+	cache.cache.Add(evaluation.Id, localDetailEntry{
+		evaluation: evaluation,
+		expireAt:   time.Now().Add(localDetailExpiration),
+	})
+	return nil
+}
+
+func (cache *LocalEvaluationDetailCache) SetNotFound(ctx context.Context, evaluationId int64) error {
+	cache.cache.Add(evaluationId, localDetailEntry{
+		notFound: true,
+		expireAt: time.Now().Add(localDetailNotFoundExpiration),
+	})
+	return nil
+}
+
+func (cache *LocalEvaluationDetailCache) Delete(ctx context.Context, evaluationIds ...int64) error {
+	for _, id := range evaluationIds {
+		cache.cache.Remove(id)
+	}
+	return nil
+}
+
+func (cache *LocalEvaluationDetailCache) get(evaluationId int64) (localDetailEntry, bool) {
+	entry, ok := cache.cache.Get(evaluationId)
+	if !ok {
+		return localDetailEntry{}, false
+	}
+	if time.Now().After(entry.expireAt) {
+		cache.cache.Remove(evaluationId)
+		return localDetailEntry{}, false
+	}
+	return entry, true
+}
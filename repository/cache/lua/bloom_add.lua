@@ -0,0 +1,19 @@
+---
+--- Generated by EmmyLua(https://github.com/EmmyLua)
+--- Created by xishan.
+--- DateTime: 2026/10/19 14:03
+---
+--- KEYS[1] 布隆过滤器 KEYS[2] 重建期间的增量 KEYS[3] 重建锁，ARGV 是要置位的偏移量
+--- 过滤器不存在的时候不能直接SETBIT，否则一个只有几位的过滤器会把所有没加进去的id判成不存在
+local exists = redis.call('EXISTS', KEYS[1]) == 1
+local rebuilding = redis.call('EXISTS', KEYS[3]) == 1
+for i = 1, #ARGV do
+    if exists then
+        redis.call('SETBIT', KEYS[1], ARGV[i], 1)
+    end
+    if rebuilding then
+        -- 重建是基于旧的快照的，重建期间加进来的要在替换的时候合并进去
+        redis.call('SETBIT', KEYS[2], ARGV[i], 1)
+    end
+end
+return 1
@@ -0,0 +1,21 @@
+---
+--- Generated by EmmyLua(https://github.com/EmmyLua)
+--- Created by xishan.
+--- DateTime: 2026/10/19 14:26
+---
+--- KEYS[1] 布隆过滤器 KEYS[2] 新建好的过滤器 KEYS[3] 重建期间的增量 KEYS[4] 重建锁 KEYS[5] 版本号，ARGV[1] 锁的值
+if redis.call('GET', KEYS[4]) ~= ARGV[1] then
+    -- 锁已经过期了，增量可能已经被别的重建清掉，这次的结果不能用
+    redis.call('DEL', KEYS[2])
+    return 0
+end
+if redis.call('EXISTS', KEYS[3]) == 1 then
+    redis.call('BITOP', 'OR', KEYS[2], KEYS[2], KEYS[3])
+end
+redis.call('RENAME', KEYS[2], KEYS[1])
+-- RENAME 会把临时key的过期时间带过来，正式的过滤器不能过期
+redis.call('PERSIST', KEYS[1])
+redis.call('DEL', KEYS[3], KEYS[4])
+-- 各个实例看到版本号变了才重新拉位图
+redis.call('INCR', KEYS[5])
+return 1
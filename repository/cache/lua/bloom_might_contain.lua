@@ -0,0 +1,15 @@
+---
+--- Generated by EmmyLua(https://github.com/EmmyLua)
+--- Created by xishan.
+--- DateTime: 2026/10/19 14:10
+---
+--- 过滤器还没建好的时候什么都不能排除
+if redis.call('EXISTS', KEYS[1]) == 0 then
+    return 1
+end
+for i = 1, #ARGV do
+    if redis.call('GETBIT', KEYS[1], ARGV[i]) == 0 then
+        return 0
+    end
+end
+return 1
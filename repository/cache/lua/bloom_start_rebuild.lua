@@ -0,0 +1,12 @@
+---
+--- Generated by EmmyLua(https://github.com/EmmyLua)
+--- Created by xishan.
+--- DateTime: 2026/10/19 14:21
+---
+--- KEYS[1] 重建锁 KEYS[2] 重建期间的增量，ARGV[1] 锁的值 ARGV[2] 锁的过期秒数
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'EX', ARGV[2]) then
+    -- 拿到锁和清空增量必须是原子的，否则拿锁之后写进来的增量会被删掉
+    redis.call('DEL', KEYS[2])
+    return 1
+end
+return 0
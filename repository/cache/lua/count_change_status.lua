@@ -0,0 +1,22 @@
+---
+--- Generated by EmmyLua(https://github.com/EmmyLua)
+--- Created by xishan.
+--- DateTime: 2026/10/19 15:40
+---
+--- KEYS[1] 用户各状态的课评数
+--- ARGV[1] 旧状态，新发布的课评为空串 ARGV[2] 新状态
+local oldStatus = ARGV[1]
+local newStatus = ARGV[2]
+if oldStatus == newStatus then
+    return 0
+end
+
+-- 计数器只在存在的时候更新，不存在的等读的时候从数据库重建
+if redis.call('EXISTS', KEYS[1]) == 0 then
+    return 0
+end
+if oldStatus ~= '' then
+    redis.call('HINCRBY', KEYS[1], oldStatus, -1)
+end
+redis.call('HINCRBY', KEYS[1], newStatus, 1)
+return 1
@@ -0,0 +1,19 @@
+---
+--- Generated by EmmyLua(https://github.com/EmmyLua)
+--- Created by xishan.
+--- DateTime: 2026/10/19 10:12
+---
+--- 只往已经存在的时间线里面加，不存在的时间线等读的时候从数据库重建，
+--- 否则一个只有一条记录的时间线会被当成完整的
+local member = ARGV[1]
+local score = ARGV[2]
+local capacity = tonumber(ARGV[3])
+
+for _, key in ipairs(KEYS) do
+    if redis.call('EXISTS', key) == 1 then
+        redis.call('ZADD', key, score, member)
+        -- 只保留utime最大的capacity条
+        redis.call('ZREMRANGEBYRANK', key, 0, -capacity - 1)
+    end
+end
+return 1
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"github.com/bits-and-blooms/bloom/v3"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryBloomFilter 单机部署没有redis的时候用，语义和 RedisBloomFilter 一致
+type MemoryBloomFilter struct {
+	mu sync.Mutex
+	m  uint
+	k  uint
+	// 还没建好的时候是nil
+	bitmap *BloomBitmap
+	// 重建期间的增量
+	pending       *BloomBitmap
+	token         string
+	lockExpiresAt time.Time
+}
+
+func NewMemoryBloomFilter(n uint, p float64) *MemoryBloomFilter {
+	m, k := bloom.EstimateParameters(n, p)
+	return &MemoryBloomFilter{m: m, k: k}
+}
+
+func (f *MemoryBloomFilter) Built(ctx context.Context) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bitmap != nil, nil
+}
+
+func (f *MemoryBloomFilter) MightContain(ctx context.Context, id int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bitmap == nil {
+		return true, nil
+	}
+	return f.bitmap.test(id), nil
+}
+
+// Add 对应 bloom_add.lua
+func (f *MemoryBloomFilter) Add(ctx context.Context, ids ...int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rebuilding := f.rebuilding()
+	for _, id := range ids {
+		if f.bitmap != nil {
+			f.bitmap.Add(id)
+		}
+		if rebuilding {
+			f.pending.Add(id)
+		}
+	}
+	return nil
+}
+
+// TryStartRebuild 对应 bloom_start_rebuild.lua
+func (f *MemoryBloomFilter) TryStartRebuild(ctx context.Context) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token := strconv.FormatUint(rand.Uint64(), 36)
+	if f.rebuilding() {
+		return token, false, nil
+	}
+	f.token = token
+	f.lockExpiresAt = time.Now().Add(bloomRebuildLockExpiration)
+	f.pending = f.NewBitmap()
+	return token, true, nil
+}
+
+func (f *MemoryBloomFilter) NewBitmap() *BloomBitmap {
+	return &BloomBitmap{m: f.m, k: f.k, bits: make([]byte, (f.m+7)/8)}
+}
+
+// FinishRebuild 对应 bloom_finish_rebuild.lua
+func (f *MemoryBloomFilter) FinishRebuild(ctx context.Context, token string, bitmap *BloomBitmap) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.rebuilding() || f.token != token {
+		return ErrBloomRebuildLockLost
+	}
+	for i := range bitmap.bits {
+		bitmap.bits[i] |= f.pending.bits[i]
+	}
+	f.bitmap = bitmap
+	f.pending, f.token = nil, ""
+	return nil
+}
+
+// rebuilding 调用方要持有锁
+func (f *MemoryBloomFilter) rebuilding() bool {
+	return f.token != "" && time.Now().Before(f.lockExpiresAt)
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	f := NewMemoryBloomFilter(1000, 0.001)
+	ok, err := f.MightContain(ctx, 1)
+	if err != nil || !ok {
+		t.Fatalf("还没建好的时候什么都不能排除: %v, %v", ok, err)
+	}
+
+	token, ok, err := f.TryStartRebuild(ctx)
+	if err != nil || !ok {
+		t.Fatalf("拿重建权: %v, %v", ok, err)
+	}
+	if _, ok, _ = f.TryStartRebuild(ctx); ok {
+		t.Fatal("重建期间不能再拿到重建权")
+	}
+	bitmap := f.NewBitmap()
+	bitmap.Add(1)
+	// 重建期间加进来的要合并到新的位图里面
+	if err = f.Add(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.FinishRebuild(ctx, token+"x", bitmap); err != ErrBloomRebuildLockLost {
+		t.Fatalf("token对不上: %v", err)
+	}
+	if err = f.FinishRebuild(ctx, token, bitmap); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{1, 2} {
+		if ok, _ = f.MightContain(ctx, id); !ok {
+			t.Fatalf("%d 应该在", id)
+		}
+	}
+	if ok, _ = f.MightContain(ctx, 3); ok {
+		t.Fatal("3 不应该在")
+	}
+	if err = f.Add(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ = f.MightContain(ctx, 3); !ok {
+		t.Fatal("建好之后加进来的应该在")
+	}
+	if _, ok, _ = f.TryStartRebuild(ctx); !ok {
+		t.Fatal("重建完成之后要释放重建权")
+	}
+}
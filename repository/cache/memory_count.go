@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+type memoryCountMine struct {
+	counts   map[evaluationv1.EvaluationStatus]int64
+	expireAt time.Time
+}
+
+type memoryCountInvisible struct {
+	count    int64
+	expireAt time.Time
+}
+
+// MemoryEvaluationCountCache 单机部署没有redis的时候用，语义和 RedisEvaluationCountCache 一致，容量满了按LRU淘汰
+type MemoryEvaluationCountCache struct {
+	// 读改写要整体加锁，对应lua脚本的原子性
+	mu        sync.Mutex
+	mine      *lru.Cache[int64, memoryCountMine]
+	invisible *lru.Cache[int64, memoryCountInvisible]
+}
+
+func NewMemoryEvaluationCountCache(size int) EvaluationCountCache {
+	mine, err := lru.New[int64, memoryCountMine](size)
+	if err != nil {
+		panic(err)
+	}
+	invisible, err := lru.New[int64, memoryCountInvisible](size)
+	if err != nil {
+		panic(err)
+	}
+	return &MemoryEvaluationCountCache{mine: mine, invisible: invisible}
+}
+
+func (cache *MemoryEvaluationCountCache) GetCountMine(ctx context.Context, uid int64, status evaluationv1.EvaluationStatus) (int64, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.getMine(uid)
+	if !ok {
+		return 0, ErrKeyNotExists
+	}
+	cnt, ok := entry.counts[status]
+	if !ok {
+		return 0, ErrKeyNotExists
+	}
+	return cnt, nil
+}
+
+func (cache *MemoryEvaluationCountCache) SetCountMine(ctx context.Context, uid int64, counts map[evaluationv1.EvaluationStatus]int64) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry := memoryCountMine{counts: make(map[evaluationv1.EvaluationStatus]int64, len(counts)), expireAt: cache.expireAt()}
+	for status, cnt := range counts {
+		entry.counts[status] = cnt
+	}
+	cache.mine.Add(uid, entry)
+	return nil
+}
+
+func (cache *MemoryEvaluationCountCache) GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.getInvisible(courseId)
+	if !ok {
+		return 0, ErrKeyNotExists
+	}
+	return entry.count, nil
+}
+
+func (cache *MemoryEvaluationCountCache) SetCountCourseInvisible(ctx context.Context, courseId int64, count int64) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.invisible.Add(courseId, memoryCountInvisible{count: count, expireAt: cache.expireAt()})
+	return nil
+}
+
+func (cache *MemoryEvaluationCountCache) AddIfPresent(ctx context.Context, uid int64, courseId int64, status evaluationv1.EvaluationStatus) error {
+	cache.changeStatus(uid, courseId, nil, status)
+	return nil
+}
+
+func (cache *MemoryEvaluationCountCache) ChangeStatusIfPresent(ctx context.Context, uid int64, courseId int64,
+	oldStatus, newStatus evaluationv1.EvaluationStatus) error {
+	cache.changeStatus(uid, courseId, &oldStatus, newStatus)
+	return nil
+}
+
+// changeStatus 对应 RedisEvaluationCountCache.changeStatus，oldStatus 是nil表示新发布的课评
+func (cache *MemoryEvaluationCountCache) changeStatus(uid int64, courseId int64, oldStatus *evaluationv1.EvaluationStatus,
+	newStatus evaluationv1.EvaluationStatus) {
+	if oldStatus != nil && *oldStatus == newStatus {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.invisible.Remove(courseId)
+	// 计数器只在存在的时候更新，不存在的等读的时候从数据库重建
+	if entry, ok := cache.getMine(uid); ok {
+		if oldStatus != nil {
+			entry.counts[*oldStatus]--
+		}
+		entry.counts[newStatus]++
+	}
+}
+
+func (cache *MemoryEvaluationCountCache) Delete(ctx context.Context, uid int64, courseId int64) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.mine.Remove(uid)
+	cache.invisible.Remove(courseId)
+	return nil
+}
+
+// getMine 调用方要持有锁，过期的顺便删掉
+func (cache *MemoryEvaluationCountCache) getMine(uid int64) (memoryCountMine, bool) {
+	entry, ok := cache.mine.Get(uid)
+	if ok && time.Now().After(entry.expireAt) {
+		cache.mine.Remove(uid)
+		return memoryCountMine{}, false
+	}
+	return entry, ok
+}
+
+func (cache *MemoryEvaluationCountCache) getInvisible(courseId int64) (memoryCountInvisible, bool) {
+	entry, ok := cache.invisible.Get(courseId)
+	if ok && time.Now().After(entry.expireAt) {
+		cache.invisible.Remove(courseId)
+		return memoryCountInvisible{}, false
+	}
+	return entry, ok
+}
+
+func (cache *MemoryEvaluationCountCache) expireAt() time.Time {
+	n := rand.IntN(181) // 随机偏移的秒数[0, 180]，和redis一致
+	return time.Now().Add(time.Minute*15 + time.Second*time.Duration(n))
+}
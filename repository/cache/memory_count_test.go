@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"testing"
+)
+
+func TestMemoryEvaluationCountCache(t *testing.T) {
+	testEvaluationCountCache(t, func() EvaluationCountCache {
+		return NewMemoryEvaluationCountCache(10)
+	})
+}
+
+func TestRedisEvaluationCountCache(t *testing.T) {
+	testEvaluationCountCache(t, func() EvaluationCountCache {
+		return NewRedisEvaluationCountCache(newTestRedis(t))
+	})
+}
+
+// testEvaluationCountCache 课程不可见的计数以数据库为准，课评的状态变了就删掉，等下次读的时候回源
+func testEvaluationCountCache(t *testing.T, newCache func() EvaluationCountCache) {
+	const (
+		uid      = 1
+		courseId = 2
+	)
+	public, private := evaluationv1.EvaluationStatus_Public, evaluationv1.EvaluationStatus_Private
+	testCases := []struct {
+		name        string
+		update      func(c EvaluationCountCache) error
+		wantPublic  int64
+		wantPrivate int64
+		// 课程不可见的计数还在缓存里面
+		wantInvisible bool
+	}{
+		{
+			name: "发布公开的",
+			update: func(c EvaluationCountCache) error {
+				return c.AddIfPresent(context.Background(), uid, courseId, public)
+			},
+			wantPublic: 3, wantPrivate: 1,
+		},
+		{
+			name: "发布私密的",
+			update: func(c EvaluationCountCache) error {
+				return c.AddIfPresent(context.Background(), uid, courseId, private)
+			},
+			wantPublic: 2, wantPrivate: 2,
+		},
+		{
+			name: "公开改成私密",
+			update: func(c EvaluationCountCache) error {
+				return c.ChangeStatusIfPresent(context.Background(), uid, courseId, public, private)
+			},
+			wantPublic: 1, wantPrivate: 2,
+		},
+		{
+			name: "私密改成公开",
+			update: func(c EvaluationCountCache) error {
+				return c.ChangeStatusIfPresent(context.Background(), uid, courseId, private, public)
+			},
+			wantPublic: 3, wantPrivate: 0,
+		},
+		{
+			name: "状态没变",
+			update: func(c EvaluationCountCache) error {
+				return c.ChangeStatusIfPresent(context.Background(), uid, courseId, private, private)
+			},
+			wantPublic: 2, wantPrivate: 1, wantInvisible: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newCache()
+			err := c.SetCountMine(ctx, uid, map[evaluationv1.EvaluationStatus]int64{public: 2, private: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = c.SetCountCourseInvisible(ctx, courseId, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err = tc.update(c); err != nil {
+				t.Fatal(err)
+			}
+			gotPublic, _ := c.GetCountMine(ctx, uid, public)
+			gotPrivate, _ := c.GetCountMine(ctx, uid, private)
+			_, err = c.GetCountCourseInvisible(ctx, courseId)
+			if gotPublic != tc.wantPublic || gotPrivate != tc.wantPrivate || (err == nil) != tc.wantInvisible {
+				t.Fatalf("得到 %d %d %v", gotPublic, gotPrivate, err)
+			}
+		})
+	}
+}
+
+func TestMemoryEvaluationCountCache_IfPresent(t *testing.T) {
+	testEvaluationCountCacheIfPresent(t, NewMemoryEvaluationCountCache(10))
+}
+
+func TestRedisEvaluationCountCache_IfPresent(t *testing.T) {
+	testEvaluationCountCacheIfPresent(t, NewRedisEvaluationCountCache(newTestRedis(t)))
+}
+
+func testEvaluationCountCacheIfPresent(t *testing.T, c EvaluationCountCache) {
+	ctx := context.Background()
+	err := c.AddIfPresent(ctx, 1, 2, evaluationv1.EvaluationStatus_Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetCountMine(ctx, 1, evaluationv1.EvaluationStatus_Public); err != ErrKeyNotExists {
+		t.Fatalf("不存在的时候不能凭空建出来: %v", err)
+	}
+	if _, err = c.GetCountCourseInvisible(ctx, 2); err != ErrKeyNotExists {
+		t.Fatalf("不存在的时候不能凭空建出来: %v", err)
+	}
+	// 没有缓存的状态当成没命中，不能返回0
+	err = c.SetCountMine(ctx, 1, map[evaluationv1.EvaluationStatus]int64{evaluationv1.EvaluationStatus_Public: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetCountMine(ctx, 1, evaluationv1.EvaluationStatus_Private); err != ErrKeyNotExists {
+		t.Fatalf("没有缓存的状态: %v", err)
+	}
+	if err = c.SetCountCourseInvisible(ctx, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete(ctx, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetCountMine(ctx, 1, evaluationv1.EvaluationStatus_Public); err != ErrKeyNotExists {
+		t.Fatalf("删掉之后: %v", err)
+	}
+	if _, err = c.GetCountCourseInvisible(ctx, 2); err != ErrKeyNotExists {
+		t.Fatalf("删掉之后: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type memoryCompositeScore struct {
+	score    float64
+	raterCnt int64
+	expireAt time.Time
+}
+
+// MemoryEvaluationCache 单机部署没有redis的时候用，语义和 RedisEvaluationCache 一致，
+// 更新评分的算法和lua脚本逐行对应，结果按lua写回redis的精度取整，容量满了按LRU淘汰
+type MemoryEvaluationCache struct {
+	// lru本身是并发安全的，但是读改写要整体加锁，对应lua脚本的原子性
+	mu    sync.Mutex
+	cache *lru.Cache[int64, memoryCompositeScore]
+}
+
+func NewMemoryEvaluationCache(size int) EvaluationCache {
+	cache, err := lru.New[int64, memoryCompositeScore](size)
+	if err != nil {
+		panic(err)
+	}
+	return &MemoryEvaluationCache{cache: cache}
+}
+
+func (cache *MemoryEvaluationCache) GetCompositeScore(ctx context.Context, courseId int64) (domain.CompositeScore, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.get(courseId)
+	if !ok {
+		return domain.CompositeScore{}, ErrKeyNotExists
+	}
+	return domain.CompositeScore{
+		CourseId: courseId,
+		Score:    entry.score,
+		RaterCnt: entry.raterCnt,
+	}, nil
+}
+
+func (cache *MemoryEvaluationCache) SetCompositeScore(ctx context.Context, courseId int64, cs domain.CompositeScore) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	n := rand.IntN(181) // 随机偏移的秒数[0, 180]，和redis一致
+	cache.cache.Add(courseId, memoryCompositeScore{
+		score:    cs.Score,
+		raterCnt: cs.RaterCnt,
+		expireAt: time.Now().Add(time.Minute*15 + time.Second*time.Duration(n)),
+	})
+	return nil
+}
+
+// UpdateRatingIfCompositeScorePresent 对应 composite_score_update_rating.lua
+func (cache *MemoryEvaluationCache) UpdateRatingIfCompositeScorePresent(ctx context.Context, courseId int64, oldRating uint8, newRating uint8) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.get(courseId)
+	if !ok {
+		return nil
+	}
+	currentCount := float64(entry.raterCnt)
+	entry.score = luaNumber(((entry.score * currentCount) - float64(oldRating) + float64(newRating)) / currentCount)
+	// HSET 不会改过期时间
+	cache.cache.Add(courseId, entry)
+	return nil
+}
+
+// AddRatingIfCompositeScorePresent 对应 composite_score_add_rating.lua
+func (cache *MemoryEvaluationCache) AddRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.get(courseId)
+	if !ok {
+		return nil
+	}
+	currentCount := float64(entry.raterCnt)
+	newCount := currentCount + 1
+	entry.score = luaNumber(((entry.score * currentCount) + float64(starRating)) / newCount)
+	entry.raterCnt++
+	cache.cache.Add(courseId, entry)
+	return nil
+}
+
+// DeleteRatingIfCompositeScorePresent 对应 composite_score_delete_rating.lua
+func (cache *MemoryEvaluationCache) DeleteRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.get(courseId)
+	if !ok {
+		return nil
+	}
+	if entry.raterCnt == 1 {
+		entry.score, entry.raterCnt = 0, 0
+		cache.cache.Add(courseId, entry)
+		return nil
+	}
+	currentCount := float64(entry.raterCnt)
+	newCount := currentCount - 1
+	entry.score = luaNumber(((entry.score * currentCount) - float64(starRating)) / newCount)
+	entry.raterCnt--
+	cache.cache.Add(courseId, entry)
+	return nil
+}
+
+func (cache *MemoryEvaluationCache) DeleteCompositeScore(ctx context.Context, courseIds ...int64) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, courseId := range courseIds {
+		cache.cache.Remove(courseId)
+	}
+	return nil
+}
+
+// get 调用方要持有锁，过期的顺便删掉
+func (cache *MemoryEvaluationCache) get(courseId int64) (memoryCompositeScore, bool) {
+	entry, ok := cache.cache.Get(courseId)
+	if !ok {
+		return memoryCompositeScore{}, false
+	}
+	if time.Now().After(entry.expireAt) {
+		cache.cache.Remove(courseId)
+		return memoryCompositeScore{}, false
+	}
+	return entry, true
+}
+
+// luaNumber lua脚本 HSET 一个number的时候按 %.14g 转成字符串，读回来只剩14位有效数字
+func luaNumber(x float64) float64 {
+	res, _ := strconv.ParseFloat(strconv.FormatFloat(x, 'g', 14, 64), 64)
+	return res
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"testing"
+)
+
+// 期望值是redis里面跑对应lua脚本之后 HGET 出来的结果，lua按 %.14g 写回
+func TestMemoryEvaluationCache_LuaPrecision(t *testing.T) {
+	testCases := []struct {
+		name   string
+		update func(c EvaluationCache) error
+		want   domain.CompositeScore
+	}{
+		{
+			name: "新增评分",
+			update: func(c EvaluationCache) error {
+				return c.AddRatingIfCompositeScorePresent(context.Background(), 1, 5)
+			},
+			want: domain.CompositeScore{CourseId: 1, Score: 4.3333333333333, RaterCnt: 3},
+		},
+		{
+			name: "修改评分",
+			update: func(c EvaluationCache) error {
+				return c.UpdateRatingIfCompositeScorePresent(context.Background(), 1, 4, 3)
+			},
+			want: domain.CompositeScore{CourseId: 1, Score: 3.5, RaterCnt: 2},
+		},
+		{
+			name: "删掉最后一个评分",
+			update: func(c EvaluationCache) error {
+				if err := c.DeleteRatingIfCompositeScorePresent(context.Background(), 1, 4); err != nil {
+					return err
+				}
+				return c.DeleteRatingIfCompositeScorePresent(context.Background(), 1, 4)
+			},
+			want: domain.CompositeScore{CourseId: 1, Score: 0, RaterCnt: 0},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewMemoryEvaluationCache(10)
+			err := c.SetCompositeScore(context.Background(), 1, domain.CompositeScore{CourseId: 1, Score: 4, RaterCnt: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tc.update(c); err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.GetCompositeScore(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("得到 %+v, 期望 %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMemoryEvaluationCache_UpdateIfPresent(t *testing.T) {
+	c := NewMemoryEvaluationCache(10)
+	err := c.AddRatingIfCompositeScorePresent(context.Background(), 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetCompositeScore(context.Background(), 1)
+	if err != ErrKeyNotExists {
+		t.Fatalf("不存在的时候不能凭空建出来: %v", err)
+	}
+}
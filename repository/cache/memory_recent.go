@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type memoryTimeline struct {
+	// 课评id -> utime
+	items map[int64]int64
+	// 对应redis里面的 recentCompleteMarker
+	complete bool
+	expireAt time.Time
+}
+
+// MemoryRecentEvaluationCache 单机部署没有redis的时候用，语义和 RedisRecentEvaluationCache 一致
+type MemoryRecentEvaluationCache struct {
+	mu        sync.Mutex
+	timelines map[coursev1.CourseProperty]*memoryTimeline
+}
+
+func NewMemoryRecentEvaluationCache() RecentEvaluationCache {
+	return &MemoryRecentEvaluationCache{timelines: map[coursev1.CourseProperty]*memoryTimeline{}}
+}
+
+func (cache *MemoryRecentEvaluationCache) GetRecent(ctx context.Context, property coursev1.CourseProperty,
+	curEvaluationId int64, limit int64) ([]int64, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	tl, ok := cache.get(property)
+	if !ok {
+		return nil, ErrKeyNotExists
+	}
+	ids := make([]int64, 0, limit)
+	for _, id := range tl.sorted() {
+		if id >= curEvaluationId {
+			continue
+		}
+		ids = append(ids, id)
+		if int64(len(ids)) == limit {
+			return ids, nil
+		}
+	}
+	if tl.complete {
+		return ids, nil
+	}
+	return ids, ErrRecentNotEnough
+}
+
+func (cache *MemoryRecentEvaluationCache) SetRecent(ctx context.Context, property coursev1.CourseProperty, items []RecentItem) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(items) > RecentCapacity {
+		items = items[:RecentCapacity]
+	}
+	tl := &memoryTimeline{
+		items:    make(map[int64]int64, len(items)),
+		complete: len(items) < RecentCapacity,
+		expireAt: time.Now().Add(recentExpiration(len(items))),
+	}
+	for _, item := range items {
+		tl.items[item.EvaluationId] = item.Utime
+	}
+	cache.timelines[property] = tl
+	return nil
+}
+
+// AddRecentIfPresent 对应 recent_add_if_present.lua
+func (cache *MemoryRecentEvaluationCache) AddRecentIfPresent(ctx context.Context, property coursev1.CourseProperty,
+	evaluationId int64, utime int64) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, p := range recentProperties(property) {
+		tl, ok := cache.get(p)
+		if !ok {
+			continue
+		}
+		tl.items[evaluationId] = utime
+		// redis里面标记也占一个位置，超过容量的时候第一个被裁掉
+		if tl.complete && len(tl.items)+1 > RecentCapacity {
+			tl.complete = false
+		}
+		if len(tl.items) > RecentCapacity {
+			for _, id := range tl.sorted()[RecentCapacity:] {
+				delete(tl.items, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (cache *MemoryRecentEvaluationCache) DeleteRecent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, p := range recentProperties(property) {
+		tl, ok := cache.get(p)
+		if !ok {
+			continue
+		}
+		delete(tl.items, evaluationId)
+		// 和redis一样，删空了的有序集合就是不存在，带着完整标记的除外
+		if len(tl.items) == 0 && !tl.complete {
+			delete(cache.timelines, p)
+		}
+	}
+	return nil
+}
+
+func (cache *MemoryRecentEvaluationCache) ClearRecent(ctx context.Context, properties ...coursev1.CourseProperty) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for _, property := range properties {
+		if property == CoursePropertyAny {
+			continue
+		}
+		delete(cache.timelines, property)
+	}
+	return nil
+}
+
+// get 调用方要持有锁，过期的顺便删掉
+func (cache *MemoryRecentEvaluationCache) get(property coursev1.CourseProperty) (*memoryTimeline, bool) {
+	tl, ok := cache.timelines[property]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(tl.expireAt) {
+		delete(cache.timelines, property)
+		return nil, false
+	}
+	return tl, true
+}
+
+// sorted 和 ZREVRANGE 的顺序一致：utime倒序，utime相同的按成员的字符串倒序
+func (tl *memoryTimeline) sorted() []int64 {
+	ids := make([]int64, 0, len(tl.items))
+	for id := range tl.items {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ui, uj := tl.items[ids[i]], tl.items[ids[j]]; ui != uj {
+			return ui > uj
+		}
+		return strconv.FormatInt(ids[i], 10) > strconv.FormatInt(ids[j], 10)
+	})
+	return ids
+}
+
+func recentProperties(property coursev1.CourseProperty) []coursev1.CourseProperty {
+	if property == CoursePropertyAny {
+		return []coursev1.CourseProperty{CoursePropertyAny}
+	}
+	return []coursev1.CourseProperty{property, CoursePropertyAny}
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryRecentEvaluationCache(t *testing.T) {
+	testRecentEvaluationCache(t, NewMemoryRecentEvaluationCache)
+}
+
+func TestRedisRecentEvaluationCache(t *testing.T) {
+	testRecentEvaluationCache(t, func() RecentEvaluationCache {
+		return NewRedisRecentEvaluationCache(newTestRedis(t))
+	})
+}
+
+// testRecentEvaluationCache redis和进程内的实现跑同一组用例，保证语义一致
+func testRecentEvaluationCache(t *testing.T, newCache func() RecentEvaluationCache) {
+	const property coursev1.CourseProperty = 1
+	ctx := context.Background()
+	full := func(n int) []RecentItem {
+		items := make([]RecentItem, 0, n)
+		for i := n; i > 0; i-- {
+			items = append(items, RecentItem{EvaluationId: int64(i), Utime: int64(i)})
+		}
+		return items
+	}
+
+	t.Run("不存在的时间线", func(t *testing.T) {
+		c := newCache()
+		// 时间线不存在的时候不能只加一条进去，否则会被当成完整的时间线
+		if err := c.AddRecentIfPresent(ctx, property, 5, 5); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetRecent(ctx, property, 100, 10); err != ErrKeyNotExists {
+			t.Fatalf("不存在的时候不能凭空建出来: %v", err)
+		}
+	})
+
+	t.Run("空的时间线也要缓存", func(t *testing.T) {
+		c := newCache()
+		if err := c.SetRecent(ctx, property, nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.GetRecent(ctx, property, 100, 10)
+		if err != nil || len(got) != 0 {
+			t.Fatalf("得到 %v, %v", got, err)
+		}
+		if err = c.AddRecentIfPresent(ctx, property, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		got, err = c.GetRecent(ctx, property, 100, 10)
+		if err != nil || !reflect.DeepEqual(got, []int64{1}) {
+			t.Fatalf("空的时间线上发布了一条: %v, %v", got, err)
+		}
+	})
+
+	t.Run("增删和翻页", func(t *testing.T) {
+		c := newCache()
+		if err := c.SetRecent(ctx, property, full(3)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.SetRecent(ctx, CoursePropertyAny, []RecentItem{{EvaluationId: 3, Utime: 3}}); err != nil {
+			t.Fatal(err)
+		}
+		// 修改过的课评排到最前面
+		if err := c.AddRecentIfPresent(ctx, property, 1, 10); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.GetRecent(ctx, property, 100, 2)
+		if err != nil || !reflect.DeepEqual(got, []int64{1, 3}) {
+			t.Fatalf("得到 %v, %v", got, err)
+		}
+		// 不满容量的时间线就是全部的数据，翻到底不用回源
+		got, err = c.GetRecent(ctx, property, 3, 10)
+		if err != nil || !reflect.DeepEqual(got, []int64{1, 2}) {
+			t.Fatalf("翻到底了: %v, %v", got, err)
+		}
+		got, _ = c.GetRecent(ctx, CoursePropertyAny, 100, 10)
+		if !reflect.DeepEqual(got, []int64{1, 3}) {
+			t.Fatalf("不区分性质的时间线也要加上: %v", got)
+		}
+		if err = c.DeleteRecent(ctx, property, 1); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = c.GetRecent(ctx, CoursePropertyAny, 100, 10)
+		if !reflect.DeepEqual(got, []int64{3}) {
+			t.Fatalf("删除要从两条时间线里面删掉: %v", got)
+		}
+		if err = c.ClearRecent(ctx, property, CoursePropertyAny); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.GetRecent(ctx, property, 100, 10); err != ErrKeyNotExists {
+			t.Fatalf("清掉之后: %v", err)
+		}
+		if _, err = c.GetRecent(ctx, CoursePropertyAny, 100, 10); err != nil {
+			t.Fatalf("不区分性质的时间线不清: %v", err)
+		}
+	})
+
+	t.Run("满了之后挤掉最旧的", func(t *testing.T) {
+		c := newCache()
+		if err := c.SetRecent(ctx, CoursePropertyAny, full(RecentCapacity-1)); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []int64{RecentCapacity, RecentCapacity + 1} {
+			if err := c.AddRecentIfPresent(ctx, CoursePropertyAny, id, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := c.GetRecent(ctx, CoursePropertyAny, RecentCapacity+2, RecentCapacity+1)
+		if err != ErrRecentNotEnough || len(got) != RecentCapacity || got[len(got)-1] != 2 {
+			t.Fatalf("挤掉过数据的时间线翻到底要回源: %d 条, %v", len(got), err)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+// RecentCapacity 每条时间线最多保留的课评数
+const RecentCapacity = 1000
+
+// CoursePropertyAny 不区分课程性质的时间线
+const CoursePropertyAny coursev1.CourseProperty = 0
+
+// ErrRecentNotEnough 时间线里面凑不够一页，需要回源
+var ErrRecentNotEnough = errors.New("时间线中的课评不足")
+
+const (
+	// recentCompleteMarker 重建的时候数据库里面的课评不满容量，时间线就是全部的数据，翻到底也不用回源。
+	// 分数是-inf，超过容量的时候第一个被裁掉。空的时间线只有这一个成员，redis里面不会有空的有序集合
+	recentCompleteMarker = "complete"
+	// recentEmptyExpiration 空的时间线也要缓存，防止每次读都触发重建，但是过期时间要短
+	recentEmptyExpiration = time.Minute
+)
+
+//go:embed lua/recent_add_if_present.lua
+var recentAddLuaScript string
+
+type RecentItem struct {
+	EvaluationId int64
+	Utime        int64
+}
+
+// RecentEvaluationCache 按课程性质维护的最近公开课评时间线，以utime为分数
+type RecentEvaluationCache interface {
+	// GetRecent 按utime倒序返回时间线中id小于curEvaluationId的课评id，
+	// 翻到了时间线的末尾返回 ErrRecentNotEnough，时间线是完整的除外
+	GetRecent(ctx context.Context, property coursev1.CourseProperty, curEvaluationId int64, limit int64) ([]int64, error)
+	SetRecent(ctx context.Context, property coursev1.CourseProperty, items []RecentItem) error
+	// AddRecentIfPresent 同时写入该性质的时间线和不区分性质的时间线
+	AddRecentIfPresent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64, utime int64) error
+	DeleteRecent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64) error
+	// ClearRecent 整条删掉这些性质的时间线，等下次读的时候重建，不会动不区分性质的时间线
+	ClearRecent(ctx context.Context, properties ...coursev1.CourseProperty) error
+}
+
+type RedisRecentEvaluationCache struct {
+	cmd redis.Cmdable
+}
+
+func NewRedisRecentEvaluationCache(cmd redis.Cmdable) RecentEvaluationCache {
+	return &RedisRecentEvaluationCache{cmd: cmd}
+}
+
+func (cache *RedisRecentEvaluationCache) GetRecent(ctx context.Context, property coursev1.CourseProperty,
+	curEvaluationId int64, limit int64) ([]int64, error) {
+	// 时间线是有界的，整条取出来在内存里面过滤
+	members, err := cache.cmd.ZRevRange(ctx, cache.recentKey(property), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, ErrKeyNotExists
+	}
+	ids := make([]int64, 0, limit)
+	complete := false
+	for _, member := range members {
+		if member == recentCompleteMarker {
+			complete = true
+			continue
+		}
+		id, er := strconv.ParseInt(member, 10, 64)
+		if er != nil || id >= curEvaluationId {
+			continue
+		}
+		ids = append(ids, id)
+		if int64(len(ids)) == limit {
+			return ids, nil
+		}
+	}
+	if complete {
+		return ids, nil
+	}
+	// 走到了时间线的末尾，更早的课评可能被裁掉了，交给数据库
+	return ids, ErrRecentNotEnough
+}
+
+func (cache *RedisRecentEvaluationCache) SetRecent(ctx context.Context, property coursev1.CourseProperty, items []RecentItem) error {
+	key := cache.recentKey(property)
+	if len(items) > RecentCapacity {
+		items = items[:RecentCapacity]
+	}
+	members := make([]redis.Z, 0, len(items)+1)
+	for _, item := range items {
+		members = append(members, redis.Z{Score: float64(item.Utime), Member: item.EvaluationId})
+	}
+	if len(items) < RecentCapacity {
+		members = append(members, redis.Z{Score: math.Inf(-1), Member: recentCompleteMarker})
+	}
+	_, err := cache.cmd.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.ZAdd(ctx, key, members...)
+		pipe.Expire(ctx, key, recentExpiration(len(items)))
+		return nil
+	})
+	return err
+}
+
+func (cache *RedisRecentEvaluationCache) AddRecentIfPresent(ctx context.Context, property coursev1.CourseProperty,
+	evaluationId int64, utime int64) error {
+	return cache.cmd.Eval(ctx, recentAddLuaScript, cache.recentKeys(property), evaluationId, utime, RecentCapacity).Err()
+}
+
+func (cache *RedisRecentEvaluationCache) DeleteRecent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64) error {
+	pipe := cache.cmd.Pipeline()
+	for _, key := range cache.recentKeys(property) {
+		pipe.ZRem(ctx, key, evaluationId)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (cache *RedisRecentEvaluationCache) ClearRecent(ctx context.Context, properties ...coursev1.CourseProperty) error {
+	keys := make([]string, 0, len(properties))
+	for _, property := range properties {
+		if property == CoursePropertyAny {
+			continue
+		}
+		keys = append(keys, cache.recentKey(property))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return cache.cmd.Del(ctx, keys...).Err()
+}
+
+func (cache *RedisRecentEvaluationCache) recentKeys(property coursev1.CourseProperty) []string {
+	if property == CoursePropertyAny {
+		return []string{cache.recentKey(CoursePropertyAny)}
+	}
+	return []string{cache.recentKey(property), cache.recentKey(CoursePropertyAny)}
+}
+
+// recentKey 所有时间线用hash tag放到同一个slot里面，lua脚本在集群模式下也能同时写两条时间线
+func (cache *RedisRecentEvaluationCache) recentKey(property coursev1.CourseProperty) string {
+	return fmt.Sprintf("kstack:evaluation:{recent}:%d", property)
+}
+
+func recentExpiration(n int) time.Duration {
+	if n == 0 {
+		return recentEmptyExpiration
+	}
+	n = rand.IntN(181) // 随机偏移的秒数[0, 180]，防止缓存雪崩，过期重建也顺便修正偏差
+	return time.Minute*15 + time.Second*time.Duration(n)
+}
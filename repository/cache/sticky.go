@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"time"
+)
+
+// PrimaryStickyCache 记录最近写过数据的用户，这段时间里面他读自己的数据要走主库，
+// 放在redis里面是因为写和接下来的读不一定落在同一个实例上
+type PrimaryStickyCache interface {
+	MarkWritten(ctx context.Context, uid int64) error
+	RecentlyWritten(ctx context.Context, uid int64) (bool, error)
+}
+
+type RedisPrimaryStickyCache struct {
+	cmd redis.Cmdable
+	// 要比从库的复制延迟长
+	window time.Duration
+}
+
+func NewRedisPrimaryStickyCache(cmd redis.Cmdable, window time.Duration) PrimaryStickyCache {
+	return &RedisPrimaryStickyCache{cmd: cmd, window: window}
+}
+
+func (cache *RedisPrimaryStickyCache) MarkWritten(ctx context.Context, uid int64) error {
+	return cache.cmd.Set(ctx, cache.key(uid), 1, cache.window).Err()
+}
+
+func (cache *RedisPrimaryStickyCache) RecentlyWritten(ctx context.Context, uid int64) (bool, error) {
+	n, err := cache.cmd.Exists(ctx, cache.key(uid)).Result()
+	return n > 0, err
+}
+
+func (cache *RedisPrimaryStickyCache) key(uid int64) string {
+	return fmt.Sprintf("kstack:evaluation:primary_sticky:%d", uid)
+}
+
+// MemoryPrimaryStickyCache 单机部署没有redis的时候用，只有一个实例，写和读一定在同一个进程里面
+type MemoryPrimaryStickyCache struct {
+	mu      sync.Mutex
+	written map[int64]time.Time
+	window  time.Duration
+}
+
+func NewMemoryPrimaryStickyCache(window time.Duration) PrimaryStickyCache {
+	return &MemoryPrimaryStickyCache{written: map[int64]time.Time{}, window: window}
+}
+
+func (cache *MemoryPrimaryStickyCache) MarkWritten(ctx context.Context, uid int64) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	now := time.Now()
+	// 顺便清理过期的，map不会无限增长
+	for id, expireAt := range cache.written {
+		if now.After(expireAt) {
+			delete(cache.written, id)
+		}
+	}
+	cache.written[uid] = now.Add(cache.window)
+	return nil
+}
+
+func (cache *MemoryPrimaryStickyCache) RecentlyWritten(ctx context.Context, uid int64) (bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	expireAt, ok := cache.written[uid]
+	return ok && !time.Now().After(expireAt), nil
+}
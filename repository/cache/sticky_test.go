@@ -0,0 +1,25 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryPrimaryStickyCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryPrimaryStickyCache(time.Millisecond * 50)
+	if ok, _ := c.RecentlyWritten(ctx, 1); ok {
+		t.Fatal("没写过")
+	}
+	if err := c.MarkWritten(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.RecentlyWritten(ctx, 1); !ok {
+		t.Fatal("刚写过")
+	}
+	time.Sleep(time.Millisecond * 60)
+	if ok, _ := c.RecentlyWritten(ctx, 1); ok {
+		t.Fatal("过了窗口")
+	}
+}
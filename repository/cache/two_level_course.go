@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+)
+
+const courseInvalidateChannel = "kstack:evaluation:course:invalidate"
+
+// TwoLevelCourseCache 本地LRU在前，redis在后。课程更新事件只会被一个实例消费到，
+// 所以删除的时候通过redis的发布订阅通知所有实例删掉本地缓存
+type TwoLevelCourseCache struct {
+	local  *LocalCourseCache
+	remote *RedisCourseCache
+	client redis.UniversalClient
+	l      logger.Logger
+}
+
+func NewTwoLevelCourseCache(local *LocalCourseCache, remote *RedisCourseCache,
+	client redis.UniversalClient, l logger.Logger) CourseCache {
+	res := &TwoLevelCourseCache{local: local, remote: remote, client: client, l: l}
+	go res.subscribe()
+	return res
+}
+
+func (cache *TwoLevelCourseCache) GetCourse(ctx context.Context, courseId int64) (*coursev1.Course, error) {
+	course, err := cache.local.GetCourse(ctx, courseId)
+	if err != ErrKeyNotExists {
+		return course, err
+	}
+	course, err = cache.remote.GetCourse(ctx, courseId)
+	if err == nil {
+		_ = cache.local.SetCourse(ctx, course)
+	}
+	return course, err
+}
+
+func (cache *TwoLevelCourseCache) SetCourse(ctx context.Context, course *coursev1.Course) error {
+	_ = cache.local.SetCourse(ctx, course)
+	return cache.remote.SetCourse(ctx, course)
+}
+
+func (cache *TwoLevelCourseCache) DeleteCourse(ctx context.Context, courseId int64) error {
+	_ = cache.local.DeleteCourse(ctx, courseId)
+	err := cache.remote.DeleteCourse(ctx, courseId)
+	if err != nil {
+		return err
+	}
+	return cache.client.Publish(ctx, courseInvalidateChannel, strconv.FormatInt(courseId, 10)).Err()
+}
+
+func (cache *TwoLevelCourseCache) GetSubscribed(ctx context.Context, uid int64, courseId int64) (bool, error) {
+	subscribed, err := cache.local.GetSubscribed(ctx, uid, courseId)
+	if err != ErrKeyNotExists {
+		return subscribed, err
+	}
+	subscribed, err = cache.remote.GetSubscribed(ctx, uid, courseId)
+	if err == nil {
+		_ = cache.local.SetSubscribed(ctx, uid, courseId, subscribed)
+	}
+	return subscribed, err
+}
+
+func (cache *TwoLevelCourseCache) SetSubscribed(ctx context.Context, uid int64, courseId int64, subscribed bool) error {
+	_ = cache.local.SetSubscribed(ctx, uid, courseId, subscribed)
+	return cache.remote.SetSubscribed(ctx, uid, courseId, subscribed)
+}
+
+// subscribe 监听其它实例的失效广播，自己发的也会收到，重复删除没有影响
+func (cache *TwoLevelCourseCache) subscribe() {
+	ctx := context.Background()
+	pubsub := cache.client.Subscribe(ctx, courseInvalidateChannel)
+	defer pubsub.Close()
+	// 断线期间丢掉的消息靠本地缓存的过期时间兜底
+	for msg := range pubsub.Channel() {
+		courseId, err := strconv.ParseInt(msg.Payload, 10, 64)
+		if err != nil {
+			cache.l.Error("解析课程缓存失效消息失败", logger.Error(err), logger.String("payload", msg.Payload))
+			continue
+		}
+		_ = cache.local.DeleteCourse(ctx, courseId)
+	}
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+)
+
+const detailInvalidateChannel = "kstack:evaluation:detail:invalidate"
+
+// TwoLevelEvaluationDetailCache 本地LRU在前，redis在后。
+// 失效的时候通过redis的发布订阅通知所有实例删掉本地缓存
+type TwoLevelEvaluationDetailCache struct {
+	local  *LocalEvaluationDetailCache
+	remote *RedisEvaluationDetailCache
+	client redis.UniversalClient
+	l      logger.Logger
+}
+
+func NewTwoLevelEvaluationDetailCache(local *LocalEvaluationDetailCache, remote *RedisEvaluationDetailCache,
+	client redis.UniversalClient, l logger.Logger) EvaluationDetailCache {
+	res := &TwoLevelEvaluationDetailCache{local: local, remote: remote, client: client, l: l}
+	go res.subscribe()
+	return res
+}
+
+func (cache *TwoLevelEvaluationDetailCache) Get(ctx context.Context, evaluationId int64) (domain.Evaluation, error) {
+	evaluation, err := cache.local.Get(ctx, evaluationId)
+	if err != ErrKeyNotExists {
+		return evaluation, err
+	}
+	evaluation, err = cache.remote.Get(ctx, evaluationId)
+	switch err {
+	case nil:
+		_ = cache.local.Set(ctx, evaluation)
+	case ErrEvaluationNotFound:
+		_ = cache.local.SetNotFound(ctx, evaluationId)
+	}
+	return evaluation, err
+}
+
+func (cache *TwoLevelEvaluationDetailCache) MGet(ctx context.Context, evaluationIds []int64) (map[int64]domain.Evaluation, error) {
+	res, _ := cache.local.MGet(ctx, evaluationIds)
+	if len(res) == len(evaluationIds) {
+		return res, nil
+	}
+	missed := make([]int64, 0, len(evaluationIds)-len(res))
+	for _, id := range evaluationIds {
+		if _, ok := res[id]; !ok {
+			missed = append(missed, id)
+		}
+	}
+	remote, err := cache.remote.MGet(ctx, missed)
+	if err != nil {
+		// redis挂了也把本地命中的返回回去
+		return res, err
+	}
+	for id, evaluation := range remote {
+		res[id] = evaluation
+		_ = cache.local.Set(ctx, evaluation)
+	}
+	return res, nil
+}
+
+func (cache *TwoLevelEvaluationDetailCache) Set(ctx context.Context, evaluation domain.Evaluation) error {
+	_ = cache.local.Set(ctx, evaluation)
+	return cache.remote.Set(ctx, evaluation)
+}
+
+func (cache *TwoLevelEvaluationDetailCache) SetNotFound(ctx context.Context, evaluationId int64) error {
+	_ = cache.local.SetNotFound(ctx, evaluationId)
+	return cache.remote.SetNotFound(ctx, evaluationId)
+}
+
+func (cache *TwoLevelEvaluationDetailCache) Delete(ctx context.Context, evaluationIds ...int64) error {
+	if len(evaluationIds) == 0 {
+		return nil
+	}
+	_ = cache.local.Delete(ctx, evaluationIds...)
+	err := cache.remote.Delete(ctx, evaluationIds...)
+	if err != nil {
+		return err
+	}
+	return publishDetailInvalidation(ctx, cache.client, evaluationIds)
+}
+
+func publishDetailInvalidation(ctx context.Context, client redis.UniversalClient, evaluationIds []int64) error {
+	ids := make([]string, 0, len(evaluationIds))
+	for _, id := range evaluationIds {
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	return client.Publish(ctx, detailInvalidateChannel, strings.Join(ids, ",")).Err()
+}
+
+// PublishingEvaluationDetailCache 自己没有本地缓存，只在删除的时候通知正在运行的实例删掉它们的本地缓存。
+// 给管理员命令用，不用起订阅的goroutine
+type PublishingEvaluationDetailCache struct {
+	*RedisEvaluationDetailCache
+	client redis.UniversalClient
+}
+
+func NewPublishingEvaluationDetailCache(remote *RedisEvaluationDetailCache, client redis.UniversalClient) EvaluationDetailCache {
+	return &PublishingEvaluationDetailCache{RedisEvaluationDetailCache: remote, client: client}
+}
+
+func (cache *PublishingEvaluationDetailCache) Delete(ctx context.Context, evaluationIds ...int64) error {
+	if len(evaluationIds) == 0 {
+		return nil
+	}
+	err := cache.RedisEvaluationDetailCache.Delete(ctx, evaluationIds...)
+	if err != nil {
+		return err
+	}
+	return publishDetailInvalidation(ctx, cache.client, evaluationIds)
+}
+
+// subscribe 监听其它实例的失效广播，自己发的也会收到，重复删除没有影响
+func (cache *TwoLevelEvaluationDetailCache) subscribe() {
+	ctx := context.Background()
+	pubsub := cache.client.Subscribe(ctx, detailInvalidateChannel)
+	defer pubsub.Close()
+	// go-redis 断线会自动重连，重连期间丢掉的消息靠本地缓存的短过期时间兜底
+	for msg := range pubsub.Channel() {
+		for _, s := range strings.Split(msg.Payload, ",") {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				cache.l.Error("解析课评缓存失效消息失败", logger.Error(err), logger.String("payload", msg.Payload))
+				continue
+			}
+			_ = cache.local.Delete(ctx, id)
+		}
+	}
+}
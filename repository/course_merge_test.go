@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"slices"
+	"testing"
+)
+
+// mergeDAO 第一批挪一条、丢一条，第二批结束
+type mergeDAO struct {
+	dao.EvaluationDAO
+	batches []dao.CourseMergeBatch
+}
+
+func (d *mergeDAO) FindOrCreateCourseMergeTask(ctx context.Context, sourceCourseId int64, targetCourseId int64) (dao.CourseMergeTask, error) {
+	return dao.CourseMergeTask{Id: 1}, nil
+}
+
+func (d *mergeDAO) MergeCourseBatch(ctx context.Context, taskId int64, limit int) (dao.CourseMergeBatch, error) {
+	batch := d.batches[0]
+	d.batches = d.batches[1:]
+	return batch, nil
+}
+
+// recordingCaches 记下合并之后删了哪些缓存
+type recordingCaches struct {
+	cache.EvaluationCache
+	cache.EvaluationDetailCache
+	cache.RecentEvaluationCache
+	cache.BloomFilter
+	scoreDeleted  []int64
+	detailDeleted []int64
+	recentDeleted []int64
+}
+
+func (c *recordingCaches) DeleteCompositeScore(ctx context.Context, courseIds ...int64) error {
+	c.scoreDeleted = append(c.scoreDeleted, courseIds...)
+	return nil
+}
+
+func (c *recordingCaches) Delete(ctx context.Context, evaluationIds ...int64) error {
+	c.detailDeleted = append(c.detailDeleted, evaluationIds...)
+	return nil
+}
+
+func (c *recordingCaches) DeleteRecent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64) error {
+	c.recentDeleted = append(c.recentDeleted, evaluationId)
+	return nil
+}
+
+func (c *recordingCaches) Add(ctx context.Context, ids ...int64) error {
+	return nil
+}
+
+type recordingCountCache struct {
+	cache.EvaluationCountCache
+	deleted int
+}
+
+func (c *recordingCountCache) Delete(ctx context.Context, uid int64, courseId int64) error {
+	c.deleted++
+	return nil
+}
+
+func TestEvaluationRepository_MergeCourseInvalidate(t *testing.T) {
+	c := &recordingCaches{}
+	cc := &recordingCountCache{}
+	repo := &evaluationRepository{
+		dao: &mergeDAO{batches: []dao.CourseMergeBatch{
+			{
+				Moved:   []dao.Evaluation{{Id: 1, PublisherId: 1, CourseId: 10, Status: dao.EvaluationStatusPublic}},
+				Dropped: []dao.Evaluation{{Id: 2, PublisherId: 2, CourseId: 20, Status: dao.EvaluationStatusPublic}},
+			},
+			{Done: true},
+		}},
+		cache:       c,
+		detailCache: c,
+		recentCache: c,
+		countCache:  cc,
+		courseBloom: c,
+		l:           logger.NewNopLogger(),
+	}
+	if err := repo.MergeCourse(context.Background(), 10, 20); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(c.scoreDeleted, 10) || !slices.Contains(c.scoreDeleted, 20) {
+		t.Fatalf("两门课的综合得分都要删掉: %v", c.scoreDeleted)
+	}
+	slices.Sort(c.detailDeleted)
+	if !slices.Equal(c.detailDeleted, []int64{1, 2}) {
+		t.Fatalf("挪动和丢弃的课评详情都要删掉: %v", c.detailDeleted)
+	}
+	if !slices.Equal(c.recentDeleted, []int64{2}) {
+		t.Fatalf("丢弃的公开课评要移出时间线: %v", c.recentDeleted)
+	}
+	// 两个作者在两门课上的计数
+	if cc.deleted != 4 {
+		t.Fatalf("删了 %d 个计数缓存，想要 4 个", cc.deleted)
+	}
+}
@@ -0,0 +1,240 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	CourseMergeTaskStatusRunning = 0
+	CourseMergeTaskStatusDone    = 1
+)
+
+// CourseMergeBatch 一批合并的结果，课评都是修改之前的样子
+type CourseMergeBatch struct {
+	// 从源课程挪到目标课程的课评
+	Moved []Evaluation
+	// 同一个用户两门课都评过，只保留较新的一条，较旧的挪进 MergeDroppedEvaluation 留底
+	Dropped []Evaluation
+	// 源课程已经没有课评了，任务结束
+	Done bool
+}
+
+func (dao *GORMEvaluationDAO) FindOrCreateCourseMergeTask(ctx context.Context, sourceCourseId int64,
+	targetCourseId int64) (CourseMergeTask, error) {
+	var task CourseMergeTask
+	// 同一对课程没跑完的任务接着跑
+	err := withContext(dao.db, ctx).
+		Where("source_course_id = ? and target_course_id = ? and status = ?",
+			sourceCourseId, targetCourseId, CourseMergeTaskStatusRunning).
+		First(&task).Error
+	if err == nil || err != gorm.ErrRecordNotFound {
+		return task, err
+	}
+	now := time.Now().UnixMilli()
+	task = CourseMergeTask{
+		SourceCourseId: sourceCourseId,
+		TargetCourseId: targetCourseId,
+		Status:         CourseMergeTaskStatusRunning,
+		Ctime:          now,
+		Utime:          now,
+	}
+	err = dao.db.WithContext(ctx).Create(&task).Error
+	return task, err
+}
+
+func (dao *GORMEvaluationDAO) MergeCourseBatch(ctx context.Context, taskId int64, limit int) (CourseMergeBatch, error) {
+	var batch CourseMergeBatch
+	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var task CourseMergeTask
+		// 锁住任务，同一个任务同时只有一个批次在跑
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", taskId).
+			First(&task).Error
+		if err != nil {
+			return err
+		}
+		if task.Status == CourseMergeTaskStatusDone {
+			batch.Done = true
+			return nil
+		}
+		var sources []Evaluation
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("course_id = ? and id > ?", task.SourceCourseId, task.LastEvaluationId).
+			Order("id").
+			Limit(limit).Find(&sources).Error
+		if err != nil {
+			return err
+		}
+		if len(sources) == 0 {
+			batch.Done = true
+			return tx.Model(&task).Updates(map[string]any{
+				"status": CourseMergeTaskStatusDone,
+				"utime":  time.Now().UnixMilli(),
+			}).Error
+		}
+
+		publisherIds := make([]int64, 0, len(sources))
+		for _, e := range sources {
+			publisherIds = append(publisherIds, e.PublisherId)
+		}
+		var targets []Evaluation
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("course_id = ? and publisher_id IN ?", task.TargetCourseId, publisherIds).
+			Find(&targets).Error
+		if err != nil {
+			return err
+		}
+		conflicts := make(map[int64]Evaluation, len(targets))
+		for _, e := range targets {
+			conflicts[e.PublisherId] = e
+		}
+		now := time.Now().UnixMilli()
+		var audits []MergeDroppedEvaluation
+		for _, e := range sources {
+			t, ok := conflicts[e.PublisherId]
+			switch {
+			case !ok:
+				batch.Moved = append(batch.Moved, e)
+			case e.Utime > t.Utime:
+				// 源课程上的更新，替换掉目标课程上的
+				batch.Dropped = append(batch.Dropped, t)
+				batch.Moved = append(batch.Moved, e)
+				audits = append(audits, newMergeDroppedEvaluation(t, task.Id, e.Id, now))
+			default:
+				batch.Dropped = append(batch.Dropped, e)
+				audits = append(audits, newMergeDroppedEvaluation(e, task.Id, t.Id, now))
+			}
+		}
+		// 被丢弃的课评先留底再删，否则会撞上 publisherId_courseId 唯一索引。
+		// 折叠也不行，折叠的课评还占着唯一索引
+		if len(batch.Dropped) > 0 {
+			err = tx.Create(&audits).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Where("id IN ?", evaluationIds(batch.Dropped)).Delete(&Evaluation{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(batch.Moved) > 0 {
+			err = tx.Model(&Evaluation{}).
+				Where("id IN ?", evaluationIds(batch.Moved)).
+				Update("course_id", task.TargetCourseId).Error
+			if err != nil {
+				return err
+			}
+		}
+		// 下游按课程统计的数据要跟着挪，和课评的变更一起提交
+		for _, e := range batch.Moved {
+			err = insertEvaluationEvent(tx, courseMergedEvent(EvaluationEventTypeMoved, e, task.TargetCourseId))
+			if err != nil {
+				return err
+			}
+		}
+		for _, e := range batch.Dropped {
+			err = insertEvaluationEvent(tx, courseMergedEvent(EvaluationEventTypeDropped, e, task.TargetCourseId))
+			if err != nil {
+				return err
+			}
+		}
+		// 每一批都重算两门课的综合得分，任务中途停下来得分也是对的
+		for _, courseId := range []int64{task.SourceCourseId, task.TargetCourseId} {
+			err = recomputeCompositeScore(tx, courseId)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&task).Updates(map[string]any{
+			"last_evaluation_id": sources[len(sources)-1].Id,
+			"moved_cnt":          task.MovedCnt + int64(len(batch.Moved)),
+			"dropped_cnt":        task.DroppedCnt + int64(len(batch.Dropped)),
+			"utime":              time.Now().UnixMilli(),
+		}).Error
+	})
+	return batch, err
+}
+
+// recomputeCompositeScore 从课评全量重算综合得分和不可见的课评数，不能用增量的公式，合并会同时挪入和删除
+func recomputeCompositeScore(tx *gorm.DB, courseId int64) error {
+	return tx.Exec(dialectOf(tx).recomputeScoreSQL(), courseId,
+		EvaluationStatusPublic, EvaluationStatusPublic, EvaluationStatusPublic, courseId).Error
+}
+
+func courseMergedEvent(typ string, e Evaluation, targetCourseId int64) EvaluationEvent {
+	return EvaluationEvent{
+		Type:         typ,
+		EvaluationId: e.Id,
+		PublisherId:  e.PublisherId,
+		CourseId:     targetCourseId,
+		OldCourseId:  e.CourseId,
+		OldStatus:    e.Status,
+		NewStatus:    e.Status,
+		OldRating:    e.StarRating,
+		NewRating:    e.StarRating,
+	}
+}
+
+func evaluationIds(evaluations []Evaluation) []int64 {
+	ids := make([]int64, 0, len(evaluations))
+	for _, e := range evaluations {
+		ids = append(ids, e.Id)
+	}
+	return ids
+}
+
+// CourseMergeTask 记录合并进度，中途失败之后从LastEvaluationId接着跑
+type CourseMergeTask struct {
+	Id               int64 `gorm:"primaryKey,autoIncrement"`
+	SourceCourseId   int64 `gorm:"index:sourceCourseId_targetCourseId"`
+	TargetCourseId   int64 `gorm:"index:sourceCourseId_targetCourseId"`
+	Status           int32
+	LastEvaluationId int64
+	MovedCnt         int64
+	DroppedCnt       int64
+	Utime            int64
+	Ctime            int64
+}
+
+// MergeDroppedEvaluation 合并课程时被丢弃的课评，原样留底，需要的时候可以人工恢复
+type MergeDroppedEvaluation struct {
+	// 就是原来的课评id
+	Id             int64 `gorm:"primaryKey;autoIncrement:false"`
+	TaskId         int64 `gorm:"index"`
+	PublisherId    int64
+	CourseId       int64
+	CourseProperty int32
+	StarRating     uint8
+	Content        string
+	Status         int32
+	IsAnonymous    bool
+	Version        int64
+	Utime          int64
+	Ctime          int64
+	// 保留下来的那条课评
+	KeptEvaluationId int64
+	// 被丢弃的时间
+	Dtime int64
+}
+
+func newMergeDroppedEvaluation(e Evaluation, taskId int64, keptEvaluationId int64, now int64) MergeDroppedEvaluation {
+	return MergeDroppedEvaluation{
+		Id:               e.Id,
+		TaskId:           taskId,
+		PublisherId:      e.PublisherId,
+		CourseId:         e.CourseId,
+		CourseProperty:   e.CourseProperty,
+		StarRating:       e.StarRating,
+		Content:          e.Content,
+		Status:           e.Status,
+		IsAnonymous:      e.IsAnonymous,
+		Version:          e.Version,
+		Utime:            e.Utime,
+		Ctime:            e.Ctime,
+		KeptEvaluationId: keptEvaluationId,
+		Dtime:            now,
+	}
+}
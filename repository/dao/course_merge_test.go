@@ -0,0 +1,98 @@
+package dao
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGORMEvaluationDAO_MergeCourseBatch(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := NewGORMEvaluationDAO(db, newTestIdGen(t)).(*GORMEvaluationDAO)
+	evaluations := []Evaluation{
+		// 只在源课程上评过，挪过去
+		{Id: 1, PublisherId: 1, CourseId: 10, StarRating: 5, Status: EvaluationStatusPublic, Utime: 100},
+		// 源课程上的更新，目标课程上的那条被丢弃
+		{Id: 2, PublisherId: 2, CourseId: 10, StarRating: 4, Status: EvaluationStatusPublic, Utime: 200},
+		{Id: 3, PublisherId: 2, CourseId: 20, StarRating: 1, Status: EvaluationStatusPublic, Utime: 100},
+		// 目标课程上的更新，源课程上的那条被丢弃
+		{Id: 4, PublisherId: 3, CourseId: 10, StarRating: 2, Status: EvaluationStatusPublic, Content: "旧的", Utime: 100},
+		{Id: 5, PublisherId: 3, CourseId: 20, StarRating: 3, Status: EvaluationStatusPublic, Utime: 200},
+	}
+	if err := db.Create(&evaluations).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := d.FindOrCreateCourseMergeTask(ctx, 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := d.MergeCourseBatch(ctx, task.Id, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := evaluationIds(batch.Moved); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("挪动的课评 %v", got)
+	}
+	if got := evaluationIds(batch.Dropped); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Fatalf("丢弃的课评 %v", got)
+	}
+
+	var left []Evaluation
+	db.Order("id").Find(&left)
+	if got := evaluationIds(left); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 5 {
+		t.Fatalf("剩下的课评 %v", got)
+	}
+	for _, e := range left {
+		if e.CourseId != 20 {
+			t.Fatalf("课评 %d 还在课程 %d 上", e.Id, e.CourseId)
+		}
+	}
+
+	// 丢弃的课评原样留底
+	var dropped []MergeDroppedEvaluation
+	db.Order("id").Find(&dropped)
+	if len(dropped) != 2 {
+		t.Fatalf("留底了 %d 条", len(dropped))
+	}
+	if dropped[0].Id != 3 || dropped[0].CourseId != 20 || dropped[0].KeptEvaluationId != 2 {
+		t.Fatalf("留底的课评 %+v", dropped[0])
+	}
+	if dropped[1].Id != 4 || dropped[1].CourseId != 10 || dropped[1].Content != "旧的" || dropped[1].KeptEvaluationId != 5 {
+		t.Fatalf("留底的课评 %+v", dropped[1])
+	}
+
+	// 和课评的变更在同一个事务里面写进发件箱
+	var evts []EvaluationEvent
+	db.Order("id").Find(&evts)
+	want := []EvaluationEvent{
+		{Type: EvaluationEventTypeMoved, EvaluationId: 1, OldCourseId: 10, CourseId: 20},
+		{Type: EvaluationEventTypeMoved, EvaluationId: 2, OldCourseId: 10, CourseId: 20},
+		{Type: EvaluationEventTypeDropped, EvaluationId: 3, OldCourseId: 20, CourseId: 20},
+		{Type: EvaluationEventTypeDropped, EvaluationId: 4, OldCourseId: 10, CourseId: 20},
+	}
+	if len(evts) != len(want) {
+		t.Fatalf("写了 %d 个事件，想要 %d 个", len(evts), len(want))
+	}
+	for i, w := range want {
+		e := evts[i]
+		if e.Type != w.Type || e.EvaluationId != w.EvaluationId || e.OldCourseId != w.OldCourseId || e.CourseId != w.CourseId {
+			t.Fatalf("第 %d 个事件 %+v，想要 %+v", i, e, w)
+		}
+	}
+
+	var score CompositeScore
+	db.Where("course_id = ?", 20).First(&score)
+	if score.RaterCnt != 3 || score.Score != 4 {
+		t.Fatalf("目标课程的综合得分 %+v", score)
+	}
+	// 不可见的课评数和综合得分一起重算
+	if score.InvisibleCnt == nil || *score.InvisibleCnt != 0 {
+		t.Fatalf("目标课程不可见的课评数 %v", score.InvisibleCnt)
+	}
+
+	batch, err = d.MergeCourseBatch(ctx, task.Id, 100)
+	if err != nil || !batch.Done {
+		t.Fatalf("源课程已经没有课评了: %+v, %v", batch, err)
+	}
+}
@@ -0,0 +1,113 @@
+package dao
+
+import (
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+const mysqlErrDuplicateEntry uint16 = 1062
+
+// dialect 各个数据库语法不一样的地方。行锁交给gorm的方言处理，sqlite不支持行锁，gorm会直接去掉
+type dialect interface {
+	// upsertRatingSQL 课程还没有综合得分就创建，有就把一个新的评分算进去，参数依次是 course_id, score
+	upsertRatingSQL() string
+	// upsertInvisibleSQL 课程还没有综合得分就创建，有就把不可见的课评数加一，参数是 course_id
+	upsertInvisibleSQL() string
+	// recomputeScoreSQL 从课评全量重算综合得分和不可见的课评数并写回，参数依次是 course_id, status, status, status, course_id
+	recomputeScoreSQL() string
+	isDuplicateEntry(err error) bool
+}
+
+func dialectOf(db *gorm.DB) dialect {
+	switch db.Dialector.Name() {
+	case "postgres", "sqlite":
+		return onConflictDialect{}
+	default:
+		return mysqlDialect{}
+	}
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) upsertRatingSQL() string {
+	// mysql按顺序计算SET，算score的时候rater_cnt还是旧的
+	return `
+		INSERT INTO composite_scores (course_id, score, rater_cnt, invisible_cnt)
+		VALUES (?, ?, 1, 0)
+		ON DUPLICATE KEY UPDATE
+		    score = ((score * rater_cnt + VALUES(score)) / (rater_cnt + 1)),
+		    rater_cnt = rater_cnt + 1
+		`
+}
+
+func (mysqlDialect) upsertInvisibleSQL() string {
+	return `
+		INSERT INTO composite_scores (course_id, score, rater_cnt, invisible_cnt)
+		VALUES (?, 0, 0, 1)
+		ON DUPLICATE KEY UPDATE
+		    invisible_cnt = invisible_cnt + 1
+		`
+}
+
+func (mysqlDialect) recomputeScoreSQL() string {
+	return `
+	INSERT INTO composite_scores (course_id, score, rater_cnt, invisible_cnt)
+	SELECT ?, COALESCE(AVG(CASE WHEN status = ? THEN star_rating END), 0),
+	       COUNT(CASE WHEN status = ? THEN 1 END), COUNT(CASE WHEN status != ? THEN 1 END)
+	FROM evaluations
+	WHERE course_id = ?
+	ON DUPLICATE KEY UPDATE
+	    score = VALUES(score),
+	    rater_cnt = VALUES(rater_cnt),
+	    invisible_cnt = VALUES(invisible_cnt)
+	`
+}
+
+func (mysqlDialect) isDuplicateEntry(err error) bool {
+	// AT数据源会包一层错误，所以用errors.As
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == mysqlErrDuplicateEntry || errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// onConflictDialect postgres和sqlite的upsert语法是一样的，SET里面引用的都是旧值
+type onConflictDialect struct{}
+
+func (onConflictDialect) upsertRatingSQL() string {
+	return `
+		INSERT INTO composite_scores (course_id, score, rater_cnt, invisible_cnt)
+		VALUES (?, ?, 1, 0)
+		ON CONFLICT (course_id) DO UPDATE SET
+		    score = (composite_scores.score * composite_scores.rater_cnt + EXCLUDED.score) / (composite_scores.rater_cnt + 1),
+		    rater_cnt = composite_scores.rater_cnt + 1
+		`
+}
+
+func (onConflictDialect) upsertInvisibleSQL() string {
+	return `
+		INSERT INTO composite_scores (course_id, score, rater_cnt, invisible_cnt)
+		VALUES (?, 0, 0, 1)
+		ON CONFLICT (course_id) DO UPDATE SET
+		    invisible_cnt = composite_scores.invisible_cnt + 1
+		`
+}
+
+func (onConflictDialect) recomputeScoreSQL() string {
+	// SELECT里面的参数postgres推断不出类型，要显式转换
+	return `
+	INSERT INTO composite_scores (course_id, score, rater_cnt, invisible_cnt)
+	SELECT CAST(? AS BIGINT), COALESCE(AVG(CASE WHEN status = ? THEN star_rating END), 0),
+	       COUNT(CASE WHEN status = ? THEN 1 END), COUNT(CASE WHEN status != ? THEN 1 END)
+	FROM evaluations
+	WHERE course_id = ?
+	ON CONFLICT (course_id) DO UPDATE SET
+	    score = EXCLUDED.score,
+	    rater_cnt = EXCLUDED.rater_cnt,
+	    invisible_cnt = EXCLUDED.invisible_cnt
+	`
+}
+
+// isDuplicateEntry 依赖 gorm.Config.TranslateError 把驱动的错误翻译过来
+func (onConflictDialect) isDuplicateEntry(err error) bool {
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
@@ -0,0 +1,271 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"sync/atomic"
+)
+
+// 迁移的四个阶段，按顺序切换，每一步都可以回退到上一步
+const (
+	PatternSrcOnly  = "SRC_ONLY"
+	PatternSrcFirst = "SRC_FIRST"
+	PatternDstFirst = "DST_FIRST"
+	PatternDstOnly  = "DST_ONLY"
+)
+
+var ErrUnknownPattern = errors.New("未知的双写模式")
+
+// DoubleWriteDAO 在线迁移用的双写。读只读主库，写先写主库再写从库，
+// 从库写失败只记日志不影响业务，由校验修复补上。
+// 课程合并只在主库上执行，迁移期间合并过课程要跑一次校验修复
+type DoubleWriteDAO struct {
+	src     EvaluationDAO
+	dst     EvaluationDAO
+	pattern atomic.Value
+	l       logger.Logger
+}
+
+func NewDoubleWriteDAO(src EvaluationDAO, dst EvaluationDAO, pattern string, l logger.Logger) (*DoubleWriteDAO, error) {
+	res := &DoubleWriteDAO{src: src, dst: dst, l: l}
+	return res, res.UpdatePattern(pattern)
+}
+
+func (d *DoubleWriteDAO) UpdatePattern(pattern string) error {
+	switch pattern {
+	case PatternSrcOnly, PatternSrcFirst, PatternDstFirst, PatternDstOnly:
+		d.pattern.Store(pattern)
+		return nil
+	default:
+		return ErrUnknownPattern
+	}
+}
+
+func (d *DoubleWriteDAO) Pattern() string {
+	return d.pattern.Load().(string)
+}
+
+// primary 读和第一个写的库，secondary 为nil表示不需要双写
+func (d *DoubleWriteDAO) route() (primary EvaluationDAO, secondary EvaluationDAO) {
+	switch d.Pattern() {
+	case PatternSrcOnly:
+		return d.src, nil
+	case PatternSrcFirst:
+		return d.src, d.dst
+	case PatternDstFirst:
+		return d.dst, d.src
+	default:
+		return d.dst, nil
+	}
+}
+
+func (d *DoubleWriteDAO) read() EvaluationDAO {
+	primary, _ := d.route()
+	return primary
+}
+
+// write 主库成功之后再写从库，从库不发领域事件，否则同一次变更会发两遍
+func (d *DoubleWriteDAO) write(ctx context.Context, name string,
+	fn func(ctx context.Context, dao EvaluationDAO, isPrimary bool) error) error {
+	primary, secondary := d.route()
+	err := fn(ctx, primary, true)
+	if err != nil || secondary == nil {
+		return err
+	}
+	if er := fn(withoutEvents(ctx), secondary, false); er != nil {
+		d.l.Error("双写从库失败", logger.Error(er), logger.String("method", name), logger.String("pattern", d.Pattern()))
+	}
+	return nil
+}
+
+func (d *DoubleWriteDAO) FindEvaluation(ctx context.Context, publisherId int64, courseId int64) (Evaluation, error) {
+	return d.read().FindEvaluation(ctx, publisherId, courseId)
+}
+
+func (d *DoubleWriteDAO) UpdateStatus(ctx context.Context, evaluationId int64, status uint32, uid int64) (OldEvaluation, error) {
+	var oe OldEvaluation
+	err := d.write(ctx, "UpdateStatus", func(ctx context.Context, dao EvaluationDAO, isPrimary bool) error {
+		if !isPrimary {
+			ctx = withSyncedVersion(ctx, oe.Version+1)
+		}
+		res, err := dao.UpdateStatus(ctx, evaluationId, status, uid)
+		if isPrimary {
+			oe = res
+		}
+		return err
+	})
+	return oe, err
+}
+
+func (d *DoubleWriteDAO) UpdateById(ctx context.Context, evaluation Evaluation) (OldEvaluation, error) {
+	var oe OldEvaluation
+	err := d.write(ctx, "UpdateById", func(ctx context.Context, dao EvaluationDAO, isPrimary bool) error {
+		if !isPrimary {
+			ctx = withSyncedVersion(ctx, oe.Version+1)
+		}
+		res, err := dao.UpdateById(ctx, evaluation)
+		if isPrimary {
+			oe = res
+		}
+		return err
+	})
+	return oe, err
+}
+
+func (d *DoubleWriteDAO) Insert(ctx context.Context, evaluation Evaluation) (int64, error) {
+	err := d.write(ctx, "Insert", func(ctx context.Context, dao EvaluationDAO, isPrimary bool) error {
+		// 从库沿用主库生成的id
+		id, err := dao.Insert(ctx, evaluation)
+		if isPrimary {
+			evaluation.Id = id
+		}
+		return err
+	})
+	return evaluation.Id, err
+}
+
+func (d *DoubleWriteDAO) InsertWithTime(ctx context.Context, evaluation Evaluation) (int64, error) {
+	err := d.write(ctx, "InsertWithTime", func(ctx context.Context, dao EvaluationDAO, isPrimary bool) error {
+		id, err := dao.InsertWithTime(ctx, evaluation)
+		if isPrimary {
+			evaluation.Id = id
+		}
+		return err
+	})
+	return evaluation.Id, err
+}
+
+func (d *DoubleWriteDAO) GetListRecent(ctx context.Context, curEvaluationId int64, limit int64, property int32) ([]Evaluation, error) {
+	return d.read().GetListRecent(ctx, curEvaluationId, limit, property)
+}
+
+func (d *DoubleWriteDAO) GetListRecentTimeline(ctx context.Context, limit int64, property int32) ([]Evaluation, error) {
+	return d.read().GetListRecentTimeline(ctx, limit, property)
+}
+
+func (d *DoubleWriteDAO) GetListByIds(ctx context.Context, ids []int64) ([]Evaluation, error) {
+	return d.read().GetListByIds(ctx, ids)
+}
+
+func (d *DoubleWriteDAO) GetListCourse(ctx context.Context, curEvaluationId int64, limit int64, courseId int64) ([]Evaluation, error) {
+	return d.read().GetListCourse(ctx, curEvaluationId, limit, courseId)
+}
+
+func (d *DoubleWriteDAO) GetListMine(ctx context.Context, curEvaluationId int64, limit int64, uid int64, status int32) ([]Evaluation, error) {
+	return d.read().GetListMine(ctx, curEvaluationId, limit, uid, status)
+}
+
+func (d *DoubleWriteDAO) GetListCourseIds(ctx context.Context, curEvaluationId int64, limit int64, courseId int64) ([]int64, error) {
+	return d.read().GetListCourseIds(ctx, curEvaluationId, limit, courseId)
+}
+
+func (d *DoubleWriteDAO) GetListMineIds(ctx context.Context, curEvaluationId int64, limit int64, uid int64, status int32) ([]int64, error) {
+	return d.read().GetListMineIds(ctx, curEvaluationId, limit, uid, status)
+}
+
+func (d *DoubleWriteDAO) GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
+	return d.read().GetCountCourseInvisible(ctx, courseId)
+}
+
+func (d *DoubleWriteDAO) GetCountMine(ctx context.Context, uid int64, status int32) (int64, error) {
+	return d.read().GetCountMine(ctx, uid, status)
+}
+
+func (d *DoubleWriteDAO) GetCountMineGroupByStatus(ctx context.Context, uid int64) (map[int32]int64, error) {
+	return d.read().GetCountMineGroupByStatus(ctx, uid)
+}
+
+func (d *DoubleWriteDAO) GetDetailById(ctx context.Context, evaluationId int64) (Evaluation, error) {
+	return d.read().GetDetailById(ctx, evaluationId)
+}
+
+func (d *DoubleWriteDAO) GetPublishersByCourseIdStatus(ctx context.Context, courseId int64, status int32) ([]int64, error) {
+	return d.read().GetPublishersByCourseIdStatus(ctx, courseId, status)
+}
+
+func (d *DoubleWriteDAO) GetCompositeScoreByCourseId(ctx context.Context, courseId int64) (CompositeScore, error) {
+	return d.read().GetCompositeScoreByCourseId(ctx, courseId)
+}
+
+func (d *DoubleWriteDAO) UpdateIsAnonymousById(ctx context.Context, uid int64, courseId int64, fields map[string]any) error {
+	return d.write(ctx, "UpdateIsAnonymousById", func(ctx context.Context, dao EvaluationDAO, isPrimary bool) error {
+		return dao.UpdateIsAnonymousById(ctx, uid, courseId, fields)
+	})
+}
+
+func (d *DoubleWriteDAO) GetIdsAfter(ctx context.Context, startId int64, limit int) ([]int64, error) {
+	return d.read().GetIdsAfter(ctx, startId, limit)
+}
+
+func (d *DoubleWriteDAO) GetCourseIdsAfter(ctx context.Context, startCourseId int64, limit int) ([]int64, error) {
+	return d.read().GetCourseIdsAfter(ctx, startCourseId, limit)
+}
+
+func (d *DoubleWriteDAO) UpdateCourseProperty(ctx context.Context, courseId int64, property int32, limit int) ([]Evaluation, error) {
+	var evaluations []Evaluation
+	err := d.write(ctx, "UpdateCourseProperty", func(ctx context.Context, dao EvaluationDAO, isPrimary bool) error {
+		res, err := dao.UpdateCourseProperty(ctx, courseId, property, limit)
+		if isPrimary {
+			evaluations = res
+		}
+		return err
+	})
+	return evaluations, err
+}
+
+func (d *DoubleWriteDAO) FindOrCreateCourseMergeTask(ctx context.Context, sourceCourseId int64, targetCourseId int64) (CourseMergeTask, error) {
+	return d.read().FindOrCreateCourseMergeTask(ctx, sourceCourseId, targetCourseId)
+}
+
+func (d *DoubleWriteDAO) MergeCourseBatch(ctx context.Context, taskId int64, limit int) (CourseMergeBatch, error) {
+	return d.read().MergeCourseBatch(ctx, taskId, limit)
+}
+
+func (d *DoubleWriteDAO) GetListForExport(ctx context.Context, filter ExportFilter, afterId int64, limit int) ([]Evaluation, error) {
+	return d.read().GetListForExport(ctx, filter, afterId, limit)
+}
+
+// DoubleWriteEvaluationEventDAO 事件只写在当时的主库里面，切换前后两边的发件箱都可能有积压，两边都要投递
+type DoubleWriteEvaluationEventDAO struct {
+	src EvaluationEventDAO
+	dst EvaluationEventDAO
+}
+
+func NewDoubleWriteEvaluationEventDAO(src EvaluationEventDAO, dst EvaluationEventDAO) EvaluationEventDAO {
+	return &DoubleWriteEvaluationEventDAO{src: src, dst: dst}
+}
+
+func (d *DoubleWriteEvaluationEventDAO) ConsumePending(ctx context.Context, limit int, fn func(evt EvaluationEvent) error) (int, error) {
+	n, err := d.src.ConsumePending(ctx, limit, fn)
+	if err != nil || n == limit {
+		return n, err
+	}
+	m, err := d.dst.ConsumePending(ctx, limit-n, fn)
+	return n + m, err
+}
+
+type withoutEventsKey struct{}
+
+// withoutEvents 从库的写入不进发件箱
+func withoutEvents(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutEventsKey{}, true)
+}
+
+func isWithoutEvents(ctx context.Context) bool {
+	v, _ := ctx.Value(withoutEventsKey{}).(bool)
+	return v
+}
+
+type syncedVersionKey struct{}
+
+// withSyncedVersion 从库不检查版本号，直接改成主库写入之后的版本号。
+// 从库有一次写失败版本号就和主库对不上了，再检查的话这条课评在从库上以后都写不进去
+func withSyncedVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, syncedVersionKey{}, version)
+}
+
+func syncedVersion(ctx context.Context) (int64, bool) {
+	v, ok := ctx.Value(syncedVersionKey{}).(int64)
+	return v, ok
+}
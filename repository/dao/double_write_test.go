@@ -0,0 +1,48 @@
+package dao
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"testing"
+)
+
+// TestDoubleWriteDAO_SecondaryVersion 从库的版本号落后了也要能写进去，写完和主库一致
+func TestDoubleWriteDAO_SecondaryVersion(t *testing.T) {
+	ctx := context.Background()
+	src, dst := newTestDB(t), newTestDB(t)
+	idGen := newTestIdGen(t)
+	d, err := NewDoubleWriteDAO(NewGORMEvaluationDAO(src, idGen), NewGORMEvaluationDAO(dst, idGen), PatternSrcFirst,
+		logger.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := d.Insert(ctx, Evaluation{PublisherId: 1, CourseId: 2, StarRating: 5, Status: EvaluationStatusPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟之前有一次从库没写进去，主库已经是版本 3 了
+	if err = src.Model(&Evaluation{}).Where("id = ?", id).Update("version", 3).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.UpdateById(ctx, Evaluation{Id: id, PublisherId: 1, StarRating: 4, Content: "改过了",
+		Status: EvaluationStatusPublic, Version: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var se, de Evaluation
+	src.First(&se, id)
+	dst.First(&de, id)
+	if se.Version != 4 || de.Version != 4 || de.Content != "改过了" {
+		t.Fatalf("主库 %+v，从库 %+v", se, de)
+	}
+
+	if _, err = d.UpdateStatus(ctx, id, EvaluationStatusPrivate, 1); err != nil {
+		t.Fatal(err)
+	}
+	src.First(&se, id)
+	dst.First(&de, id)
+	if se.Version != 5 || de.Version != 5 || de.Status != EvaluationStatusPrivate {
+		t.Fatalf("主库 %+v，从库 %+v", se, de)
+	}
+}
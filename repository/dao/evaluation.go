@@ -3,12 +3,24 @@ package dao
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"time"
 )
 
-var ErrorRecordNotFind = gorm.ErrRecordNotFound
+var (
+	ErrorRecordNotFind = gorm.ErrRecordNotFound
+	// ErrDuplicateEvaluation 同一个用户对同一门课已经有课评了
+	ErrDuplicateEvaluation = errors.New("课评已存在")
+	// ErrPermissionDenied 课评存在，但是不是这个用户发的
+	ErrPermissionDenied = errors.New("没有权限修改这条课评")
+	// ErrIllegalStatusTransition 课评当前的状态不允许这样变更，比如折叠的课评作者不能再改状态
+	ErrIllegalStatusTransition = errors.New("非法的课评状态变更")
+	// ErrTooManyRequests 数据库请求被限流
+	ErrTooManyRequests = errors.New("数据库请求限流")
+)
 
 type EvaluationDAO interface {
 	FindEvaluation(ctx context.Context, publisherId int64, courseId int64) (Evaluation, error)
@@ -16,17 +28,47 @@ type EvaluationDAO interface {
 	// 更新课评并返回旧的星级
 	UpdateById(ctx context.Context, evaluation Evaluation) (OldEvaluation, error)
 	Insert(ctx context.Context, evaluation Evaluation) (int64, error)
-	// 这里是给迁移脚本是用的insert,ctime和utime也通过上层传入
+	// 这里是给迁移脚本是用的insert,ctime和utime也通过上层传入，迁移的是历史数据，不产生领域事件
 	InsertWithTime(ctx context.Context, evaluation Evaluation) (int64, error)
 	GetListRecent(ctx context.Context, curEvaluationId int64, limit int64, property int32) ([]Evaluation, error)
+	// 只查id和utime，用于重建最近课评时间线
+	GetListRecentTimeline(ctx context.Context, limit int64, property int32) ([]Evaluation, error)
+	GetListByIds(ctx context.Context, ids []int64) ([]Evaluation, error)
 	GetListCourse(ctx context.Context, curEvaluationId int64, limit int64, courseId int64) ([]Evaluation, error)
 	GetListMine(ctx context.Context, curEvaluationId int64, limit int64, uid int64, status int32) ([]Evaluation, error)
+	// 列表只查id，课评实体从缓存里面取
+	GetListCourseIds(ctx context.Context, curEvaluationId int64, limit int64, courseId int64) ([]int64, error)
+	GetListMineIds(ctx context.Context, curEvaluationId int64, limit int64, uid int64, status int32) ([]int64, error)
 	GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error)
 	GetCountMine(ctx context.Context, uid int64, status int32) (int64, error)
+	// 一次把用户各个状态的课评数都查出来，用于重建计数缓存
+	GetCountMineGroupByStatus(ctx context.Context, uid int64) (map[int32]int64, error)
 	GetDetailById(ctx context.Context, evaluationId int64) (Evaluation, error)
 	GetPublishersByCourseIdStatus(ctx context.Context, courseId int64, status int32) ([]int64, error)
 	GetCompositeScoreByCourseId(ctx context.Context, courseId int64) (CompositeScore, error)
 	UpdateIsAnonymousById(background context.Context, uid int64, courseId int64, fields map[string]any) error
+	// 按主键顺序分批扫描，用于重建布隆过滤器
+	GetIdsAfter(ctx context.Context, startId int64, limit int) ([]int64, error)
+	GetCourseIdsAfter(ctx context.Context, startCourseId int64, limit int) ([]int64, error)
+	// UpdateCourseProperty 把课程下课程性质不一致的课评改成新的性质，一次最多改limit条，
+	// 返回被修改的课评修改之前的样子，返回空说明已经全部一致
+	UpdateCourseProperty(ctx context.Context, courseId int64, property int32, limit int) ([]Evaluation, error)
+	// 合并重复的课程，找到没跑完的任务就接着跑
+	FindOrCreateCourseMergeTask(ctx context.Context, sourceCourseId int64, targetCourseId int64) (CourseMergeTask, error)
+	// 在一个事务里面挪一批课评，并且记录进度
+	MergeCourseBatch(ctx context.Context, taskId int64, limit int) (CourseMergeBatch, error)
+	// 按主键顺序导出id大于afterId的课评，导出的数据量很大，不能用offset翻页
+	GetListForExport(ctx context.Context, filter ExportFilter, afterId int64, limit int) ([]Evaluation, error)
+}
+
+// ExportFilter 零值表示不过滤
+type ExportFilter struct {
+	CourseId       int64
+	CourseProperty int32
+	Status         int32
+	// 按ctime过滤，毫秒时间戳，左闭右开
+	StartTime int64
+	EndTime   int64
 }
 
 const (
@@ -37,33 +79,101 @@ const (
 
 type GORMEvaluationDAO struct {
 	db *gorm.DB
+	// 不用自增主键，自增的id没法分库，还会暴露课评总数
+	idGen idgen.Generator
 }
 
 func (dao *GORMEvaluationDAO) UpdateIsAnonymousById(ctx context.Context, uid int64, courseId int64, fields map[string]any) error {
-	return dao.db.WithContext(ctx).Model(&Evaluation{}).Where("publisher_id = ? and course_id = ?", uid, courseId).Updates(fields).Error
+	return withContext(dao.db, ctx).Model(&Evaluation{}).Where("publisher_id = ? and course_id = ?", uid, courseId).Updates(fields).Error
 }
 
-func (dao *GORMEvaluationDAO) InsertWithTime(ctx context.Context, evaluation Evaluation) (int64, error) {
+func (dao *GORMEvaluationDAO) GetIdsAfter(ctx context.Context, startId int64, limit int) ([]int64, error) {
+	var ids []int64
+	err := withContext(dao.db, ctx).
+		Model(&Evaluation{}).
+		Select("id").
+		Where("id > ?", startId).
+		Order("id").
+		Limit(limit).Find(&ids).Error
+	return ids, err
+}
+
+func (dao *GORMEvaluationDAO) GetCourseIdsAfter(ctx context.Context, startCourseId int64, limit int) ([]int64, error) {
+	var courseIds []int64
+	err := withContext(dao.db, ctx).
+		Model(&Evaluation{}).
+		Distinct("course_id").
+		Where("course_id > ?", startCourseId).
+		Order("course_id").
+		Limit(limit).Find(&courseIds).Error
+	return courseIds, err
+}
+
+func (dao *GORMEvaluationDAO) UpdateCourseProperty(ctx context.Context, courseId int64, property int32, limit int) ([]Evaluation, error) {
+	var evaluations []Evaluation
 	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Evaluation{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id, course_property, status, utime").
+			Where("course_id = ? and course_property != ?", courseId, property).
+			Order("id").
+			Limit(limit).Find(&evaluations).Error
+		if err != nil || len(evaluations) == 0 {
+			return err
+		}
+		ids := make([]int64, 0, len(evaluations))
+		for _, e := range evaluations {
+			ids = append(ids, e.Id)
+		}
+		// 不刷新utime，课程改了性质不代表课评有更新，不应该改变它在时间线里面的位置
+		return tx.Model(&Evaluation{}).
+			Where("id IN ?", ids).
+			Update("course_property", property).Error
+	})
+	return evaluations, err
+}
+
+func (dao *GORMEvaluationDAO) GetListForExport(ctx context.Context, filter ExportFilter, afterId int64, limit int) ([]Evaluation, error) {
+	var evaluations []Evaluation
+	const CoursePropertyAny = 0
+	query := withContext(dao.db, ctx).Where("id > ? and status = ?", afterId, filter.Status)
+	if filter.CourseId > 0 {
+		query = query.Where("course_id = ?", filter.CourseId)
+	}
+	if filter.CourseProperty != CoursePropertyAny {
+		query = query.Where("course_property = ?", filter.CourseProperty)
+	}
+	if filter.StartTime > 0 {
+		query = query.Where("ctime >= ?", filter.StartTime)
+	}
+	if filter.EndTime > 0 {
+		query = query.Where("ctime < ?", filter.EndTime)
+	}
+	err := query.Order("id").Limit(limit).Find(&evaluations).Error
+	return evaluations, err
+}
+
+func (dao *GORMEvaluationDAO) InsertWithTime(ctx context.Context, evaluation Evaluation) (int64, error) {
+	err := dao.fillId(ctx, &evaluation)
+	if err != nil {
+		return 0, err
+	}
+	err = dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// 创建评价记录
 		err := tx.Create(&evaluation).Error
+		if dialectOf(tx).isDuplicateEntry(err) {
+			// 重复导入的时候可以据此跳过
+			return ErrDuplicateEvaluation
+		}
 		if err != nil {
 			return err
 		}
-		if evaluation.Status == EvaluationStatusPrivate {
-			// 非公开的课评，不计入评分
-			return nil
+		if evaluation.Status != EvaluationStatusPublic {
+			// 非公开的课评，不计入评分，历史数据里面可能有折叠的
+			return tx.Exec(dialectOf(tx).upsertInvisibleSQL(), evaluation.CourseId).Error
 		}
 		// 使用 upsert 来更新或插入分数
-		sql := `
-		INSERT INTO composite_scores (course_id, score, rater_cnt)
-		VALUES (?, ?, 1)
-		ON DUPLICATE KEY UPDATE 
-		    score = ((score * rater_cnt + VALUES(score)) / (rater_cnt + 1)),
-		    rater_cnt = rater_cnt + 1
-		`
-		// 执行 SQL 更新操作
-		return tx.Exec(sql, evaluation.CourseId, float64(evaluation.StarRating)).Error
+		return tx.Exec(dialectOf(tx).upsertRatingSQL(), evaluation.CourseId, float64(evaluation.StarRating)).Error
 	})
 
 	if err != nil {
@@ -75,13 +185,13 @@ func (dao *GORMEvaluationDAO) InsertWithTime(ctx context.Context, evaluation Eva
 func (dao *GORMEvaluationDAO) GetCompositeScoreByCourseId(ctx context.Context, courseId int64) (CompositeScore, error) {
 	// 这是聚合的写法
 	//var averageRating float64
-	//err := dao.db.WithContext(ctx).
+	//err := withContext(dao.db, ctx).
 	//	Model(&Evaluation{}).
 	//	Select("COALESCE(AVG(CAST(star_rating as double)), 0) as average_rating").
 	//	Where("course_id = ? and status = ?", courseId, EvaluationStatusPublic).
 	//	First(&averageRating).Error
 	var cs CompositeScore
-	err := dao.db.WithContext(ctx).
+	err := withContext(dao.db, ctx).
 		Where("course_id = ?", courseId).
 		First(&cs).Error
 	return cs, err
@@ -89,7 +199,7 @@ func (dao *GORMEvaluationDAO) GetCompositeScoreByCourseId(ctx context.Context, c
 
 func (dao *GORMEvaluationDAO) GetPublishersByCourseIdStatus(ctx context.Context, courseId int64, status int32) ([]int64, error) {
 	var publishers []int64
-	err := dao.db.WithContext(ctx).
+	err := withContext(dao.db, ctx).
 		Select("publisher_id").
 		Model(&Evaluation{}).
 		Where("course_id = ? and status = ?", courseId, status).
@@ -99,7 +209,7 @@ func (dao *GORMEvaluationDAO) GetPublishersByCourseIdStatus(ctx context.Context,
 
 func (dao *GORMEvaluationDAO) GetDetailById(ctx context.Context, evaluationId int64) (Evaluation, error) {
 	var evaluation Evaluation
-	err := dao.db.WithContext(ctx).
+	err := withContext(dao.db, ctx).
 		Where("id = ?", evaluationId).
 		First(&evaluation).Error
 	return evaluation, err
@@ -107,16 +217,65 @@ func (dao *GORMEvaluationDAO) GetDetailById(ctx context.Context, evaluationId in
 
 func (dao *GORMEvaluationDAO) GetCountMine(ctx context.Context, uid int64, status int32) (int64, error) {
 	var count int64
-	err := dao.db.WithContext(ctx).
+	err := withContext(dao.db, ctx).
 		Model(&Evaluation{}).
 		Where("publisher_id = ? and status = ?", uid, status).
 		Count(&count).Error
 	return count, err
 }
 
+func (dao *GORMEvaluationDAO) GetCountMineGroupByStatus(ctx context.Context, uid int64) (map[int32]int64, error) {
+	var rows []struct {
+		Status int32
+		Cnt    int64
+	}
+	err := withContext(dao.db, ctx).
+		Model(&Evaluation{}).
+		Select("status, count(*) as cnt").
+		Where("publisher_id = ?", uid).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int32]int64, len(rows))
+	for _, row := range rows {
+		res[row.Status] = row.Cnt
+	}
+	return res, nil
+}
+
+// GetCountCourseInvisible 不可见的课评数和综合得分在同一个事务里面维护，直接读综合得分这一行
 func (dao *GORMEvaluationDAO) GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
+	var cs CompositeScore
+	err := withContext(dao.db, ctx).
+		Where("course_id = ?", courseId).
+		First(&cs).Error
+	switch {
+	case err == ErrorRecordNotFind:
+		// 加这一列之前，只有不公开课评的课程没有综合得分
+		return dao.countCourseInvisible(ctx, courseId)
+	case err != nil:
+		return 0, err
+	case cs.InvisibleCnt != nil:
+		return *cs.InvisibleCnt, nil
+	}
+	// 加这一列之前就有的课程还没统计过，和综合得分一起重算一次，之后就是增量维护的
+	err = dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := recomputeCompositeScore(tx, courseId); err != nil {
+			return err
+		}
+		return tx.Where("course_id = ?", courseId).First(&cs).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return *cs.InvisibleCnt, nil
+}
+
+func (dao *GORMEvaluationDAO) countCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
 	var count int64
-	err := dao.db.WithContext(ctx).
+	err := withContext(dao.db, ctx).
 		Model(&Evaluation{}).
 		Where("course_id = ? and status != ?", courseId, EvaluationStatusPublic).
 		Count(&count).Error
@@ -126,7 +285,7 @@ func (dao *GORMEvaluationDAO) GetCountCourseInvisible(ctx context.Context, cours
 func (dao *GORMEvaluationDAO) GetListCourse(ctx context.Context, curEvaluationId int64, limit int64,
 	courseId int64) ([]Evaluation, error) {
 	var evaluations []Evaluation
-	err := dao.db.WithContext(ctx).
+	err := withContext(dao.db, ctx).
 		Where("course_id = ? and status = ? and id < ?", courseId, EvaluationStatusPublic, curEvaluationId).
 		Order("utime desc").
 		Limit(int(limit)).Find(&evaluations).Error
@@ -136,16 +295,40 @@ func (dao *GORMEvaluationDAO) GetListCourse(ctx context.Context, curEvaluationId
 func (dao *GORMEvaluationDAO) GetListMine(ctx context.Context, curEvaluationId int64, limit int64, uid int64,
 	status int32) ([]Evaluation, error) {
 	var evaluations []Evaluation
-	err := dao.db.WithContext(ctx).
+	err := withContext(dao.db, ctx).
 		Where("publisher_id = ? and status = ? and id < ?", uid, status, curEvaluationId).
 		Order("utime desc").
 		Limit(int(limit)).Find(&evaluations).Error
 	return evaluations, err
 }
 
+func (dao *GORMEvaluationDAO) GetListCourseIds(ctx context.Context, curEvaluationId int64, limit int64,
+	courseId int64) ([]int64, error) {
+	var ids []int64
+	err := withContext(dao.db, ctx).
+		Model(&Evaluation{}).
+		Select("id").
+		Where("course_id = ? and status = ? and id < ?", courseId, EvaluationStatusPublic, curEvaluationId).
+		Order("utime desc").
+		Limit(int(limit)).Find(&ids).Error
+	return ids, err
+}
+
+func (dao *GORMEvaluationDAO) GetListMineIds(ctx context.Context, curEvaluationId int64, limit int64, uid int64,
+	status int32) ([]int64, error) {
+	var ids []int64
+	err := withContext(dao.db, ctx).
+		Model(&Evaluation{}).
+		Select("id").
+		Where("publisher_id = ? and status = ? and id < ?", uid, status, curEvaluationId).
+		Order("utime desc").
+		Limit(int(limit)).Find(&ids).Error
+	return ids, err
+}
+
 func (dao *GORMEvaluationDAO) GetListRecent(ctx context.Context, curEvaluationId int64, limit int64, property int32) ([]Evaluation, error) {
 	var evaluations []Evaluation
-	query := dao.db.WithContext(ctx)
+	query := withContext(dao.db, ctx)
 	const CoursePropertyAny = 0
 	if property != CoursePropertyAny {
 		query = query.Where("course_property = ?", property)
@@ -155,10 +338,55 @@ func (dao *GORMEvaluationDAO) GetListRecent(ctx context.Context, curEvaluationId
 	return evaluations, err
 }
 
+func (dao *GORMEvaluationDAO) GetListRecentTimeline(ctx context.Context, limit int64, property int32) ([]Evaluation, error) {
+	var evaluations []Evaluation
+	query := withContext(dao.db, ctx).Select("id, utime")
+	const CoursePropertyAny = 0
+	if property != CoursePropertyAny {
+		query = query.Where("course_property = ?", property)
+	}
+	err := query.Where("status = ?", EvaluationStatusPublic).
+		Order("utime desc").
+		Limit(int(limit)).Find(&evaluations).Error
+	return evaluations, err
+}
+
+func (dao *GORMEvaluationDAO) GetListByIds(ctx context.Context, ids []int64) ([]Evaluation, error) {
+	var evaluations []Evaluation
+	err := withContext(dao.db, ctx).
+		Where("id IN ?", ids).
+		Find(&evaluations).Error
+	return evaluations, err
+}
+
 type OldEvaluation struct {
-	CourseId   int64
-	StarRating uint8
-	Status     int32
+	CourseId       int64
+	CourseProperty int32
+	StarRating     uint8
+	Status         int32
+	Version        int64
+	// 只有 UpdateById 会查出来，用来判断内容有没有变
+	Content     string
+	IsAnonymous bool
+	// 这次修改写进去的utime，不是修改之前的，时间线按它排序
+	Utime int64 `gorm:"-"`
+}
+
+// nextVersion 修改之后的版本号，双写的从库直接用主库的版本号
+func nextVersion(ctx context.Context) any {
+	if v, ok := syncedVersion(ctx); ok {
+		return v
+	}
+	return gorm.Expr("version + 1")
+}
+
+// VersionConflictError 修改时带的版本号和库里的不一致，Current 是库里现在的课评，调用方可以据此合并之后重新提交
+type VersionConflictError struct {
+	Current Evaluation
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("课评已经被修改过了，当前版本 %d", e.Current.Version)
 }
 
 // 这里的有问题 todo
@@ -169,12 +397,24 @@ func (dao *GORMEvaluationDAO) UpdateById(ctx context.Context, evaluation Evaluat
 		// 先获取原有评价的星级，并锁定该行直到事务结束，这里是一个检查，然后做某事的场景
 		err := tx.Model(&Evaluation{}).
 			Clauses(clause.Locking{Strength: "UPDATE"}). // 添加行级锁
-			Select("course_id, star_rating, status").
+			Select("course_id, course_property, star_rating, status, version, content, is_anonymous").
 			Where("id = ? AND publisher_id = ?", evaluation.Id, evaluation.PublisherId).
 			First(&oe).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return notFoundOrDenied(tx, evaluation.Id)
+		}
 		if err != nil {
 			return err
 		}
+		// 版本号是0表示调用方没有带版本号，不检查，兼容老的客户端。双写的从库也不检查，主库已经检查过了
+		_, synced := syncedVersion(ctx)
+		if evaluation.Version > 0 && !synced && evaluation.Version != oe.Version {
+			var cur Evaluation
+			if err = tx.Where("id = ?", evaluation.Id).First(&cur).Error; err != nil {
+				return err
+			}
+			return &VersionConflictError{Current: cur}
+		}
 
 		res := tx.Model(&Evaluation{}).
 			Where("id = ? AND publisher_id = ?", evaluation.Id, evaluation.PublisherId).
@@ -183,13 +423,46 @@ func (dao *GORMEvaluationDAO) UpdateById(ctx context.Context, evaluation Evaluat
 				"content":      evaluation.Content,
 				"status":       evaluation.Status,
 				"is_anonymous": evaluation.IsAnonymous,
+				"version":      nextVersion(ctx),
 				"utime":        now,
 			})
 		if res.Error != nil {
 			return res.Error
 		}
 		if res.RowsAffected == 0 {
-			return errors.New("更新数据失败")
+			return ErrorRecordNotFind
+		}
+		oe.Utime = now
+		// 只改了状态的只发状态变更事件，什么都没改的不发事件
+		if oe.StarRating != evaluation.StarRating || oe.Content != evaluation.Content || oe.IsAnonymous != evaluation.IsAnonymous {
+			err = insertEvaluationEvent(tx, EvaluationEvent{
+				Type:         EvaluationEventTypeUpdated,
+				EvaluationId: evaluation.Id,
+				PublisherId:  evaluation.PublisherId,
+				CourseId:     oe.CourseId,
+				OldStatus:    oe.Status,
+				NewStatus:    evaluation.Status,
+				OldRating:    oe.StarRating,
+				NewRating:    evaluation.StarRating,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if oe.Status != evaluation.Status {
+			err = insertEvaluationEvent(tx, EvaluationEvent{
+				Type:         EvaluationEventTypeStatusChanged,
+				EvaluationId: evaluation.Id,
+				PublisherId:  evaluation.PublisherId,
+				CourseId:     oe.CourseId,
+				OldStatus:    oe.Status,
+				NewStatus:    evaluation.Status,
+				OldRating:    oe.StarRating,
+				NewRating:    evaluation.StarRating,
+			})
+			if err != nil {
+				return err
+			}
 		}
 		switch {
 		case (oe.Status == EvaluationStatusPrivate || oe.Status == EvaluationStatusFolded) && (evaluation.Status == EvaluationStatusPrivate || evaluation.Status == EvaluationStatusFolded):
@@ -209,7 +482,8 @@ func (dao *GORMEvaluationDAO) UpdateById(ctx context.Context, evaluation Evaluat
 			sql := `
         	UPDATE composite_scores
         	SET score = ((score * rater_cnt + ?) / (rater_cnt + 1)),
-        	    rater_cnt = rater_cnt + 1
+        	    rater_cnt = rater_cnt + 1,
+        	    invisible_cnt = invisible_cnt - 1
         	WHERE course_id = ?;
     		`
 			return tx.Exec(sql, float64(evaluation.StarRating), oe.CourseId).Error
@@ -224,12 +498,13 @@ func (dao *GORMEvaluationDAO) UpdateById(ctx context.Context, evaluation Evaluat
     			rater_cnt = CASE 
                     			WHEN rater_cnt = 1 THEN 0
                     			ELSE rater_cnt - 1
-                			END
+                			END,
+    			invisible_cnt = invisible_cnt + 1
 			WHERE course_id = ?;
     		`
 			return tx.Exec(sql, float64(oe.StarRating), oe.CourseId).Error
 		default:
-			return errors.New("非法的课评新旧状态变迁")
+			return ErrIllegalStatusTransition
 		}
 	})
 	if err != nil {
@@ -244,25 +519,48 @@ func (dao *GORMEvaluationDAO) UpdateStatus(ctx context.Context, evaluationId int
 		// 先获取原有评价的状态，并锁定该行直到事务结束
 		err := tx.Model(&Evaluation{}).
 			Clauses(clause.Locking{Strength: "UPDATE"}). // 添加行级锁
-			Select("course_id, star_rating, status").
+			Select("course_id, course_property, star_rating, status, version").
 			Where("id = ? AND publisher_id = ?", evaluationId, uid).
 			First(&oe).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return notFoundOrDenied(tx, evaluationId)
+		}
 		if err != nil {
 			return err
 		}
+		if oe.Status == EvaluationStatusFolded {
+			return ErrIllegalStatusTransition
+		}
 
 		// 更新状态
+		oe.Utime = time.Now().UnixMilli()
 		res := tx.Model(&Evaluation{}).
 			Where("id = ? AND publisher_id = ? AND status != ?", evaluationId, uid, EvaluationStatusFolded).
 			Updates(map[string]any{
-				"utime":  time.Now().UnixMilli(),
-				"status": status,
+				"utime":   oe.Utime,
+				"status":  status,
+				"version": nextVersion(ctx),
 			})
 		if res.Error != nil {
 			return res.Error
 		}
 		if res.RowsAffected == 0 {
-			return errors.New("更新数据失败")
+			return ErrorRecordNotFind
+		}
+		if oe.Status != int32(status) {
+			err = insertEvaluationEvent(tx, EvaluationEvent{
+				Type:         EvaluationEventTypeStatusChanged,
+				EvaluationId: evaluationId,
+				PublisherId:  uid,
+				CourseId:     oe.CourseId,
+				OldStatus:    oe.Status,
+				NewStatus:    int32(status),
+				OldRating:    oe.StarRating,
+				NewRating:    oe.StarRating,
+			})
+			if err != nil {
+				return err
+			}
 		}
 
 		// 更新综分，根据评价状态的变化
@@ -272,7 +570,8 @@ func (dao *GORMEvaluationDAO) UpdateStatus(ctx context.Context, evaluationId int
 			sql := `
 			UPDATE composite_scores
 			SET score = ((score * rater_cnt + ?) / (rater_cnt + 1)),
-			    rater_cnt = rater_cnt + 1
+			    rater_cnt = rater_cnt + 1,
+			    invisible_cnt = invisible_cnt - 1
 			WHERE course_id = ?;
 			`
 			// 执行 SQL 更新操作
@@ -289,7 +588,8 @@ func (dao *GORMEvaluationDAO) UpdateStatus(ctx context.Context, evaluationId int
     			rater_cnt = CASE 
                     			WHEN rater_cnt = 1 THEN 0
                     			ELSE rater_cnt - 1
-                			END
+                			END,
+    			invisible_cnt = invisible_cnt + 1
 			WHERE course_id = ?;
     		`
 			// 执行 SQL 更新操作
@@ -299,7 +599,7 @@ func (dao *GORMEvaluationDAO) UpdateStatus(ctx context.Context, evaluationId int
 			oe.Status == EvaluationStatusPublic && status == EvaluationStatusPublic:
 			return nil
 		default:
-			return errors.New("不合法的评课状态变更")
+			return ErrIllegalStatusTransition
 		}
 	})
 	if err != nil {
@@ -308,46 +608,81 @@ func (dao *GORMEvaluationDAO) UpdateStatus(ctx context.Context, evaluationId int
 	return oe, nil
 }
 
+// notFoundOrDenied 按课评id和作者没查到的时候，区分是课评不存在还是不是这个用户发的
+func notFoundOrDenied(tx *gorm.DB, evaluationId int64) error {
+	var cnt int64
+	err := tx.Model(&Evaluation{}).Where("id = ?", evaluationId).Count(&cnt).Error
+	if err != nil {
+		return err
+	}
+	if cnt > 0 {
+		return ErrPermissionDenied
+	}
+	return ErrorRecordNotFind
+}
+
+// Insert 调用方填了utime的沿用，时间线要用和数据库里面一样的utime
 func (dao *GORMEvaluationDAO) Insert(ctx context.Context, evaluation Evaluation) (int64, error) {
-	now := time.Now().UnixMilli()
-	evaluation.Ctime = now
-	evaluation.Utime = now
+	if evaluation.Utime == 0 {
+		evaluation.Utime = time.Now().UnixMilli()
+	}
+	evaluation.Ctime = evaluation.Utime
+	err := dao.fillId(ctx, &evaluation)
+	if err != nil {
+		return 0, err
+	}
 
-	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err = dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// 创建评价记录
 		err := tx.Create(&evaluation).Error
 		if err != nil {
 			return err
 		}
+		err = insertEvaluationEvent(tx, EvaluationEvent{
+			Type:         EvaluationEventTypeCreated,
+			EvaluationId: evaluation.Id,
+			PublisherId:  evaluation.PublisherId,
+			CourseId:     evaluation.CourseId,
+			NewStatus:    evaluation.Status,
+			NewRating:    evaluation.StarRating,
+		})
+		if err != nil {
+			return err
+		}
 		if evaluation.Status == EvaluationStatusPrivate {
-			// 非公开的课评，不计入评分
-			return nil
+			// 非公开的课评，不计入评分，只计入不可见的课评数
+			return tx.Exec(dialectOf(tx).upsertInvisibleSQL(), evaluation.CourseId).Error
 		}
 		// 使用 upsert 来更新或插入分数
-		sql := `
-		INSERT INTO composite_scores (course_id, score, rater_cnt)
-		VALUES (?, ?, 1)
-		ON DUPLICATE KEY UPDATE 
-		    score = ((score * rater_cnt + VALUES(score)) / (rater_cnt + 1)),
-		    rater_cnt = rater_cnt + 1
-		`
-		// 执行 SQL 更新操作
-		return tx.Exec(sql, evaluation.CourseId, float64(evaluation.StarRating)).Error
+		return tx.Exec(dialectOf(tx).upsertRatingSQL(), evaluation.CourseId, float64(evaluation.StarRating)).Error
 	})
 
+	if dialectOf(dao.db).isDuplicateEntry(err) {
+		return 0, ErrDuplicateEvaluation
+	}
 	if err != nil {
 		return 0, err
 	}
 	return evaluation.Id, nil
 }
 
-func NewGORMEvaluationDAO(db *gorm.DB) EvaluationDAO {
-	return &GORMEvaluationDAO{db: db}
+func NewGORMEvaluationDAO(db *gorm.DB, idGen idgen.Generator) EvaluationDAO {
+	return &GORMEvaluationDAO{db: db, idGen: idGen}
+}
+
+// fillId 双写的时候从库沿用主库的id，已经有id的不再生成
+func (dao *GORMEvaluationDAO) fillId(ctx context.Context, evaluation *Evaluation) error {
+	if evaluation.Id != 0 {
+		return nil
+	}
+	id, err := dao.idGen.NextTagged(ctx, CourseSlot(evaluation.CourseId))
+	evaluation.Id = id
+	return err
 }
 
 func (dao *GORMEvaluationDAO) FindEvaluation(ctx context.Context, publisherId int64, courseId int64) (Evaluation, error) {
 	var e Evaluation
-	err := dao.db.WithContext(ctx).
+	err := withContext(dao.db, ctx).
 		Where("publisher_id = ? and course_id = ?", publisherId, courseId).
 		First(&e).Error
 	return e, err
@@ -355,7 +690,7 @@ func (dao *GORMEvaluationDAO) FindEvaluation(ctx context.Context, publisherId in
 
 // TODO 设计索引，优化查询
 type Evaluation struct {
-	Id             int64 `gorm:"primaryKey,autoIncrement"`
+	Id             int64 `gorm:"primaryKey;autoIncrement:false"`
 	PublisherId    int64 `gorm:"uniqueIndex:publisherId_courseId"`
 	CourseId       int64 `gorm:"uniqueIndex:publisherId_courseId;index:courseId_status"`
 	CourseProperty int32 // 冗余一个课程性质，用于查询
@@ -363,8 +698,10 @@ type Evaluation struct {
 	Content        string
 	Status         int32 `gorm:"index:courseId_status"`
 	IsAnonymous    bool
-	Utime          int64
-	Ctime          int64
+	// 作者每修改一次加一，修改的时候带上读到的版本号，防止多端编辑互相覆盖
+	Version int64 `gorm:"not null;default:1"`
+	Utime   int64
+	Ctime   int64
 }
 
 // 维护一个综合得分
@@ -373,4 +710,6 @@ type CompositeScore struct {
 	CourseId int64 `gorm:"uniqueIndex"`
 	Score    float64
 	RaterCnt int64
+	// 不公开的课评数，和得分在同一个事务里面维护。NULL表示加这一列之前就有的课程，还没统计过
+	InvisibleCnt *int64
 }
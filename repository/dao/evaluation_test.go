@@ -0,0 +1,179 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// TestEvaluationSchema 课评id由id生成器分配，不能是自增列
+func TestEvaluationSchema(t *testing.T) {
+	s, err := schema.Parse(&Evaluation{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := s.LookUpField("id")
+	if f == nil || !f.PrimaryKey || f.AutoIncrement {
+		t.Fatalf("id 字段 %+v", f)
+	}
+}
+
+func TestGORMEvaluationDAO_UpdateByIdEvents(t *testing.T) {
+	testCases := []struct {
+		name      string
+		update    Evaluation
+		wantTypes []string
+	}{
+		{
+			name:   "什么都没改，不发事件",
+			update: Evaluation{StarRating: 5, Content: "好", Status: EvaluationStatusPublic},
+		},
+		{
+			name:      "只改了状态，只发状态变更",
+			update:    Evaluation{StarRating: 5, Content: "好", Status: EvaluationStatusPrivate},
+			wantTypes: []string{EvaluationEventTypeStatusChanged},
+		},
+		{
+			name:      "改了内容",
+			update:    Evaluation{StarRating: 5, Content: "很好", Status: EvaluationStatusPublic},
+			wantTypes: []string{EvaluationEventTypeUpdated},
+		},
+		{
+			name:      "内容和状态都改了",
+			update:    Evaluation{StarRating: 4, Content: "好", Status: EvaluationStatusPrivate},
+			wantTypes: []string{EvaluationEventTypeUpdated, EvaluationEventTypeStatusChanged},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			d := NewGORMEvaluationDAO(db, newTestIdGen(t))
+			id, err := d.Insert(ctx, Evaluation{PublisherId: 1, CourseId: 2, StarRating: 5, Content: "好",
+				Status: EvaluationStatusPublic})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 发布的事件不关心
+			db.Where("1 = 1").Delete(&EvaluationEvent{})
+			tc.update.Id, tc.update.PublisherId = id, 1
+			if _, err = d.UpdateById(ctx, tc.update); err != nil {
+				t.Fatal(err)
+			}
+			var types []string
+			db.Model(&EvaluationEvent{}).Order("id").Pluck("type", &types)
+			if !slices.Equal(types, tc.wantTypes) {
+				t.Fatalf("发了 %v，想要 %v", types, tc.wantTypes)
+			}
+		})
+	}
+}
+
+// 不可见的课评数和综合得分在同一个事务里面维护，和直接数出来的一样
+func TestGORMEvaluationDAO_CountCourseInvisible(t *testing.T) {
+	const courseId = 2
+	testCases := []struct {
+		name string
+		// 在 d 上做一系列操作
+		ops func(t *testing.T, d EvaluationDAO, db *gorm.DB)
+		// 只是为了检查事务里面维护的值，不包括重算
+		wantStored    *int64
+		wantInvisible int64
+		wantRaterCnt  int64
+	}{
+		{
+			name: "发布私密的课评",
+			ops: func(t *testing.T, d EvaluationDAO, db *gorm.DB) {
+				insertForCount(t, d, 1, EvaluationStatusPrivate)
+				insertForCount(t, d, 2, EvaluationStatusPublic)
+			},
+			wantStored:    ptr[int64](1),
+			wantInvisible: 1,
+			wantRaterCnt:  1,
+		},
+		{
+			name: "公开改成私密，私密改成公开，私密改成折叠",
+			ops: func(t *testing.T, d EvaluationDAO, db *gorm.DB) {
+				a := insertForCount(t, d, 1, EvaluationStatusPublic)
+				b := insertForCount(t, d, 2, EvaluationStatusPrivate)
+				c := insertForCount(t, d, 3, EvaluationStatusPrivate)
+				mustUpdateStatus(t, d, a, EvaluationStatusPrivate, 1)
+				if _, err := d.UpdateById(context.Background(), Evaluation{Id: b, PublisherId: 2, StarRating: 5,
+					Content: "好", Status: EvaluationStatusPublic}); err != nil {
+					t.Fatal(err)
+				}
+				mustUpdateStatus(t, d, c, EvaluationStatusFolded, 3)
+			},
+			wantStored:    ptr[int64](2),
+			wantInvisible: 2,
+			wantRaterCnt:  1,
+		},
+		{
+			name: "加这一列之前就有的课程，第一次读的时候重算",
+			ops: func(t *testing.T, d EvaluationDAO, db *gorm.DB) {
+				insertForCount(t, d, 1, EvaluationStatusPrivate)
+				insertForCount(t, d, 2, EvaluationStatusPublic)
+				db.Model(&CompositeScore{}).Where("course_id = ?", courseId).Update("invisible_cnt", nil)
+				// 计数还是NULL的时候，增量更新不会把它变成一个错的数
+				insertForCount(t, d, 3, EvaluationStatusPrivate)
+			},
+			wantInvisible: 2,
+			wantRaterCnt:  1,
+		},
+		{
+			name: "加这一列之前只有私密课评的课程没有综合得分，直接数",
+			ops: func(t *testing.T, d EvaluationDAO, db *gorm.DB) {
+				insertForCount(t, d, 1, EvaluationStatusPrivate)
+				db.Where("course_id = ?", courseId).Delete(&CompositeScore{})
+			},
+			wantInvisible: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			d := NewGORMEvaluationDAO(db, newTestIdGen(t))
+			tc.ops(t, d, db)
+			var cs CompositeScore
+			db.Where("course_id = ?", courseId).Limit(1).Find(&cs)
+			if tc.wantStored != nil && (cs.InvisibleCnt == nil || *cs.InvisibleCnt != *tc.wantStored) {
+				t.Fatalf("事务里面维护的计数是 %v，想要 %d", cs.InvisibleCnt, *tc.wantStored)
+			}
+			cnt, err := d.GetCountCourseInvisible(ctx, courseId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cnt != tc.wantInvisible {
+				t.Fatalf("不可见的课评数 %d，想要 %d", cnt, tc.wantInvisible)
+			}
+			cs = CompositeScore{}
+			db.Where("course_id = ?", courseId).Limit(1).Find(&cs)
+			if cs.RaterCnt != tc.wantRaterCnt {
+				t.Fatalf("评分人数 %d，想要 %d", cs.RaterCnt, tc.wantRaterCnt)
+			}
+		})
+	}
+}
+
+func insertForCount(t *testing.T, d EvaluationDAO, uid int64, status int32) int64 {
+	id, err := d.Insert(context.Background(), Evaluation{PublisherId: uid, CourseId: 2, StarRating: 5, Content: "好",
+		Status: status})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func mustUpdateStatus(t *testing.T, d EvaluationDAO, id int64, status uint32, uid int64) {
+	if _, err := d.UpdateStatus(context.Background(), id, status, uid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
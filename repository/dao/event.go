@@ -0,0 +1,186 @@
+package dao
+
+import (
+	"context"
+	"github.com/seata/seata-go/pkg/tm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+// GlobalTxTimeout 和 seatago.yaml 里面的 default-global-transaction-timeout 一致，
+// 过了这么久全局事务一定已经有结果了
+const GlobalTxTimeout = time.Minute
+
+const (
+	EvaluationEventTypeCreated       = "created"
+	EvaluationEventTypeUpdated       = "updated"
+	EvaluationEventTypeStatusChanged = "status_changed"
+	// EvaluationEventTypeMoved 合并课程，课评从 OldCourseId 挪到了 CourseId
+	EvaluationEventTypeMoved = "moved"
+	// EvaluationEventTypeDropped 合并课程，作者在 CourseId 上有更新的课评，这一条被丢弃了
+	EvaluationEventTypeDropped = "dropped"
+)
+
+// eventClaimLease 认领的事件在这么久之内没有投递完，别的实例可以接手。要比投递任务一次运行的超时长
+const eventClaimLease = time.Minute
+
+type EvaluationEventDAO interface {
+	// ConsumePending 按写入顺序取出待投递的事件交给fn，fn成功的事件会被删除。
+	// fn失败就停在这一条，后面的事件留到下次再投递。同一条课评的事件按写入顺序交给fn，
+	// 前面的事件还没投递出去的时候，不管在不在这个实例手里，后面的都不会交出去
+	ConsumePending(ctx context.Context, limit int, fn func(evt EvaluationEvent) error) (int, error)
+}
+
+type GORMEvaluationEventDAO struct {
+	db *gorm.DB
+	// 这个实例认领事件时用的标识
+	owner string
+}
+
+func NewGORMEvaluationEventDAO(db *gorm.DB) EvaluationEventDAO {
+	return &GORMEvaluationEventDAO{db: db, owner: strconv.FormatUint(rand.Uint64(), 36)}
+}
+
+// ConsumePending 先在一个短事务里面认领一批事件，事务外面投递，投递完再删掉。
+// 不能在持有行锁的事务里面发消息，消息队列慢的时候会长时间占着连接和锁
+func (dao *GORMEvaluationEventDAO) ConsumePending(ctx context.Context, limit int, fn func(evt EvaluationEvent) error) (int, error) {
+	evts, err := dao.claim(ctx, limit)
+	if err != nil || len(evts) == 0 {
+		return 0, err
+	}
+	var (
+		ids   = make([]int64, 0, len(evts))
+		fnErr error
+	)
+	for _, evt := range evts {
+		if fnErr = fn(evt); fnErr != nil {
+			break
+		}
+		ids = append(ids, evt.Id)
+	}
+	if len(ids) > 0 {
+		// 租约过期被别的实例接手的事件也会被删掉，别的实例会再发一次，消费方按 EventId 去重
+		err = dao.db.WithContext(ctx).Where("id IN ?", ids).Delete(&EvaluationEvent{}).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(ids) < len(evts) {
+		// 没投递的马上放回去，不用等租约过期
+		err = dao.db.WithContext(ctx).Model(&EvaluationEvent{}).
+			Where("id IN ? AND owner = ?", evaluationEventIds(evts[len(ids):]), dao.owner).
+			Updates(map[string]any{"owner": "", "lease_until": 0}).Error
+		if err != nil {
+			return len(ids), err
+		}
+	}
+	return len(ids), fnErr
+}
+
+// claim 认领一批没人认领或者租约已经过期的事件。前面还有事件没投递的课评先跳过，保证同一条课评的事件按顺序投递
+func (dao *GORMEvaluationEventDAO) claim(ctx context.Context, limit int) ([]EvaluationEvent, error) {
+	var res []EvaluationEvent
+	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UnixMilli()
+		var evts []EvaluationEvent
+		// 多个实例同时认领的时候各自跳过别人锁住的行。
+		// 全局事务里面写的事件要等全局事务有了结果再投递，全局回滚的时候事件会随着undo_log一起被删掉
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("lease_until < ?", now).
+			Where("xid = '' OR ctime < ?", time.Now().Add(-GlobalTxTimeout).UnixMilli()).
+			Order("id").
+			Limit(limit).
+			Find(&evts).Error
+		if err != nil || len(evts) == 0 {
+			return err
+		}
+		// 每条课评在这一批里面最早的事件，如果表里还有更早的，说明在别人手里或者还不能投递
+		first := make(map[int64]int64, len(evts))
+		for _, evt := range evts {
+			if _, ok := first[evt.EvaluationId]; !ok {
+				first[evt.EvaluationId] = evt.Id
+			}
+		}
+		evaluationIds := make([]int64, 0, len(first))
+		for evaluationId := range first {
+			evaluationIds = append(evaluationIds, evaluationId)
+		}
+		var heads []struct {
+			EvaluationId int64
+			MinId        int64
+		}
+		err = tx.Model(&EvaluationEvent{}).
+			Select("evaluation_id, MIN(id) AS min_id").
+			Where("evaluation_id IN ?", evaluationIds).
+			Group("evaluation_id").
+			Find(&heads).Error
+		if err != nil {
+			return err
+		}
+		blocked := make(map[int64]bool, len(heads))
+		for _, h := range heads {
+			blocked[h.EvaluationId] = h.MinId < first[h.EvaluationId]
+		}
+		for _, evt := range evts {
+			if !blocked[evt.EvaluationId] {
+				res = append(res, evt)
+			}
+		}
+		if len(res) == 0 {
+			return nil
+		}
+		return tx.Model(&EvaluationEvent{}).
+			Where("id IN ?", evaluationEventIds(res)).
+			Updates(map[string]any{
+				"owner":       dao.owner,
+				"lease_until": time.Now().Add(eventClaimLease).UnixMilli(),
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func evaluationEventIds(evts []EvaluationEvent) []int64 {
+	ids := make([]int64, 0, len(evts))
+	for _, evt := range evts {
+		ids = append(ids, evt.Id)
+	}
+	return ids
+}
+
+// insertEvaluationEvent 在业务事务里面写入发件箱，和课评的变更一起提交或者回滚
+func insertEvaluationEvent(tx *gorm.DB, evt EvaluationEvent) error {
+	if isWithoutEvents(tx.Statement.Context) {
+		return nil
+	}
+	evt.Ctime = time.Now().UnixMilli()
+	evt.Xid = tm.GetXID(tx.Statement.Context)
+	return tx.Create(&evt).Error
+}
+
+// EvaluationEvent 事务发件箱，投递成功之后删除，所以表里只有待投递的事件
+type EvaluationEvent struct {
+	Id   int64  `gorm:"primaryKey,autoIncrement"`
+	Type string `gorm:"type:varchar(32)"`
+	// 认领的时候要查同一条课评有没有更早的事件
+	EvaluationId int64 `gorm:"index"`
+	PublisherId  int64
+	CourseId     int64
+	// 只有合并课程的事件有，课评原来所在的课程
+	OldCourseId int64
+	OldStatus   int32
+	NewStatus   int32
+	OldRating   uint8
+	NewRating   uint8
+	// 写入时所在的seata全局事务，不在全局事务里面是空
+	Xid string `gorm:"type:varchar(128)"`
+	// 认领这条事件的实例和租约到期的时间，没人认领的时候是空和0
+	Owner      string `gorm:"type:varchar(32);not null;default:''"`
+	LeaseUntil int64  `gorm:"not null;default:0;index"`
+	Ctime      int64
+}
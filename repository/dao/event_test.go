@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGORMEvaluationEventDAO_ConsumePending(t *testing.T) {
+	errSend := errors.New("发送失败")
+	testCases := []struct {
+		name string
+		// 表里的事件，按id顺序
+		evts []EvaluationEvent
+		// fn 在这条事件上失败
+		failOn  int64
+		wantIds []int64
+		wantErr error
+		// 投递之后表里剩下的事件和认领者
+		wantLeft map[int64]string
+	}{
+		{
+			name: "按顺序投递完删掉",
+			evts: []EvaluationEvent{
+				{Id: 1, EvaluationId: 1},
+				{Id: 2, EvaluationId: 2},
+				{Id: 3, EvaluationId: 1},
+			},
+			wantIds:  []int64{1, 2, 3},
+			wantLeft: map[int64]string{},
+		},
+		{
+			name: "同一条课评前面的事件在别人手里，后面的先不投递",
+			evts: []EvaluationEvent{
+				{Id: 1, EvaluationId: 1, Owner: "other", LeaseUntil: time.Now().Add(time.Minute).UnixMilli()},
+				{Id: 2, EvaluationId: 2},
+				{Id: 3, EvaluationId: 1},
+			},
+			wantIds:  []int64{2},
+			wantLeft: map[int64]string{1: "other", 3: ""},
+		},
+		{
+			name: "别人的租约过期了，接手投递",
+			evts: []EvaluationEvent{
+				{Id: 1, EvaluationId: 1, Owner: "other", LeaseUntil: time.Now().Add(-time.Second).UnixMilli()},
+				{Id: 2, EvaluationId: 1},
+			},
+			wantIds:  []int64{1, 2},
+			wantLeft: map[int64]string{},
+		},
+		{
+			name: "全局事务还没有结果，同一条课评后面的事件也要等",
+			evts: []EvaluationEvent{
+				{Id: 1, EvaluationId: 1, Xid: "xid"},
+				{Id: 2, EvaluationId: 1},
+				{Id: 3, EvaluationId: 2},
+			},
+			wantIds:  []int64{3},
+			wantLeft: map[int64]string{1: "", 2: ""},
+		},
+		{
+			name: "投递失败停在这一条，没投递的放回去",
+			evts: []EvaluationEvent{
+				{Id: 1, EvaluationId: 1},
+				{Id: 2, EvaluationId: 2},
+				{Id: 3, EvaluationId: 3},
+			},
+			failOn:   2,
+			wantIds:  []int64{1},
+			wantErr:  errSend,
+			wantLeft: map[int64]string{2: "", 3: ""},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			for i := range tc.evts {
+				tc.evts[i].Ctime = time.Now().UnixMilli()
+			}
+			if err := db.Create(&tc.evts).Error; err != nil {
+				t.Fatal(err)
+			}
+			d := NewGORMEvaluationEventDAO(db)
+			var ids []int64
+			n, err := d.ConsumePending(ctx, 10, func(evt EvaluationEvent) error {
+				if evt.Id == tc.failOn {
+					return errSend
+				}
+				ids = append(ids, evt.Id)
+				return nil
+			})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, 想要 %v", err, tc.wantErr)
+			}
+			if n != len(tc.wantIds) || !slices.Equal(ids, tc.wantIds) {
+				t.Fatalf("投递了 %v，返回 %d，想要 %v", ids, n, tc.wantIds)
+			}
+			var left []EvaluationEvent
+			db.Find(&left)
+			got := make(map[int64]string, len(left))
+			for _, evt := range left {
+				got[evt.Id] = evt.Owner
+			}
+			if len(got) != len(tc.wantLeft) {
+				t.Fatalf("剩下 %v，想要 %v", got, tc.wantLeft)
+			}
+			for id, owner := range tc.wantLeft {
+				if o, ok := got[id]; !ok || o != owner {
+					t.Fatalf("剩下 %v，想要 %v", got, tc.wantLeft)
+				}
+			}
+		})
+	}
+}
@@ -3,5 +3,23 @@ package dao
 import "gorm.io/gorm"
 
 func InitTables(db *gorm.DB) error {
-	return db.AutoMigrate(&Evaluation{}, &CompositeScore{})
+	return db.AutoMigrate(&Evaluation{}, &CompositeScore{}, &EvaluationEvent{}, &CourseMergeTask{}, &PendingEvaluation{},
+		&MergeDroppedEvaluation{})
+}
+
+// InitUndoLogTable seata AT模式的回滚日志，表结构由seata规定，不能用AutoMigrate
+func InitUndoLogTable(db *gorm.DB) error {
+	return db.Exec("CREATE TABLE IF NOT EXISTS `undo_log` (\n" +
+		"    `id`            bigint(20)   NOT NULL AUTO_INCREMENT,\n" +
+		"    `branch_id`     bigint(20)   NOT NULL,\n" +
+		"    `xid`           varchar(100) NOT NULL,\n" +
+		"    `context`       varchar(128) NOT NULL,\n" +
+		"    `rollback_info` longblob     NOT NULL,\n" +
+		"    `log_status`    int(11)      NOT NULL,\n" +
+		"    `log_created`   datetime     NOT NULL,\n" +
+		"    `log_modified`  datetime     NOT NULL,\n" +
+		"    `ext`           varchar(100) DEFAULT NULL,\n" +
+		"    PRIMARY KEY (`id`),\n" +
+		"    UNIQUE KEY `ux_undo_log` (`xid`, `branch_id`)\n" +
+		") ENGINE = InnoDB DEFAULT CHARSET = utf8mb4").Error
 }
@@ -0,0 +1,38 @@
+package dao
+
+import (
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	glogger "gorm.io/gorm/logger"
+	"testing"
+)
+
+// newTestDB 每个测试一个内存里的sqlite，建好所有的表
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		TranslateError: true,
+		Logger:         glogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存库每个连接是独立的
+	sqlDB.SetMaxOpenConns(1)
+	if err = InitTables(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestIdGen(t *testing.T) idgen.Generator {
+	g, err := idgen.NewSnowflake(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
@@ -0,0 +1,208 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"unicode/utf8"
+)
+
+type PendingEvaluationDAO interface {
+	// Insert 新建的课评在这里预先分配课评id，校验通过之后用这个id写入 evaluations，返回课评id。
+	// 同一个用户对同一门课已经有待校验的新课评了，就覆盖它的内容，沿用它的课评id
+	Insert(ctx context.Context, p PendingEvaluation) (int64, error)
+	// FindDue 到了校验时间的待校验课评，按写入顺序，不包括死信
+	FindDue(ctx context.Context, limit int) ([]PendingEvaluation, error)
+	// Claim 占住一条待校验课评，同时把下次校验的时间推迟到 nextTime，
+	// 这次校验失败或者实例挂了都会在 nextTime 之后重新校验。别的实例已经占住了返回false
+	Claim(ctx context.Context, p PendingEvaluation, nextTime time.Time) (bool, error)
+	Delete(ctx context.Context, id int64) error
+	// ExistsCreate 用户对这门课有没有还在校验的新课评，不包括死信
+	ExistsCreate(ctx context.Context, publisherId int64, courseId int64) (bool, error)
+	// Bury 重试次数用完了，转成死信，不再自动校验
+	Bury(ctx context.Context, id int64, reason string) error
+	// FindDead 按id顺序翻页列出死信
+	FindDead(ctx context.Context, afterId int64, limit int) ([]PendingEvaluation, error)
+	// Requeue 把死信放回去重新校验，重试次数清零，返回放回去的条数。
+	// 作者已经重新提交了同一门课的新课评的，不放回去
+	Requeue(ctx context.Context, ids []int64) (int64, error)
+}
+
+type GORMPendingEvaluationDAO struct {
+	db    *gorm.DB
+	idGen idgen.Generator
+}
+
+func NewGORMPendingEvaluationDAO(db *gorm.DB, idGen idgen.Generator) PendingEvaluationDAO {
+	return &GORMPendingEvaluationDAO{db: db, idGen: idGen}
+}
+
+func (dao *GORMPendingEvaluationDAO) Insert(ctx context.Context, p PendingEvaluation) (int64, error) {
+	now := time.Now().UnixMilli()
+	p.Ctime = now
+	p.NextTime = now
+	if p.IsUpdate {
+		return p.EvaluationId, dao.db.WithContext(ctx).Create(&p).Error
+	}
+	// 校验通过之后课评沿用这个id，分库的时候要靠它找到课程所在的分片
+	id, err := dao.idGen.NextTagged(ctx, CourseSlot(p.CourseId))
+	if err != nil {
+		return 0, err
+	}
+	p.EvaluationId = id
+	p.CreateKey = pendingCreateKey(p.PublisherId, p.CourseId)
+	err = dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 还没校验完又提交了一次，靠唯一键合并成一条，只保留最新的内容，课评id沿用之前分配的
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "create_key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"star_rating", "content", "status", "is_anonymous"}),
+		}).Create(&p).Error
+		if err != nil {
+			return err
+		}
+		var merged PendingEvaluation
+		err = tx.Select("evaluation_id").Where("create_key = ?", p.CreateKey).First(&merged).Error
+		id = merged.EvaluationId
+		return err
+	})
+	return id, err
+}
+
+func (dao *GORMPendingEvaluationDAO) FindDue(ctx context.Context, limit int) ([]PendingEvaluation, error) {
+	var res []PendingEvaluation
+	err := dao.db.WithContext(ctx).
+		Where("next_time <= ? AND dead_time = 0", time.Now().UnixMilli()).
+		Order("id").
+		Limit(limit).
+		Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMPendingEvaluationDAO) Claim(ctx context.Context, p PendingEvaluation, nextTime time.Time) (bool, error) {
+	// 校验要调用课程服务，不能在事务里面锁着行等，所以用 next_time 做乐观锁
+	res := dao.db.WithContext(ctx).Model(&PendingEvaluation{}).
+		Where("id = ? AND next_time = ?", p.Id, p.NextTime).
+		Updates(map[string]any{
+			"attempts":  gorm.Expr("attempts + 1"),
+			"next_time": nextTime.UnixMilli(),
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (dao *GORMPendingEvaluationDAO) Delete(ctx context.Context, id int64) error {
+	return dao.db.WithContext(ctx).Where("id = ?", id).Delete(&PendingEvaluation{}).Error
+}
+
+func (dao *GORMPendingEvaluationDAO) ExistsCreate(ctx context.Context, publisherId int64, courseId int64) (bool, error) {
+	var cnt int64
+	err := dao.db.WithContext(ctx).Model(&PendingEvaluation{}).
+		Where("create_key = ?", pendingCreateKey(publisherId, courseId)).
+		Count(&cnt).Error
+	return cnt > 0, err
+}
+
+func (dao *GORMPendingEvaluationDAO) Bury(ctx context.Context, id int64, reason string) error {
+	reason = truncateRunes(reason, pendingLastErrorSize)
+	return dao.db.WithContext(ctx).Model(&PendingEvaluation{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"dead_time":  time.Now().UnixMilli(),
+			"last_error": reason,
+			// 死信不再占着唯一键，作者可以重新提交
+			"create_key": nil,
+		}).Error
+}
+
+func (dao *GORMPendingEvaluationDAO) FindDead(ctx context.Context, afterId int64, limit int) ([]PendingEvaluation, error) {
+	var res []PendingEvaluation
+	err := dao.db.WithContext(ctx).
+		Where("dead_time > 0 AND id > ?", afterId).
+		Order("id").
+		Limit(limit).
+		Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMPendingEvaluationDAO) Requeue(ctx context.Context, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var ps []PendingEvaluation
+	err := dao.db.WithContext(ctx).Where("id IN ? AND dead_time > 0", ids).Find(&ps).Error
+	if err != nil {
+		return 0, err
+	}
+	var cnt int64
+	for _, p := range ps {
+		var createKey *string
+		if !p.IsUpdate {
+			createKey = pendingCreateKey(p.PublisherId, p.CourseId)
+		}
+		res := dao.db.WithContext(ctx).Model(&PendingEvaluation{}).
+			Where("id = ? AND dead_time > 0", p.Id).
+			Updates(map[string]any{
+				"dead_time":  0,
+				"attempts":   0,
+				"next_time":  time.Now().UnixMilli(),
+				"last_error": "",
+				"create_key": createKey,
+			})
+		if dialectOf(dao.db).isDuplicateEntry(res.Error) {
+			// 作者已经重新提交了同一门课的课评，以新提交的为准，这条留在死信里面
+			continue
+		}
+		if res.Error != nil {
+			return cnt, res.Error
+		}
+		cnt += res.RowsAffected
+	}
+	return cnt, nil
+}
+
+// pendingCreateKey 新课评的唯一键，同一个用户同一门课只能有一条还在校验的新课评。
+// 修改和死信是NULL，不参与唯一约束
+func pendingCreateKey(publisherId int64, courseId int64) *string {
+	key := fmt.Sprintf("%d:%d", publisherId, courseId)
+	return &key
+}
+
+// pendingLastErrorSize varchar的长度按字符算，错误信息大多是中文，截断的时候也要按字符截，截出半个字符严格模式下写不进去
+const pendingLastErrorSize = 512
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// PendingEvaluation 还没确认选了这门课的课评，确认之后才写入 evaluations，在这之前不可见，也不计入评分。
+// 课程服务不可用的时候收下的，和异步校验模式下新提交的，都在这里
+type PendingEvaluation struct {
+	Id int64 `gorm:"primaryKey,autoIncrement"`
+	// 新建的课评是预先分配的id，修改的课评是原来的id
+	EvaluationId int64
+	IsUpdate     bool
+	PublisherId  int64
+	CourseId     int64
+	// 还在校验的新课评是 publisher_id:course_id，修改和死信是NULL
+	CreateKey   *string `gorm:"uniqueIndex:pending_create_key;size:64"`
+	StarRating  uint8
+	Content     string
+	Status      int32
+	IsAnonymous bool
+	// 修改的课评提交时带的版本号，写入的时候照样检查
+	Version int64
+	// 已经校验过几次
+	Attempts int
+	// 下次校验的时间
+	NextTime int64 `gorm:"index"`
+	// 转成死信的时间，0表示还在重试
+	DeadTime int64
+	// 转成死信之前最后一次校验的错误
+	LastError string `gorm:"type:varchar(512)"`
+	Ctime     int64
+}
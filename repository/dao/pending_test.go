@@ -0,0 +1,164 @@
+package dao
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGORMPendingEvaluationDAO_Insert(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := NewGORMPendingEvaluationDAO(db, newTestIdGen(t))
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ids = map[int64]struct{}{}
+	)
+	// 同一门课并发重复提交，只能留下一条，课评id都一样
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, err := d.Insert(ctx, PendingEvaluation{PublisherId: 1, CourseId: 2, StarRating: uint8(i%5 + 1)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			ids[id] = struct{}{}
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	if len(ids) != 1 {
+		t.Fatalf("重复提交分配了 %d 个课评id", len(ids))
+	}
+	var cnt int64
+	db.Model(&PendingEvaluation{}).Where("publisher_id = ? AND course_id = ?", 1, 2).Count(&cnt)
+	if cnt != 1 {
+		t.Fatalf("重复提交留下了 %d 条", cnt)
+	}
+
+	// 后提交的内容覆盖先提交的
+	first, err := d.Insert(ctx, PendingEvaluation{PublisherId: 1, CourseId: 3, Content: "旧的"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := d.Insert(ctx, PendingEvaluation{PublisherId: 1, CourseId: 3, Content: "新的"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p PendingEvaluation
+	db.Where("course_id = ?", 3).First(&p)
+	if first != second || p.EvaluationId != first || p.Content != "新的" {
+		t.Fatalf("合并之后是 %+v，两次的课评id %d %d", p, first, second)
+	}
+
+	// 修改不参与合并
+	for i := 0; i < 2; i++ {
+		if _, err = d.Insert(ctx, PendingEvaluation{EvaluationId: 100, IsUpdate: true, PublisherId: 1, CourseId: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Model(&PendingEvaluation{}).Where("is_update = ?", true).Count(&cnt)
+	if cnt != 2 {
+		t.Fatalf("修改留下了 %d 条，应该是 2 条", cnt)
+	}
+}
+
+func TestGORMPendingEvaluationDAO_BuryRequeue(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	d := NewGORMPendingEvaluationDAO(db, newTestIdGen(t))
+
+	deadId, err := d.Insert(ctx, PendingEvaluation{PublisherId: 1, CourseId: 2, Content: "死信"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dead PendingEvaluation
+	db.Where("evaluation_id = ?", deadId).First(&dead)
+	if err = d.Bury(ctx, dead.Id, "重试次数用完了"); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := d.ExistsCreate(ctx, 1, 2)
+	if err != nil || ok {
+		t.Fatalf("死信不算还在校验: %v, %v", ok, err)
+	}
+
+	// 死信不占着唯一键，可以重新提交，分配新的课评id
+	liveId, err := d.Insert(ctx, PendingEvaluation{PublisherId: 1, CourseId: 2, Content: "重新提交"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if liveId == deadId {
+		t.Fatal("重新提交沿用了死信的课评id")
+	}
+	// 已经重新提交了，死信不能放回去
+	n, err := d.Requeue(ctx, []int64{dead.Id})
+	if err != nil || n != 0 {
+		t.Fatalf("放回了 %d 条, %v，应该是 0 条", n, err)
+	}
+
+	var live PendingEvaluation
+	db.Where("evaluation_id = ?", liveId).First(&live)
+	if err = d.Delete(ctx, live.Id); err != nil {
+		t.Fatal(err)
+	}
+	n, err = d.Requeue(ctx, []int64{dead.Id})
+	if err != nil || n != 1 {
+		t.Fatalf("放回了 %d 条, %v，应该是 1 条", n, err)
+	}
+	ok, err = d.ExistsCreate(ctx, 1, 2)
+	if err != nil || !ok {
+		t.Fatalf("放回去之后应该还在校验: %v, %v", ok, err)
+	}
+}
+
+// 错误信息大多是中文，按字节截断会截出半个字符，mysql严格模式下整条UPDATE都会失败
+func TestGORMPendingEvaluationDAO_BuryLongReason(t *testing.T) {
+	testCases := []struct {
+		name   string
+		reason string
+		want   string
+	}{
+		{
+			name:   "没超长的原样保存",
+			reason: "课程服务不可用",
+			want:   "课程服务不可用",
+		},
+		{
+			name:   "超长的中文按字符截断",
+			reason: strings.Repeat("课", pendingLastErrorSize) + "程服务不可用",
+			want:   strings.Repeat("课", pendingLastErrorSize),
+		},
+		{
+			name:   "中英文混在一起",
+			reason: "a" + strings.Repeat("程", pendingLastErrorSize),
+			want:   "a" + strings.Repeat("程", pendingLastErrorSize-1),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			d := NewGORMPendingEvaluationDAO(db, newTestIdGen(t))
+			evaluationId, err := d.Insert(ctx, PendingEvaluation{PublisherId: 1, CourseId: 2, Content: "死信"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var p PendingEvaluation
+			db.Where("evaluation_id = ?", evaluationId).First(&p)
+			if err = d.Bury(ctx, p.Id, tc.reason); err != nil {
+				t.Fatal(err)
+			}
+			db.Where("id = ?", p.Id).First(&p)
+			if !utf8.ValidString(p.LastError) || p.LastError != tc.want {
+				t.Fatalf("保存的错误信息有 %d 个字符", utf8.RuneCountInString(p.LastError))
+			}
+		})
+	}
+}
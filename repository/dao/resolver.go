@@ -0,0 +1,29 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+type usePrimaryKey struct{}
+
+// WithPrimary 标记这次请求的读也走主库，用于刚写完马上读自己数据的场景，避免读到从库的延迟数据
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, usePrimaryKey{}, true)
+}
+
+// IsUsePrimary 这次请求是不是被 WithPrimary 标记过
+func IsUsePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(usePrimaryKey{}).(bool)
+	return v
+}
+
+// withContext 没有配置从库的时候 dbresolver.Write 不起作用，所有请求本来就在主库上
+func withContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+	res := db.WithContext(ctx)
+	if IsUsePrimary(ctx) {
+		res = res.Clauses(dbresolver.Write)
+	}
+	return res
+}
@@ -0,0 +1,397 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
+	"golang.org/x/sync/errgroup"
+	"slices"
+	"sync/atomic"
+)
+
+var ErrCrossShardMerge = errors.New("两门课程不在同一个分片，不能合并")
+
+// SlotCount 课程按 course_id 落到这么多个槽位上，课评id的标记就是课程的槽位
+const SlotCount = idgen.MaxTag + 1
+
+// CourseSlot 课程所在的槽位，不分库的时候也按它给课评id打标记，以后分库不用改id
+func CourseSlot(courseId int64) int64 {
+	return courseId % SlotCount
+}
+
+// ShardRouter 课程先按 course_id 落到固定的槽位，槽位再按配置映射到分片。
+// 扩容的时候把一部分槽位的数据搬到新分片上，再改这几个槽位的映射
+type ShardRouter struct {
+	// 下标是槽位，值是分片号
+	slots []int64
+}
+
+// NewShardRouter slots 不配置的时候槽位按分片数取模
+func NewShardRouter(shards int, slots []int64) (*ShardRouter, error) {
+	if shards <= 0 || shards > SlotCount {
+		return nil, fmt.Errorf("分片数 %d 超出范围 [1, %d]", shards, SlotCount)
+	}
+	if len(slots) == 0 {
+		slots = make([]int64, SlotCount)
+		for i := range slots {
+			slots[i] = int64(i % shards)
+		}
+	}
+	if len(slots) != SlotCount {
+		return nil, fmt.Errorf("槽位映射有 %d 项，应该是 %d 项", len(slots), SlotCount)
+	}
+	for slot, idx := range slots {
+		if idx < 0 || idx >= int64(shards) {
+			return nil, fmt.Errorf("槽位 %d 映射到了不存在的分片 %d", slot, idx)
+		}
+	}
+	return &ShardRouter{slots: slots}, nil
+}
+
+// Course 课程所在的分片
+func (r *ShardRouter) Course(courseId int64) int64 {
+	return r.slots[CourseSlot(courseId)]
+}
+
+// Evaluation 按课评id里面的标记找到分片。打标记之前生成的id，或者槽位搬过家，这里给的分片可能不对
+func (r *ShardRouter) Evaluation(evaluationId int64) int64 {
+	return r.slots[idgen.Tag(evaluationId)]
+}
+
+// ShardingEvaluationDAO 按课程的槽位分库，每个分片都是一个完整的库。
+// 课程的综合得分和它的课评在同一个分片，发布、修改课评的事务不会跨库。
+// 按用户和全局的查询要查所有分片再归并；按课评id的查询按id里面的标记直接去对应的分片
+type ShardingEvaluationDAO struct {
+	shards []EvaluationDAO
+	router *ShardRouter
+}
+
+func NewShardingEvaluationDAO(shards []EvaluationDAO, router *ShardRouter) EvaluationDAO {
+	return &ShardingEvaluationDAO{shards: shards, router: router}
+}
+
+func (d *ShardingEvaluationDAO) shard(courseId int64) EvaluationDAO {
+	return d.shards[d.router.Course(courseId)]
+}
+
+// scatter 并发查询所有分片，结果按分片的顺序排列，有一个分片失败就整体失败
+func scatter[T any](ctx context.Context, shards []EvaluationDAO,
+	fn func(ctx context.Context, dao EvaluationDAO) (T, error)) ([]T, error) {
+	res := make([]T, len(shards))
+	eg, ctx := errgroup.WithContext(ctx)
+	for i, s := range shards {
+		eg.Go(func() error {
+			r, err := fn(ctx, s)
+			res[i] = r
+			return err
+		})
+	}
+	return res, eg.Wait()
+}
+
+// mergeByUtime 每个分片各自取了前limit条，归并之后整体的前limit条一定在里面
+func mergeByUtime(lists [][]Evaluation, limit int64) []Evaluation {
+	res := slices.Concat(lists...)
+	slices.SortFunc(res, func(a, b Evaluation) int {
+		if a.Utime != b.Utime {
+			return compareInt64(b.Utime, a.Utime)
+		}
+		return compareInt64(b.Id, a.Id)
+	})
+	if int64(len(res)) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+func mergeAsc(lists [][]int64, limit int) []int64 {
+	res := slices.Concat(lists...)
+	slices.Sort(res)
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// locate 标记指向的分片上没有这条课评的时候，再查其余的分片
+func (d *ShardingEvaluationDAO) locate(ctx context.Context, evaluationId int64, skip int64) (EvaluationDAO, Evaluation, error) {
+	others := slices.Delete(slices.Clone(d.shards), int(skip), int(skip)+1)
+	found, err := scatter(ctx, others, func(ctx context.Context, dao EvaluationDAO) ([]Evaluation, error) {
+		e, err := dao.GetDetailById(ctx, evaluationId)
+		switch err {
+		case nil:
+			return []Evaluation{e}, nil
+		case ErrorRecordNotFind:
+			return nil, nil
+		default:
+			return nil, err
+		}
+	})
+	if err != nil {
+		return nil, Evaluation{}, err
+	}
+	for i, es := range found {
+		if len(es) > 0 {
+			return others[i], es[0], nil
+		}
+	}
+	return nil, Evaluation{}, ErrorRecordNotFind
+}
+
+func (d *ShardingEvaluationDAO) FindEvaluation(ctx context.Context, publisherId int64, courseId int64) (Evaluation, error) {
+	return d.shard(courseId).FindEvaluation(ctx, publisherId, courseId)
+}
+
+func (d *ShardingEvaluationDAO) UpdateStatus(ctx context.Context, evaluationId int64, status uint32, uid int64) (OldEvaluation, error) {
+	idx := d.router.Evaluation(evaluationId)
+	oe, err := d.shards[idx].UpdateStatus(ctx, evaluationId, status, uid)
+	if err != ErrorRecordNotFind {
+		return oe, err
+	}
+	dao, _, err := d.locate(ctx, evaluationId, idx)
+	if err != nil {
+		return OldEvaluation{}, err
+	}
+	return dao.UpdateStatus(ctx, evaluationId, status, uid)
+}
+
+func (d *ShardingEvaluationDAO) UpdateById(ctx context.Context, evaluation Evaluation) (OldEvaluation, error) {
+	idx := d.router.Evaluation(evaluation.Id)
+	oe, err := d.shards[idx].UpdateById(ctx, evaluation)
+	if err != ErrorRecordNotFind {
+		return oe, err
+	}
+	dao, _, err := d.locate(ctx, evaluation.Id, idx)
+	if err != nil {
+		return OldEvaluation{}, err
+	}
+	return dao.UpdateById(ctx, evaluation)
+}
+
+// Insert 各个分片共用同一个id生成器，id全局唯一，id的标记是课程的槽位
+func (d *ShardingEvaluationDAO) Insert(ctx context.Context, evaluation Evaluation) (int64, error) {
+	return d.shard(evaluation.CourseId).Insert(ctx, evaluation)
+}
+
+func (d *ShardingEvaluationDAO) InsertWithTime(ctx context.Context, evaluation Evaluation) (int64, error) {
+	return d.shard(evaluation.CourseId).InsertWithTime(ctx, evaluation)
+}
+
+func (d *ShardingEvaluationDAO) GetListRecent(ctx context.Context, curEvaluationId int64, limit int64, property int32) ([]Evaluation, error) {
+	lists, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) ([]Evaluation, error) {
+		return dao.GetListRecent(ctx, curEvaluationId, limit, property)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeByUtime(lists, limit), nil
+}
+
+func (d *ShardingEvaluationDAO) GetListRecentTimeline(ctx context.Context, limit int64, property int32) ([]Evaluation, error) {
+	lists, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) ([]Evaluation, error) {
+		return dao.GetListRecentTimeline(ctx, limit, property)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeByUtime(lists, limit), nil
+}
+
+func (d *ShardingEvaluationDAO) GetListByIds(ctx context.Context, ids []int64) ([]Evaluation, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	lists, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) ([]Evaluation, error) {
+		return dao.GetListByIds(ctx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(lists...), nil
+}
+
+func (d *ShardingEvaluationDAO) GetListCourse(ctx context.Context, curEvaluationId int64, limit int64, courseId int64) ([]Evaluation, error) {
+	return d.shard(courseId).GetListCourse(ctx, curEvaluationId, limit, courseId)
+}
+
+func (d *ShardingEvaluationDAO) GetListMine(ctx context.Context, curEvaluationId int64, limit int64, uid int64, status int32) ([]Evaluation, error) {
+	lists, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) ([]Evaluation, error) {
+		return dao.GetListMine(ctx, curEvaluationId, limit, uid, status)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeByUtime(lists, limit), nil
+}
+
+func (d *ShardingEvaluationDAO) GetListCourseIds(ctx context.Context, curEvaluationId int64, limit int64, courseId int64) ([]int64, error) {
+	return d.shard(courseId).GetListCourseIds(ctx, curEvaluationId, limit, courseId)
+}
+
+// GetListMineIds 归并要用到utime，所以各个分片查的是完整的课评
+func (d *ShardingEvaluationDAO) GetListMineIds(ctx context.Context, curEvaluationId int64, limit int64, uid int64, status int32) ([]int64, error) {
+	evaluations, err := d.GetListMine(ctx, curEvaluationId, limit, uid, status)
+	if err != nil {
+		return nil, err
+	}
+	return evaluationIds(evaluations), nil
+}
+
+func (d *ShardingEvaluationDAO) GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
+	return d.shard(courseId).GetCountCourseInvisible(ctx, courseId)
+}
+
+func (d *ShardingEvaluationDAO) GetCountMine(ctx context.Context, uid int64, status int32) (int64, error) {
+	counts, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) (int64, error) {
+		return dao.GetCountMine(ctx, uid, status)
+	})
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, cnt := range counts {
+		total += cnt
+	}
+	return total, nil
+}
+
+func (d *ShardingEvaluationDAO) GetCountMineGroupByStatus(ctx context.Context, uid int64) (map[int32]int64, error) {
+	rows, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) (map[int32]int64, error) {
+		return dao.GetCountMineGroupByStatus(ctx, uid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int32]int64)
+	for _, row := range rows {
+		for status, cnt := range row {
+			res[status] += cnt
+		}
+	}
+	return res, nil
+}
+
+func (d *ShardingEvaluationDAO) GetDetailById(ctx context.Context, evaluationId int64) (Evaluation, error) {
+	idx := d.router.Evaluation(evaluationId)
+	evaluation, err := d.shards[idx].GetDetailById(ctx, evaluationId)
+	if err != ErrorRecordNotFind {
+		return evaluation, err
+	}
+	_, evaluation, err = d.locate(ctx, evaluationId, idx)
+	return evaluation, err
+}
+
+func (d *ShardingEvaluationDAO) GetPublishersByCourseIdStatus(ctx context.Context, courseId int64, status int32) ([]int64, error) {
+	return d.shard(courseId).GetPublishersByCourseIdStatus(ctx, courseId, status)
+}
+
+func (d *ShardingEvaluationDAO) GetCompositeScoreByCourseId(ctx context.Context, courseId int64) (CompositeScore, error) {
+	return d.shard(courseId).GetCompositeScoreByCourseId(ctx, courseId)
+}
+
+func (d *ShardingEvaluationDAO) UpdateIsAnonymousById(ctx context.Context, uid int64, courseId int64, fields map[string]any) error {
+	return d.shard(courseId).UpdateIsAnonymousById(ctx, uid, courseId, fields)
+}
+
+func (d *ShardingEvaluationDAO) GetIdsAfter(ctx context.Context, startId int64, limit int) ([]int64, error) {
+	lists, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) ([]int64, error) {
+		return dao.GetIdsAfter(ctx, startId, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeAsc(lists, limit), nil
+}
+
+// GetCourseIdsAfter 一门课程只在一个分片上，归并之后不会重复
+func (d *ShardingEvaluationDAO) GetCourseIdsAfter(ctx context.Context, startCourseId int64, limit int) ([]int64, error) {
+	lists, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) ([]int64, error) {
+		return dao.GetCourseIdsAfter(ctx, startCourseId, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeAsc(lists, limit), nil
+}
+
+func (d *ShardingEvaluationDAO) UpdateCourseProperty(ctx context.Context, courseId int64, property int32, limit int) ([]Evaluation, error) {
+	return d.shard(courseId).UpdateCourseProperty(ctx, courseId, property, limit)
+}
+
+// FindOrCreateCourseMergeTask 合并是一个本地事务，只支持同一个分片上的两门课程。
+// 各个分片的任务id会重复，返回的id里面编码了分片号
+func (d *ShardingEvaluationDAO) FindOrCreateCourseMergeTask(ctx context.Context, sourceCourseId int64, targetCourseId int64) (CourseMergeTask, error) {
+	idx := d.router.Course(sourceCourseId)
+	if idx != d.router.Course(targetCourseId) {
+		return CourseMergeTask{}, ErrCrossShardMerge
+	}
+	task, err := d.shards[idx].FindOrCreateCourseMergeTask(ctx, sourceCourseId, targetCourseId)
+	task.Id = task.Id*int64(len(d.shards)) + idx
+	return task, err
+}
+
+func (d *ShardingEvaluationDAO) MergeCourseBatch(ctx context.Context, taskId int64, limit int) (CourseMergeBatch, error) {
+	n := int64(len(d.shards))
+	return d.shards[taskId%n].MergeCourseBatch(ctx, taskId/n, limit)
+}
+
+func (d *ShardingEvaluationDAO) GetListForExport(ctx context.Context, filter ExportFilter, afterId int64, limit int) ([]Evaluation, error) {
+	if filter.CourseId > 0 {
+		return d.shard(filter.CourseId).GetListForExport(ctx, filter, afterId, limit)
+	}
+	lists, err := scatter(ctx, d.shards, func(ctx context.Context, dao EvaluationDAO) ([]Evaluation, error) {
+		return dao.GetListForExport(ctx, filter, afterId, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := slices.Concat(lists...)
+	slices.SortFunc(res, func(a, b Evaluation) int {
+		return compareInt64(a.Id, b.Id)
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// ShardingEvaluationEventDAO 每个分片都有自己的发件箱，轮流投递，免得一个分片积压的时候别的分片一直排不上
+type ShardingEvaluationEventDAO struct {
+	shards []EvaluationEventDAO
+	next   atomic.Int64
+}
+
+func NewShardingEvaluationEventDAO(shards []EvaluationEventDAO) EvaluationEventDAO {
+	return &ShardingEvaluationEventDAO{shards: shards}
+}
+
+// ConsumePending 各个分片的事件id会重复，交出去之前在id里面编码分片号，保证消费方可以按 EventId 去重
+func (d *ShardingEvaluationEventDAO) ConsumePending(ctx context.Context, limit int, fn func(evt EvaluationEvent) error) (int, error) {
+	n := int64(len(d.shards))
+	start := d.next.Add(1)
+	consumed := 0
+	for i := int64(0); i < n && consumed < limit; i++ {
+		idx := (start + i) % n
+		cnt, err := d.shards[idx].ConsumePending(ctx, limit-consumed, func(evt EvaluationEvent) error {
+			evt.Id = evt.Id*n + idx
+			return fn(evt)
+		})
+		consumed += cnt
+		if err != nil {
+			return consumed, err
+		}
+	}
+	return consumed, nil
+}
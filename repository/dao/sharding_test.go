@@ -0,0 +1,161 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNewShardRouter(t *testing.T) {
+	custom := make([]int64, SlotCount)
+	custom[3] = 1
+	testCases := []struct {
+		name    string
+		shards  int
+		slots   []int64
+		wantErr bool
+		// 课程 -> 分片
+		want map[int64]int64
+	}{
+		{
+			name:   "不配置的时候槽位按分片数取模",
+			shards: 3,
+			want:   map[int64]int64{0: 0, 4: 1, 5: 2, SlotCount + 1: 1},
+		},
+		{
+			name:   "按配置把槽位映射到分片",
+			shards: 2,
+			slots:  custom,
+			want:   map[int64]int64{1: 0, 3: 1, SlotCount + 3: 1, 4: 0},
+		},
+		{
+			name:    "映射的项数不对",
+			shards:  2,
+			slots:   []int64{0, 1},
+			wantErr: true,
+		},
+		{
+			name:    "映射到不存在的分片",
+			shards:  1,
+			slots:   custom,
+			wantErr: true,
+		},
+		{
+			name:    "分片比槽位还多",
+			shards:  SlotCount + 1,
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := NewShardRouter(tc.shards, tc.slots)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			for courseId, want := range tc.want {
+				if got := r.Course(courseId); got != want {
+					t.Fatalf("课程 %d 路由到分片 %d，想要 %d", courseId, got, want)
+				}
+			}
+		})
+	}
+}
+
+// countingDAO 记录按id查询和修改落到了这个分片多少次
+type countingDAO struct {
+	EvaluationDAO
+	calls int
+}
+
+func (d *countingDAO) GetDetailById(ctx context.Context, evaluationId int64) (Evaluation, error) {
+	d.calls++
+	return d.EvaluationDAO.GetDetailById(ctx, evaluationId)
+}
+
+func (d *countingDAO) UpdateStatus(ctx context.Context, evaluationId int64, status uint32, uid int64) (OldEvaluation, error) {
+	d.calls++
+	return d.EvaluationDAO.UpdateStatus(ctx, evaluationId, status, uid)
+}
+
+func (d *countingDAO) UpdateById(ctx context.Context, evaluation Evaluation) (OldEvaluation, error) {
+	d.calls++
+	return d.EvaluationDAO.UpdateById(ctx, evaluation)
+}
+
+func TestShardingEvaluationDAO_RouteById(t *testing.T) {
+	testCases := []struct {
+		name     string
+		courseId int64
+		// 打标记之前生成的id，标记指向的不是课程所在的分片
+		legacyId int64
+		// 两个分片各自被按id查询、修改的次数
+		wantCalls []int
+	}{
+		{
+			name:      "按id里面的标记直接去课程所在的分片",
+			courseId:  1,
+			wantCalls: []int{0, 3},
+		},
+		{
+			name:     "老的id先查标记指向的分片，找不到再查其余的分片",
+			courseId: 1,
+			// 标记是0，指向分片0
+			legacyId:  SlotCount * 100,
+			wantCalls: []int{3, 5},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			router, err := NewShardRouter(2, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shards := []*countingDAO{
+				{EvaluationDAO: NewGORMEvaluationDAO(newTestDB(t), newTestIdGen(t))},
+				{EvaluationDAO: NewGORMEvaluationDAO(newTestDB(t), newTestIdGen(t))},
+			}
+			d := NewShardingEvaluationDAO([]EvaluationDAO{shards[0], shards[1]}, router)
+			id, err := d.Insert(ctx, Evaluation{Id: tc.legacyId, PublisherId: 7, CourseId: tc.courseId,
+				StarRating: 5, Content: "好", Status: EvaluationStatusPublic})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := d.GetDetailById(ctx, id)
+			if err != nil || e.CourseId != tc.courseId {
+				t.Fatalf("查到 %+v, err = %v", e, err)
+			}
+			if _, err = d.UpdateStatus(ctx, id, EvaluationStatusPrivate, 7); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = d.UpdateById(ctx, Evaluation{Id: id, PublisherId: 7, StarRating: 4, Content: "还行",
+				Status: EvaluationStatusPublic}); err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range shards {
+				if s.calls != tc.wantCalls[i] {
+					t.Fatalf("分片 %d 被调用了 %d 次，想要 %d 次", i, s.calls, tc.wantCalls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestShardingEvaluationDAO_NotFound(t *testing.T) {
+	ctx := context.Background()
+	router, err := NewShardRouter(2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewShardingEvaluationDAO([]EvaluationDAO{
+		NewGORMEvaluationDAO(newTestDB(t), newTestIdGen(t)),
+		NewGORMEvaluationDAO(newTestDB(t), newTestIdGen(t)),
+	}, router)
+	if _, err = d.GetDetailById(ctx, 12345); !errors.Is(err, ErrorRecordNotFind) {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err = d.UpdateStatus(ctx, 12345, EvaluationStatusPrivate, 7); !errors.Is(err, ErrorRecordNotFind) {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// 目标库少了这条
+	DiffTypeMissing = "missing"
+	// 两边都有但是不一样
+	DiffTypeMismatch = "mismatch"
+	// 基准库已经没有了，目标库还有
+	DiffTypeExtra = "extra"
+)
+
+type EvaluationDiff struct {
+	Type         string
+	EvaluationId int64
+}
+
+type ValidateResult struct {
+	Checked int64
+	Diffs   int64
+	// 开启修复的时候修好的条数
+	Repaired int64
+}
+
+// EvaluationValidator 以base为准分批对比target，可以顺便把target修成和base一致。
+// 双写阶段以主库为准，切到DST_FIRST之前要以源库为准跑一遍修复
+type EvaluationValidator struct {
+	base      *gorm.DB
+	target    *gorm.DB
+	batchSize int
+	repair    bool
+	// 每发现一处不一致回调一次，用来上报
+	report func(diff EvaluationDiff)
+}
+
+func NewEvaluationValidator(base *gorm.DB, target *gorm.DB, repair bool, report func(diff EvaluationDiff)) *EvaluationValidator {
+	return &EvaluationValidator{base: base, target: target, batchSize: 500, repair: repair, report: report}
+}
+
+func (v *EvaluationValidator) Validate(ctx context.Context) (ValidateResult, error) {
+	var res ValidateResult
+	// 先删掉多出来的，否则修复的时候可能撞上 publisherId_courseId 唯一索引
+	err := v.validateTargetToBase(ctx, &res)
+	if err != nil {
+		return res, err
+	}
+	err = v.validateBaseToTarget(ctx, &res)
+	return res, err
+}
+
+// validateBaseToTarget 找出目标库缺少的和不一致的
+func (v *EvaluationValidator) validateBaseToTarget(ctx context.Context, res *ValidateResult) error {
+	var afterId int64
+	for {
+		var bases []Evaluation
+		err := v.base.WithContext(ctx).Where("id > ?", afterId).Order("id").Limit(v.batchSize).Find(&bases).Error
+		if err != nil {
+			return err
+		}
+		if len(bases) == 0 {
+			return nil
+		}
+		var targets []Evaluation
+		err = v.target.WithContext(ctx).Where("id IN ?", evaluationIds(bases)).Find(&targets).Error
+		if err != nil {
+			return err
+		}
+		targetMap := make(map[int64]Evaluation, len(targets))
+		for _, t := range targets {
+			targetMap[t.Id] = t
+		}
+		// affected 额外记下目标库里面的旧课程，课程变了的话两门课的得分都要重算
+		var broken, affected []Evaluation
+		for _, b := range bases {
+			t, ok := targetMap[b.Id]
+			switch {
+			case !ok:
+				v.report(EvaluationDiff{Type: DiffTypeMissing, EvaluationId: b.Id})
+			case t != b:
+				v.report(EvaluationDiff{Type: DiffTypeMismatch, EvaluationId: b.Id})
+				affected = append(affected, t)
+			default:
+				continue
+			}
+			broken = append(broken, b)
+			affected = append(affected, b)
+		}
+		res.Checked += int64(len(bases))
+		res.Diffs += int64(len(broken))
+		if v.repair && len(broken) > 0 {
+			err = v.target.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&broken).Error
+				if err != nil {
+					return err
+				}
+				return v.recomputeCompositeScores(tx, affected)
+			})
+			if err != nil {
+				return err
+			}
+			res.Repaired += int64(len(broken))
+		}
+		afterId = bases[len(bases)-1].Id
+	}
+}
+
+// validateTargetToBase 找出目标库多出来的
+func (v *EvaluationValidator) validateTargetToBase(ctx context.Context, res *ValidateResult) error {
+	var afterId int64
+	for {
+		var targets []Evaluation
+		err := v.target.WithContext(ctx).Select("id, course_id").
+			Where("id > ?", afterId).Order("id").Limit(v.batchSize).Find(&targets).Error
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return nil
+		}
+		var baseIds []int64
+		err = v.base.WithContext(ctx).Model(&Evaluation{}).
+			Where("id IN ?", evaluationIds(targets)).Pluck("id", &baseIds).Error
+		if err != nil {
+			return err
+		}
+		exists := make(map[int64]struct{}, len(baseIds))
+		for _, id := range baseIds {
+			exists[id] = struct{}{}
+		}
+		var extra []Evaluation
+		for _, t := range targets {
+			if _, ok := exists[t.Id]; !ok {
+				v.report(EvaluationDiff{Type: DiffTypeExtra, EvaluationId: t.Id})
+				extra = append(extra, t)
+			}
+		}
+		res.Diffs += int64(len(extra))
+		if v.repair && len(extra) > 0 {
+			err = v.target.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				err := tx.Where("id IN ?", evaluationIds(extra)).Delete(&Evaluation{}).Error
+				if err != nil {
+					return err
+				}
+				return v.recomputeCompositeScores(tx, extra)
+			})
+			if err != nil {
+				return err
+			}
+			res.Repaired += int64(len(extra))
+		}
+		afterId = targets[len(targets)-1].Id
+	}
+}
+
+// recomputeCompositeScores 直接改了课评，增量维护的综合得分已经不可信，按课程重算
+func (v *EvaluationValidator) recomputeCompositeScores(tx *gorm.DB, evaluations []Evaluation) error {
+	courseIds := make(map[int64]struct{}, len(evaluations))
+	for _, e := range evaluations {
+		courseIds[e.CourseId] = struct{}{}
+	}
+	for courseId := range courseIds {
+		if err := recomputeCompositeScore(tx, courseId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
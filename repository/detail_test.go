@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"testing"
+)
+
+// blockingDAO 查库的时候先等着，放行之后按ctx的状态返回
+type blockingDAO struct {
+	dao.EvaluationDAO
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *blockingDAO) GetDetailById(ctx context.Context, evaluationId int64) (dao.Evaluation, error) {
+	close(d.started)
+	<-d.release
+	if err := ctx.Err(); err != nil {
+		return dao.Evaluation{}, err
+	}
+	return dao.Evaluation{Id: evaluationId, StarRating: 5}, nil
+}
+
+func TestEvaluationRepository_GetDetailByIdFirstCallerCanceled(t *testing.T) {
+	d := &blockingDAO{started: make(chan struct{}), release: make(chan struct{})}
+	c := &emptyCaches{detail: map[int64]domain.Evaluation{}}
+	repo := NewEvaluationRepository(d, c, nil, c, nil, c, c, nil, logger.NewNopLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := repo.GetDetailById(ctx, 1)
+		first <- err
+	}()
+	<-d.started
+	// 第一个请求取消了，回源还要继续，同一个回源上的其它请求拿到的是同一个结果
+	cancel()
+	close(d.release)
+	if err := <-first; err != nil {
+		t.Fatalf("回源跟着第一个请求取消了: %v", err)
+	}
+	if e := c.detail[1]; e.StarRating != 5 {
+		t.Fatalf("没有回写缓存 %+v", e)
+	}
+}
@@ -3,17 +3,50 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
 	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
 	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
 	"github.com/MuxiKeStack/be-evaluation/domain"
 	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
 	"github.com/MuxiKeStack/be-evaluation/repository/cache"
 	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"github.com/ecodeclub/ekit/mapx"
 	"github.com/ecodeclub/ekit/slice"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/seata/seata-go/pkg/tm"
+	"golang.org/x/sync/singleflight"
 	"time"
 )
 
-var ErrEvaluationNotFound = dao.ErrorRecordNotFind
+var (
+	ErrEvaluationNotFound      = dao.ErrorRecordNotFind
+	ErrDuplicateEvaluation     = dao.ErrDuplicateEvaluation
+	ErrPermissionDenied        = dao.ErrPermissionDenied
+	ErrIllegalStatusTransition = dao.ErrIllegalStatusTransition
+	ErrTooManyRequests         = dao.ErrTooManyRequests
+	// ErrCompositeScoreUnavailable 综合得分刚刚回源失败，处于负缓存期
+	ErrCompositeScoreUnavailable = errors.New("课程综合得分暂时不可用")
+)
+
+// VersionConflictError 修改课评时带的版本号不是最新的，Current 是现在的课评
+type VersionConflictError struct {
+	Current domain.Evaluation
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("课评已经被修改过了，当前版本 %d", e.Current.Version)
+}
+
+const (
+	// 回源查库和回写缓存一共的时间上限
+	compositeScoreLoadTimeout = time.Second * 2
+	// 课评详情回源查库和回写缓存一共的时间上限
+	detailLoadTimeout = time.Second * 2
+	// 回源失败之后的负缓存时间
+	compositeScoreFailureTTL = time.Second
+	// 负缓存最多记录的课程数，防止数据库故障期间被随机id撑爆内存
+	compositeScoreFailureSize = 4096
+)
 
 type EvaluationRepository interface {
 	Evaluated(ctx context.Context, publisherId int64, courseId int64) (bool, error)
@@ -28,19 +61,56 @@ type EvaluationRepository interface {
 	GetDetailById(ctx context.Context, evaluationId int64) (domain.Evaluation, error)
 	GetPublishersByCourseIdStatus(ctx context.Context, courseId int64, status evaluationv1.EvaluationStatus) ([]int64, error)
 	GetCompositeScoreByCourseId(ctx context.Context, courseId int64) (domain.CompositeScore, error)
+	// RebuildBloomFilters 从数据库全量重建布隆过滤器，多个实例同时调用只有一个会真正执行
+	RebuildBloomFilters(ctx context.Context) error
+	// BuildBloomFiltersIfAbsent 只重建还没有建好的布隆过滤器，启动的时候调用
+	BuildBloomFiltersIfAbsent(ctx context.Context) error
+	// SyncCourseProperty 课程改了性质之后，把冗余在课评上的课程性质改过来
+	SyncCourseProperty(ctx context.Context, courseId int64, property coursev1.CourseProperty) error
+	GetCourseIdsAfter(ctx context.Context, startCourseId int64, limit int) ([]int64, error)
+	// MergeCourse 把源课程的课评合并到目标课程，可以重复调用，中断之后会从上次的进度继续
+	MergeCourse(ctx context.Context, sourceCourseId int64, targetCourseId int64) error
+	// Import 导入历史课评，保留原来的ctime和utime，已经存在的返回 ErrDuplicateEvaluation。
+	// 写进数据库之后更新缓存出错只记日志，不返回错误
+	Import(ctx context.Context, evaluation domain.Evaluation) (int64, error)
+	// GetListForExport 直接查库，不经过缓存，导出的数据不会再被读第二次。只查公开的课评
+	GetListForExport(ctx context.Context, filter domain.ExportFilter, afterId int64, limit int) ([]domain.Evaluation, error)
 }
 
 type evaluationRepository struct {
-	dao   dao.EvaluationDAO
-	cache cache.EvaluationCache
-	l     logger.Logger
+	dao         dao.EvaluationDAO
+	cache       cache.EvaluationCache
+	recentCache cache.RecentEvaluationCache
+	detailCache cache.EvaluationDetailCache
+	countCache  cache.EvaluationCountCache
+	// 挡住一定不存在的课程和课评，防止缓存穿透
+	courseBloom     cache.CourseBloomFilter
+	evaluationBloom cache.EvaluationBloomFilter
+	// 刚写过数据的用户读自己的数据走主库
+	sticky cache.PrimaryStickyCache
+	l      logger.Logger
+	g      singleflight.Group
+	// 综合得分回源失败的课程，值是负缓存的过期时间
+	loadFailures *lru.Cache[int64, time.Time]
 }
 
-func NewEvaluationRepository(dao dao.EvaluationDAO, cache cache.EvaluationCache, l logger.Logger) EvaluationRepository {
-	return &evaluationRepository{dao: dao, cache: cache, l: l}
+func NewEvaluationRepository(dao dao.EvaluationDAO, cache cache.EvaluationCache, recentCache cache.RecentEvaluationCache,
+	detailCache cache.EvaluationDetailCache, countCache cache.EvaluationCountCache, courseBloom cache.CourseBloomFilter,
+	evaluationBloom cache.EvaluationBloomFilter, sticky cache.PrimaryStickyCache, l logger.Logger) EvaluationRepository {
+	loadFailures, err := lru.New[int64, time.Time](compositeScoreFailureSize)
+	if err != nil {
+		panic(err)
+	}
+	return &evaluationRepository{dao: dao, cache: cache, recentCache: recentCache, detailCache: detailCache,
+		countCache: countCache, courseBloom: courseBloom, evaluationBloom: evaluationBloom, sticky: sticky, l: l,
+		loadFailures: loadFailures}
 }
 
 func (repo *evaluationRepository) GetCompositeScoreByCourseId(ctx context.Context, courseId int64) (domain.CompositeScore, error) {
+	if !repo.mightContain(ctx, repo.courseBloom, courseId) {
+		// 没有课评的课程，和数据库里面查不到的结果一样
+		return domain.CompositeScore{CourseId: courseId}, nil
+	}
 	res, err := repo.cache.GetCompositeScore(ctx, courseId)
 	if err == nil {
 		return res, nil
@@ -48,28 +118,32 @@ func (repo *evaluationRepository) GetCompositeScoreByCourseId(ctx context.Contex
 	if err != cache.ErrKeyNotExists {
 		repo.l.Error("redis出错", logger.Error(err), logger.Int64("courseId", courseId))
 	}
-	// 查库
-	cs, err := repo.dao.GetCompositeScoreByCourseId(ctx, courseId)
-	res = domain.CompositeScore{
-		CourseId: cs.CourseId,
-		Score:    cs.Score,
-		RaterCnt: cs.RaterCnt,
+	// 刚刚回源失败过，短时间内不再打数据库
+	if until, ok := repo.loadFailures.Get(courseId); ok && time.Now().Before(until) {
+		return domain.CompositeScore{}, ErrCompositeScoreUnavailable
 	}
-	go func() {
-		if err == nil || err == dao.ErrorRecordNotFind {
-			// 即使没找到也缓存一个空，为了防止恶意用户带来的缓存穿透
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-			defer cancel()
-			er := repo.cache.SetCompositeScore(ctx, courseId, res)
-			if er != nil {
-				repo.l.Error("回写课程综合得分缓存失败", logger.Error(err), logger.Int64("courseId", courseId))
-			}
+	// 同一个课程的并发回源共用一次查库和一次回写
+	val, err, _ := repo.g.Do(fmt.Sprintf("composite_score:%d", courseId), func() (interface{}, error) {
+		// 不跟随第一个请求的取消，否则所有等待者都会一起失败
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compositeScoreLoadTimeout)
+		defer cancel()
+		cs, err := repo.dao.GetCompositeScoreByCourseId(fillContext(ctx), courseId)
+		if err != nil && err != dao.ErrorRecordNotFind {
+			repo.loadFailures.Add(courseId, time.Now().Add(compositeScoreFailureTTL))
+			return domain.CompositeScore{}, err
+		}
+		// 没有找到也是正常行为，同样缓存一个空的，防止恶意用户带来的缓存穿透
+		res := domain.CompositeScore{
+			CourseId: courseId,
+			Score:    cs.Score,
+			RaterCnt: cs.RaterCnt,
+		}
+		if er := repo.cache.SetCompositeScore(ctx, courseId, res); er != nil {
+			repo.l.Error("回写课程综合得分缓存失败", logger.Error(er), logger.Int64("courseId", courseId))
 		}
-	}()
-	if err == nil || err == dao.ErrorRecordNotFind { // 不返回任何err，因为没有找到也属于正常行为
 		return res, nil
-	}
-	return res, err
+	})
+	return val.(domain.CompositeScore), err
 }
 
 func (repo *evaluationRepository) GetPublishersByCourseIdStatus(ctx context.Context, courseId int64, status evaluationv1.EvaluationStatus) ([]int64, error) {
@@ -77,47 +151,311 @@ func (repo *evaluationRepository) GetPublishersByCourseIdStatus(ctx context.Cont
 }
 
 func (repo *evaluationRepository) GetDetailById(ctx context.Context, evaluationId int64) (domain.Evaluation, error) {
-	evaluation, err := repo.dao.GetDetailById(ctx, evaluationId)
-	return repo.toDomain(evaluation), err
+	if !repo.mightContain(ctx, repo.evaluationBloom, evaluationId) {
+		return domain.Evaluation{}, ErrEvaluationNotFound
+	}
+	res, err := repo.detailCache.Get(ctx, evaluationId)
+	switch err {
+	case nil:
+		return res, nil
+	case cache.ErrEvaluationNotFound:
+		return domain.Evaluation{}, ErrEvaluationNotFound
+	case cache.ErrKeyNotExists:
+	default:
+		repo.l.Error("redis出错", logger.Error(err), logger.Int64("evaluationId", evaluationId))
+	}
+	// 同一个课评的并发回源只放一个请求去查库，防止缓存击穿
+	val, err, _ := repo.g.Do(fmt.Sprintf("detail:%d", evaluationId), func() (interface{}, error) {
+		// 不跟随第一个请求的取消，否则所有等待者都会一起失败
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), detailLoadTimeout)
+		defer cancel()
+		evaluation, err := repo.dao.GetDetailById(fillContext(ctx), evaluationId)
+		switch err {
+		case nil:
+			res := repo.toDomain(evaluation)
+			if er := repo.detailCache.Set(ctx, res); er != nil {
+				repo.l.Error("回写课评缓存失败", logger.Error(er), logger.Int64("evaluationId", evaluationId))
+			}
+			return res, nil
+		case dao.ErrorRecordNotFind:
+			// 缓存一个空，防止恶意用户带来的缓存穿透
+			if er := repo.detailCache.SetNotFound(ctx, evaluationId); er != nil {
+				repo.l.Error("回写课评空缓存失败", logger.Error(er), logger.Int64("evaluationId", evaluationId))
+			}
+			return domain.Evaluation{}, err
+		default:
+			return domain.Evaluation{}, err
+		}
+	})
+	return val.(domain.Evaluation), err
 }
 
 func (repo *evaluationRepository) GetCountMine(ctx context.Context, uid int64, status evaluationv1.EvaluationStatus) (int64, error) {
-	return repo.dao.GetCountMine(ctx, uid, int32(status))
+	cnt, err := repo.countCache.GetCountMine(ctx, uid, status)
+	if err == nil {
+		return cnt, nil
+	}
+	if err != cache.ErrKeyNotExists {
+		repo.l.Error("redis出错", logger.Error(err), logger.Int64("uid", uid))
+	}
+	// 计数器不存在，从数据库一次性重建这个用户所有状态的计数
+	rows, err := repo.dao.GetCountMineGroupByStatus(fillContext(ctx), uid)
+	if err != nil {
+		return 0, err
+	}
+	counts := map[evaluationv1.EvaluationStatus]int64{
+		evaluationv1.EvaluationStatus_Public:  0,
+		evaluationv1.EvaluationStatus_Private: 0,
+		evaluationv1.EvaluationStatus_Folded:  0,
+		status:                                0,
+	}
+	for s, c := range rows {
+		counts[evaluationv1.EvaluationStatus(s)] = c
+	}
+	if er := repo.countCache.SetCountMine(ctx, uid, counts); er != nil {
+		repo.l.Error("回写用户课评计数缓存失败", logger.Error(er), logger.Int64("uid", uid))
+	}
+	return counts[status], nil
 }
 
 func (repo *evaluationRepository) GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
-	return repo.dao.GetCountCourseInvisible(ctx, courseId)
+	cnt, err := repo.countCache.GetCountCourseInvisible(ctx, courseId)
+	if err == nil {
+		return cnt, nil
+	}
+	if err != cache.ErrKeyNotExists {
+		repo.l.Error("redis出错", logger.Error(err), logger.Int64("courseId", courseId))
+	}
+	cnt, err = repo.dao.GetCountCourseInvisible(fillContext(ctx), courseId)
+	if err != nil {
+		return 0, err
+	}
+	if er := repo.countCache.SetCountCourseInvisible(ctx, courseId, cnt); er != nil {
+		repo.l.Error("回写课程不可见课评计数缓存失败", logger.Error(er), logger.Int64("courseId", courseId))
+	}
+	return cnt, nil
+}
+
+// markWritten 从库追上之前，这个用户读自己的数据都走主库
+func (repo *evaluationRepository) markWritten(ctx context.Context, uid int64) {
+	if err := repo.sticky.MarkWritten(ctx, uid); err != nil {
+		repo.l.Error("标记用户读主库失败", logger.Error(err), logger.Int64("uid", uid))
+	}
+}
+
+// ownReadContext 用户读自己的数据，最近写过就走主库，redis出错的时候也走主库，宁可多压一点主库也不能让用户看不到自己刚写的
+func (repo *evaluationRepository) ownReadContext(ctx context.Context, uid int64) context.Context {
+	ok, err := repo.sticky.RecentlyWritten(ctx, uid)
+	if err != nil {
+		repo.l.Error("redis出错", logger.Error(err), logger.Int64("uid", uid))
+		return dao.WithPrimary(ctx)
+	}
+	if ok {
+		return dao.WithPrimary(ctx)
+	}
+	return ctx
+}
+
+// fillContext 查出来要回写共享缓存的读都走主库。写操作删掉缓存之后从库可能还没追上，
+// 从从库读到的旧数据会被写回缓存，一直留到缓存过期。只有未命中的时候才会回源，主库的压力不大
+func fillContext(ctx context.Context) context.Context {
+	return dao.WithPrimary(ctx)
+}
+
+// syncCounts err是更新计数器的结果，更新失败就删掉计数器，下次读的时候重建
+func (repo *evaluationRepository) syncCounts(ctx context.Context, uid int64, courseId int64, err error) {
+	if err == nil {
+		return
+	}
+	repo.l.Error("更新课评计数缓存失败", logger.Error(err), logger.Int64("uid", uid), logger.Int64("courseId", courseId))
+	if err = repo.countCache.Delete(ctx, uid, courseId); err != nil {
+		repo.l.Error("删除课评计数缓存失败", logger.Error(err), logger.Int64("uid", uid), logger.Int64("courseId", courseId))
+	}
 }
 
 func (repo *evaluationRepository) GetListCourse(ctx context.Context, curEvaluationId int64, limit int64,
 	courseId int64) ([]domain.Evaluation, error) {
-	evaluations, err := repo.dao.GetListCourse(ctx, curEvaluationId, limit, courseId)
-	return slice.Map(evaluations, func(idx int, src dao.Evaluation) domain.Evaluation {
-		return repo.toDomain(src)
-	}), err
+	ids, err := repo.dao.GetListCourseIds(ctx, curEvaluationId, limit, courseId)
+	if err != nil {
+		return nil, err
+	}
+	return repo.getListByIds(ctx, ids, evaluationv1.EvaluationStatus_Public)
 }
 
 func (repo *evaluationRepository) GetListMine(ctx context.Context, curEvaluationId int64, limit int64, uid int64,
 	status evaluationv1.EvaluationStatus) ([]domain.Evaluation, error) {
-	evaluations, err := repo.dao.GetListMine(ctx, curEvaluationId, limit, uid, int32(status))
-	return slice.Map(evaluations, func(idx int, src dao.Evaluation) domain.Evaluation {
-		return repo.toDomain(src)
-	}), err
+	ctx = repo.ownReadContext(ctx, uid)
+	ids, err := repo.dao.GetListMineIds(ctx, curEvaluationId, limit, uid, int32(status))
+	if err != nil {
+		return nil, err
+	}
+	return repo.getListByIds(ctx, ids, status)
 }
 
 func (repo *evaluationRepository) GetListRecent(ctx context.Context, curEvaluationId int64, limit int64,
 	property coursev1.CourseProperty) ([]domain.Evaluation, error) {
+	ids, err := repo.recentCache.GetRecent(ctx, property, curEvaluationId, limit)
+	switch err {
+	case nil:
+		return repo.getListByIds(ctx, ids, evaluationv1.EvaluationStatus_Public)
+	case cache.ErrRecentNotEnough:
+		// 翻到了时间线之外，直接查库
+	case cache.ErrKeyNotExists:
+		repo.rebuildRecent(property)
+	default:
+		repo.l.Error("redis出错", logger.Error(err), logger.Int32("property", int32(property)))
+	}
 	evaluations, err := repo.dao.GetListRecent(ctx, curEvaluationId, limit, int32(property))
 	return slice.Map(evaluations, func(idx int, src dao.Evaluation) domain.Evaluation {
 		return repo.toDomain(src)
 	}), err
 }
 
+// getListByIds 先从缓存批量取课评，没命中的再回表，按ids的顺序返回，状态已经变了的课评会被过滤掉
+func (repo *evaluationRepository) getListByIds(ctx context.Context, ids []int64,
+	status evaluationv1.EvaluationStatus) ([]domain.Evaluation, error) {
+	if len(ids) == 0 {
+		return []domain.Evaluation{}, nil
+	}
+	m, err := repo.detailCache.MGet(ctx, ids)
+	if err != nil {
+		repo.l.Error("redis出错", logger.Error(err))
+	}
+	if m == nil {
+		m = make(map[int64]domain.Evaluation, len(ids))
+	}
+	missed := slice.FilterMap(ids, func(idx int, src int64) (int64, bool) {
+		_, ok := m[src]
+		return src, !ok
+	})
+	if len(missed) > 0 {
+		evaluations, er := repo.dao.GetListByIds(fillContext(ctx), missed)
+		if er != nil {
+			return nil, er
+		}
+		for _, e := range evaluations {
+			de := repo.toDomain(e)
+			m[de.Id] = de
+			if er = repo.detailCache.Set(ctx, de); er != nil {
+				repo.l.Error("回写课评缓存失败", logger.Error(er), logger.Int64("evaluationId", de.Id))
+			}
+		}
+	}
+	res := make([]domain.Evaluation, 0, len(ids))
+	for _, id := range ids {
+		e, ok := m[id]
+		if !ok || e.Status != status {
+			continue
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// invalidateDetail 写操作之后删掉课评实体缓存，同时广播给其它实例
+func (repo *evaluationRepository) invalidateDetail(ctx context.Context, evaluationId int64) {
+	err := repo.detailCache.Delete(ctx, evaluationId)
+	if err != nil {
+		repo.l.Error("删除课评缓存失败", logger.Error(err), logger.Int64("evaluationId", evaluationId))
+	}
+}
+
+// invalidateForGlobalTx 在seata全局事务里面，本地事务提交之后全局事务还可能回滚，不能按增量更新缓存。
+// 先删掉受影响的缓存，等全局事务一定有结果之后再删一次，把回滚之前被读回去的数据也清掉，时间线按数据库的最终结果修正
+func (repo *evaluationRepository) invalidateForGlobalTx(ctx context.Context, evaluationId int64, uid int64, courseId int64,
+	property coursev1.CourseProperty) {
+	repo.invalidateAffected(ctx, evaluationId, uid, courseId)
+	time.AfterFunc(dao.GlobalTxTimeout, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		repo.invalidateAffected(ctx, evaluationId, uid, courseId)
+		repo.reconcileRecent(ctx, evaluationId, property)
+	})
+}
+
+func (repo *evaluationRepository) invalidateAffected(ctx context.Context, evaluationId int64, uid int64, courseId int64) {
+	repo.invalidateDetail(ctx, evaluationId)
+	if err := repo.countCache.Delete(ctx, uid, courseId); err != nil {
+		repo.l.Error("删除课评计数缓存失败", logger.Error(err), logger.Int64("uid", uid), logger.Int64("courseId", courseId))
+	}
+	if err := repo.cache.DeleteCompositeScore(ctx, courseId); err != nil {
+		repo.l.Error("删除综合得分缓存失败", logger.Error(err), logger.Int64("courseId", courseId))
+	}
+}
+
+// reconcileRecent 按数据库里面的最终状态修正时间线
+func (repo *evaluationRepository) reconcileRecent(ctx context.Context, evaluationId int64, property coursev1.CourseProperty) {
+	e, err := repo.dao.GetDetailById(dao.WithPrimary(ctx), evaluationId)
+	switch {
+	case err == nil && e.Status == dao.EvaluationStatusPublic:
+		err = repo.recentCache.AddRecentIfPresent(ctx, coursev1.CourseProperty(e.CourseProperty), evaluationId, e.Utime)
+	case err == nil, err == dao.ErrorRecordNotFind:
+		err = repo.recentCache.DeleteRecent(ctx, property, evaluationId)
+	}
+	if err != nil {
+		repo.l.Error("修正最近课评时间线失败", logger.Error(err), logger.Int64("evaluationId", evaluationId))
+	}
+}
+
+// rebuildRecent 异步从数据库重建时间线，同一条时间线同时只有一个重建
+func (repo *evaluationRepository) rebuildRecent(property coursev1.CourseProperty) {
+	go func() {
+		_, _, _ = repo.g.Do(fmt.Sprintf("recent:%d", property), func() (interface{}, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			timeline, err := repo.dao.GetListRecentTimeline(fillContext(ctx), cache.RecentCapacity, int32(property))
+			if err != nil {
+				repo.l.Error("查询最近课评时间线失败", logger.Error(err), logger.Int32("property", int32(property)))
+				return nil, err
+			}
+			err = repo.recentCache.SetRecent(ctx, property, slice.Map(timeline, func(idx int, src dao.Evaluation) cache.RecentItem {
+				return cache.RecentItem{EvaluationId: src.Id, Utime: src.Utime}
+			}))
+			if err != nil {
+				repo.l.Error("重建最近课评时间线失败", logger.Error(err), logger.Int32("property", int32(property)))
+			}
+			return nil, err
+		})
+	}()
+}
+
+// updateRecent 根据课评的状态变迁维护最近课评时间线，时间线只是派生数据，失败了记录日志等过期重建
+// utime 用数据库里面的，和重建出来的时间线排序一致
+func (repo *evaluationRepository) updateRecent(ctx context.Context, evaluationId int64, property coursev1.CourseProperty,
+	oldStatus int32, newStatus evaluationv1.EvaluationStatus, utime int64) {
+	var err error
+	switch {
+	case newStatus == evaluationv1.EvaluationStatus_Public:
+		// 发布和编辑都会刷新utime，要挪到时间线的头部
+		err = repo.recentCache.AddRecentIfPresent(ctx, property, evaluationId, utime)
+	case oldStatus == dao.EvaluationStatusPublic:
+		err = repo.recentCache.DeleteRecent(ctx, property, evaluationId)
+	default:
+		return
+	}
+	if err != nil {
+		repo.l.Error("更新最近课评时间线失败", logger.Error(err), logger.Int64("evaluationId", evaluationId))
+	}
+}
+
 func (repo *evaluationRepository) Update(ctx context.Context, evaluation domain.Evaluation) error {
 	oe, err := repo.dao.UpdateById(ctx, repo.toEntity(evaluation))
+	var ce *dao.VersionConflictError
+	if errors.As(err, &ce) {
+		return &VersionConflictError{Current: repo.toDomain(ce.Current)}
+	}
 	if err != nil {
 		return err
 	}
+	repo.markWritten(ctx, evaluation.PublisherId)
+	if tm.IsGlobalTx(ctx) {
+		repo.invalidateForGlobalTx(ctx, evaluation.Id, evaluation.PublisherId, oe.CourseId, coursev1.CourseProperty(oe.CourseProperty))
+		return nil
+	}
+	repo.invalidateDetail(ctx, evaluation.Id)
+	repo.updateRecent(ctx, evaluation.Id, coursev1.CourseProperty(oe.CourseProperty), oe.Status, evaluation.Status, oe.Utime)
+	repo.syncCounts(ctx, evaluation.PublisherId, oe.CourseId, repo.countCache.ChangeStatusIfPresent(ctx,
+		evaluation.PublisherId, oe.CourseId, evaluationv1.EvaluationStatus(oe.Status), evaluation.Status))
 	switch {
 	case (oe.Status == dao.EvaluationStatusPrivate || oe.Status == dao.EvaluationStatusFolded) && evaluation.Status == evaluationv1.EvaluationStatus_Private:
 		return nil
@@ -128,19 +466,32 @@ func (repo *evaluationRepository) Update(ctx context.Context, evaluation domain.
 	case oe.Status == dao.EvaluationStatusPublic && evaluation.Status == evaluationv1.EvaluationStatus_Private:
 		return repo.cache.DeleteRatingIfCompositeScorePresent(ctx, oe.CourseId, oe.StarRating)
 	default:
-		return errors.New("不合法的课评状态")
+		return ErrIllegalStatusTransition
 	}
 }
 
 func (repo *evaluationRepository) Create(ctx context.Context, evaluation domain.Evaluation) (int64, error) {
-	evaluationId, err := repo.dao.Insert(ctx, repo.toEntity(evaluation))
+	entity := repo.toEntity(evaluation)
+	entity.Utime = time.Now().UnixMilli()
+	evaluationId, err := repo.dao.Insert(ctx, entity)
 	if err != nil {
 		return 0, err
 	}
+	repo.markWritten(ctx, evaluation.PublisherId)
+	repo.addToBloom(ctx, evaluationId, evaluation.CourseId)
+	if tm.IsGlobalTx(ctx) {
+		repo.invalidateForGlobalTx(ctx, evaluationId, evaluation.PublisherId, evaluation.CourseId, evaluation.CourseProperty)
+		return evaluationId, nil
+	}
+	// 可能有人提前查过这个id，留下了空缓存
+	repo.invalidateDetail(ctx, evaluationId)
+	repo.syncCounts(ctx, evaluation.PublisherId, evaluation.CourseId, repo.countCache.AddIfPresent(ctx,
+		evaluation.PublisherId, evaluation.CourseId, evaluation.Status))
 	if evaluation.Status == evaluationv1.EvaluationStatus_Private {
 		return evaluationId, nil
 	}
 	// public
+	repo.updateRecent(ctx, evaluationId, evaluation.CourseProperty, dao.EvaluationStatusPrivate, evaluation.Status, entity.Utime)
 	err = repo.cache.AddRatingIfCompositeScorePresent(ctx, evaluation.CourseId, evaluation.StarRating)
 	if err != nil {
 		return 0, err
@@ -153,6 +504,15 @@ func (repo *evaluationRepository) UpdateStatus(ctx context.Context, evaluationId
 	if err != nil {
 		return err
 	}
+	repo.markWritten(ctx, uid)
+	if tm.IsGlobalTx(ctx) {
+		repo.invalidateForGlobalTx(ctx, evaluationId, uid, oe.CourseId, coursev1.CourseProperty(oe.CourseProperty))
+		return nil
+	}
+	repo.invalidateDetail(ctx, evaluationId)
+	repo.updateRecent(ctx, evaluationId, coursev1.CourseProperty(oe.CourseProperty), oe.Status, status, oe.Utime)
+	repo.syncCounts(ctx, uid, oe.CourseId, repo.countCache.ChangeStatusIfPresent(ctx,
+		uid, oe.CourseId, evaluationv1.EvaluationStatus(oe.Status), status))
 	switch {
 	case oe.Status == dao.EvaluationStatusPrivate && status == evaluationv1.EvaluationStatus_Public:
 		return repo.cache.AddRatingIfCompositeScorePresent(ctx, oe.CourseId, oe.StarRating)
@@ -163,8 +523,241 @@ func (repo *evaluationRepository) UpdateStatus(ctx context.Context, evaluationId
 	}
 }
 
+func (repo *evaluationRepository) SyncCourseProperty(ctx context.Context, courseId int64, property coursev1.CourseProperty) error {
+	// 分批修改，避免一门热门课程一次锁住太多行
+	const batchSize = 500
+	for {
+		evaluations, err := repo.dao.UpdateCourseProperty(ctx, courseId, int32(property), batchSize)
+		if err != nil {
+			return err
+		}
+		if len(evaluations) == 0 {
+			return nil
+		}
+		ids := make([]int64, 0, len(evaluations))
+		// 公开课评所在的旧时间线和新时间线都要作废
+		properties := map[coursev1.CourseProperty]struct{}{}
+		for _, e := range evaluations {
+			ids = append(ids, e.Id)
+			if e.Status == dao.EvaluationStatusPublic {
+				properties[coursev1.CourseProperty(e.CourseProperty)] = struct{}{}
+				properties[property] = struct{}{}
+			}
+		}
+		err = repo.detailCache.Delete(ctx, ids...)
+		if err != nil {
+			repo.l.Error("删除课评缓存失败", logger.Error(err), logger.Int64("courseId", courseId))
+		}
+		if len(properties) > 0 {
+			err = repo.recentCache.ClearRecent(ctx, mapx.Keys(properties)...)
+			if err != nil {
+				repo.l.Error("删除最近课评时间线失败", logger.Error(err), logger.Int64("courseId", courseId))
+			}
+		}
+		if len(evaluations) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (repo *evaluationRepository) GetCourseIdsAfter(ctx context.Context, startCourseId int64, limit int) ([]int64, error) {
+	return repo.dao.GetCourseIdsAfter(ctx, startCourseId, limit)
+}
+
+func (repo *evaluationRepository) MergeCourse(ctx context.Context, sourceCourseId int64, targetCourseId int64) error {
+	task, err := repo.dao.FindOrCreateCourseMergeTask(ctx, sourceCourseId, targetCourseId)
+	if err != nil {
+		return err
+	}
+	if err = repo.courseBloom.Add(ctx, targetCourseId); err != nil {
+		repo.l.Error("课程加入布隆过滤器失败", logger.Error(err), logger.Int64("courseId", targetCourseId))
+	}
+	const batchSize = 200
+	for {
+		batch, err := repo.dao.MergeCourseBatch(ctx, task.Id, batchSize)
+		if err != nil {
+			return err
+		}
+		// 每一批都已经提交了，缓存跟着一批一批地删
+		repo.invalidateMerged(ctx, sourceCourseId, targetCourseId, batch)
+		if batch.Done {
+			return nil
+		}
+	}
+}
+
+func (repo *evaluationRepository) invalidateMerged(ctx context.Context, sourceCourseId int64, targetCourseId int64,
+	batch dao.CourseMergeBatch) {
+	err := repo.cache.DeleteCompositeScore(ctx, sourceCourseId, targetCourseId)
+	if err != nil {
+		repo.l.Error("删除课程综合得分缓存失败", logger.Error(err),
+			logger.Int64("sourceCourseId", sourceCourseId), logger.Int64("targetCourseId", targetCourseId))
+	}
+	evaluations := make([]dao.Evaluation, 0, len(batch.Moved)+len(batch.Dropped))
+	evaluations = append(append(evaluations, batch.Moved...), batch.Dropped...)
+	if len(evaluations) == 0 {
+		return
+	}
+	ids := make([]int64, 0, len(evaluations))
+	for _, e := range evaluations {
+		ids = append(ids, e.Id)
+		// 用户的计数和两门课的不可见计数都变了，删掉等下次读的时候重建
+		for _, courseId := range []int64{sourceCourseId, targetCourseId} {
+			if err = repo.countCache.Delete(ctx, e.PublisherId, courseId); err != nil {
+				repo.l.Error("删除课评计数缓存失败", logger.Error(err),
+					logger.Int64("uid", e.PublisherId), logger.Int64("courseId", courseId))
+			}
+		}
+	}
+	err = repo.detailCache.Delete(ctx, ids...)
+	if err != nil {
+		repo.l.Error("删除课评缓存失败", logger.Error(err), logger.Int64("sourceCourseId", sourceCourseId))
+	}
+	// 挪动的课评id和utime都没变，还在原来的时间线里面，只需要移除被删掉的
+	for _, e := range batch.Dropped {
+		if e.Status == dao.EvaluationStatusPublic {
+			repo.updateRecent(ctx, e.Id, coursev1.CourseProperty(e.CourseProperty), e.Status, evaluationv1.EvaluationStatus_Private, e.Utime)
+		}
+	}
+}
+
+func (repo *evaluationRepository) Import(ctx context.Context, evaluation domain.Evaluation) (int64, error) {
+	entity := repo.toEntity(evaluation)
+	entity.Ctime = evaluation.Ctime.UnixMilli()
+	entity.Utime = evaluation.Utime.UnixMilli()
+	evaluationId, err := repo.dao.InsertWithTime(ctx, entity)
+	if err != nil {
+		return 0, err
+	}
+	repo.invalidateDetail(ctx, evaluationId)
+	repo.addToBloom(ctx, evaluationId, evaluation.CourseId)
+	if err = repo.countCache.Delete(ctx, evaluation.PublisherId, evaluation.CourseId); err != nil {
+		repo.l.Error("删除课评计数缓存失败", logger.Error(err),
+			logger.Int64("uid", evaluation.PublisherId), logger.Int64("courseId", evaluation.CourseId))
+	}
+	if evaluation.Status != evaluationv1.EvaluationStatus_Public {
+		return evaluationId, nil
+	}
+	// 按原来的utime插进时间线，太旧的会被裁掉
+	err = repo.recentCache.AddRecentIfPresent(ctx, evaluation.CourseProperty, evaluationId, entity.Utime)
+	if err != nil {
+		repo.l.Error("更新最近课评时间线失败", logger.Error(err), logger.Int64("evaluationId", evaluationId))
+	}
+	// 课评已经写进去了，缓存出错不能算导入失败，删掉综合得分让它回源
+	if err = repo.cache.AddRatingIfCompositeScorePresent(ctx, evaluation.CourseId, evaluation.StarRating); err != nil {
+		repo.l.Error("更新综合得分缓存失败", logger.Error(err), logger.Int64("courseId", evaluation.CourseId))
+		if err = repo.cache.DeleteCompositeScore(ctx, evaluation.CourseId); err != nil {
+			repo.l.Error("删除综合得分缓存失败", logger.Error(err), logger.Int64("courseId", evaluation.CourseId))
+		}
+	}
+	return evaluationId, nil
+}
+
+func (repo *evaluationRepository) GetListForExport(ctx context.Context, filter domain.ExportFilter, afterId int64,
+	limit int) ([]domain.Evaluation, error) {
+	var startTime, endTime int64
+	if !filter.StartTime.IsZero() {
+		startTime = filter.StartTime.UnixMilli()
+	}
+	if !filter.EndTime.IsZero() {
+		endTime = filter.EndTime.UnixMilli()
+	}
+	evaluations, err := repo.dao.GetListForExport(ctx, dao.ExportFilter{
+		CourseId:       filter.CourseId,
+		CourseProperty: int32(filter.CourseProperty),
+		Status:         dao.EvaluationStatusPublic,
+		StartTime:      startTime,
+		EndTime:        endTime,
+	}, afterId, limit)
+	return slice.Map(evaluations, func(idx int, src dao.Evaluation) domain.Evaluation {
+		return repo.toDomain(src)
+	}), err
+}
+
+func (repo *evaluationRepository) RebuildBloomFilters(ctx context.Context) error {
+	err := repo.rebuildBloom(ctx, repo.evaluationBloom, "evaluation", repo.dao.GetIdsAfter)
+	if err != nil {
+		return err
+	}
+	return repo.rebuildBloom(ctx, repo.courseBloom, "course", repo.dao.GetCourseIdsAfter)
+}
+
+func (repo *evaluationRepository) BuildBloomFiltersIfAbsent(ctx context.Context) error {
+	for _, f := range []struct {
+		filter cache.BloomFilter
+		name   string
+		scan   func(ctx context.Context, startId int64, limit int) ([]int64, error)
+	}{
+		{filter: repo.evaluationBloom, name: "evaluation", scan: repo.dao.GetIdsAfter},
+		{filter: repo.courseBloom, name: "course", scan: repo.dao.GetCourseIdsAfter},
+	} {
+		ok, err := f.filter.Built(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		if err = repo.rebuildBloom(ctx, f.filter, f.name, f.scan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *evaluationRepository) rebuildBloom(ctx context.Context, filter cache.BloomFilter, name string,
+	scan func(ctx context.Context, startId int64, limit int) ([]int64, error)) error {
+	token, ok, err := filter.TryStartRebuild(ctx)
+	if err != nil || !ok {
+		// 别的实例正在重建
+		return err
+	}
+	const batchSize = 1000
+	bitmap := filter.NewBitmap()
+	var startId, total int64
+	for {
+		ids, err := scan(ctx, startId, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			bitmap.Add(id)
+		}
+		total += int64(len(ids))
+		if len(ids) < batchSize {
+			break
+		}
+		startId = ids[len(ids)-1]
+	}
+	err = filter.FinishRebuild(ctx, token, bitmap)
+	if err != nil {
+		return err
+	}
+	repo.l.Info("重建布隆过滤器完成", logger.String("name", name), logger.Int64("total", total))
+	return nil
+}
+
+// mightContain 布隆过滤器出错的时候放行，宁可多查也不能把存在的判成不存在
+func (repo *evaluationRepository) mightContain(ctx context.Context, filter cache.BloomFilter, id int64) bool {
+	ok, err := filter.MightContain(ctx, id)
+	if err != nil {
+		repo.l.Error("查询布隆过滤器失败", logger.Error(err), logger.Int64("id", id))
+		return true
+	}
+	return ok
+}
+
+func (repo *evaluationRepository) addToBloom(ctx context.Context, evaluationId int64, courseId int64) {
+	if err := repo.evaluationBloom.Add(ctx, evaluationId); err != nil {
+		repo.l.Error("课评加入布隆过滤器失败", logger.Error(err), logger.Int64("evaluationId", evaluationId))
+	}
+	if err := repo.courseBloom.Add(ctx, courseId); err != nil {
+		repo.l.Error("课程加入布隆过滤器失败", logger.Error(err), logger.Int64("courseId", courseId))
+	}
+}
+
 func (repo *evaluationRepository) Evaluated(ctx context.Context, publisherId int64, courseId int64) (bool, error) {
-	_, err := repo.dao.FindEvaluation(ctx, publisherId, courseId)
+	_, err := repo.dao.FindEvaluation(repo.ownReadContext(ctx, publisherId), publisherId, courseId)
 	switch {
 	case err == nil:
 		return true, nil
@@ -185,6 +778,7 @@ func (repo *evaluationRepository) toEntity(e domain.Evaluation) dao.Evaluation {
 		Content:        e.Content,
 		Status:         int32(e.Status),
 		IsAnonymous:    e.IsAnonymous,
+		Version:        e.Version,
 	}
 }
 
@@ -198,6 +792,7 @@ func (repo *evaluationRepository) toDomain(e dao.Evaluation) domain.Evaluation {
 		Content:        e.Content,
 		Status:         evaluationv1.EvaluationStatus(e.Status),
 		IsAnonymous:    e.IsAnonymous,
+		Version:        e.Version,
 		Utime:          time.UnixMilli(e.Utime),
 		Ctime:          time.UnixMilli(e.Ctime),
 	}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/MuxiKeStack/be-evaluation/events"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+)
+
+type EvaluationEventRepository interface {
+	// ProducePending 把发件箱里面待投递的事件发出去，返回这次投递成功的条数
+	ProducePending(ctx context.Context, limit int) (int, error)
+}
+
+type evaluationEventRepository struct {
+	dao      dao.EvaluationEventDAO
+	producer events.Producer
+}
+
+func NewEvaluationEventRepository(dao dao.EvaluationEventDAO, producer events.Producer) EvaluationEventRepository {
+	return &evaluationEventRepository{dao: dao, producer: producer}
+}
+
+func (repo *evaluationEventRepository) ProducePending(ctx context.Context, limit int) (int, error) {
+	return repo.dao.ConsumePending(ctx, limit, func(evt dao.EvaluationEvent) error {
+		switch evt.Type {
+		case dao.EvaluationEventTypeCreated:
+			return repo.producer.ProduceEvaluationCreatedEvent(ctx, events.EvaluationCreatedEvent{
+				EventId:      evt.Id,
+				EvaluationId: evt.EvaluationId,
+				PublisherId:  evt.PublisherId,
+				CourseId:     evt.CourseId,
+				StarRating:   evt.NewRating,
+				Status:       evt.NewStatus,
+				Ctime:        evt.Ctime,
+			})
+		case dao.EvaluationEventTypeUpdated:
+			return repo.producer.ProduceEvaluationUpdatedEvent(ctx, events.EvaluationUpdatedEvent{
+				EventId:      evt.Id,
+				EvaluationId: evt.EvaluationId,
+				PublisherId:  evt.PublisherId,
+				CourseId:     evt.CourseId,
+				OldRating:    evt.OldRating,
+				NewRating:    evt.NewRating,
+				Status:       evt.NewStatus,
+				Ctime:        evt.Ctime,
+			})
+		case dao.EvaluationEventTypeStatusChanged:
+			return repo.producer.ProduceEvaluationStatusChangedEvent(ctx, events.EvaluationStatusChangedEvent{
+				EventId:      evt.Id,
+				EvaluationId: evt.EvaluationId,
+				PublisherId:  evt.PublisherId,
+				CourseId:     evt.CourseId,
+				OldStatus:    evt.OldStatus,
+				NewStatus:    evt.NewStatus,
+				StarRating:   evt.NewRating,
+				Ctime:        evt.Ctime,
+			})
+		case dao.EvaluationEventTypeMoved, dao.EvaluationEventTypeDropped:
+			return repo.producer.ProduceEvaluationCourseMergedEvent(ctx, events.EvaluationCourseMergedEvent{
+				EventId:        evt.Id,
+				EvaluationId:   evt.EvaluationId,
+				PublisherId:    evt.PublisherId,
+				SourceCourseId: evt.OldCourseId,
+				TargetCourseId: evt.CourseId,
+				Dropped:        evt.Type == dao.EvaluationEventTypeDropped,
+				StarRating:     evt.NewRating,
+				Status:         evt.NewStatus,
+				Ctime:          evt.Ctime,
+			})
+		default:
+			// 未知的事件类型不删除，留着人工处理
+			return fmt.Errorf("未知的课评事件类型 %s, id %d", evt.Type, evt.Id)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/events"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"testing"
+)
+
+// sliceEventDAO 把给定的事件依次交给fn
+type sliceEventDAO struct {
+	evts []dao.EvaluationEvent
+}
+
+func (d *sliceEventDAO) ConsumePending(ctx context.Context, limit int, fn func(evt dao.EvaluationEvent) error) (int, error) {
+	for i, evt := range d.evts {
+		if err := fn(evt); err != nil {
+			return i, err
+		}
+	}
+	return len(d.evts), nil
+}
+
+func TestEvaluationEventRepository_ProduceCourseMerged(t *testing.T) {
+	producer := events.NewMemoryProducer()
+	repo := NewEvaluationEventRepository(&sliceEventDAO{evts: []dao.EvaluationEvent{
+		{Id: 1, Type: dao.EvaluationEventTypeMoved, EvaluationId: 11, PublisherId: 1, OldCourseId: 10, CourseId: 20,
+			NewRating: 5, NewStatus: dao.EvaluationStatusPublic},
+		{Id: 2, Type: dao.EvaluationEventTypeDropped, EvaluationId: 12, PublisherId: 2, OldCourseId: 20, CourseId: 20,
+			NewRating: 1, NewStatus: dao.EvaluationStatusPublic},
+	}}, producer)
+	n, err := repo.ProducePending(context.Background(), 10)
+	if err != nil || n != 2 {
+		t.Fatalf("投递了 %d 条, %v", n, err)
+	}
+	got := producer.CourseMergedEvents()
+	want := []events.EvaluationCourseMergedEvent{
+		{EventId: 1, EvaluationId: 11, PublisherId: 1, SourceCourseId: 10, TargetCourseId: 20,
+			StarRating: 5, Status: dao.EvaluationStatusPublic},
+		{EventId: 2, EvaluationId: 12, PublisherId: 2, SourceCourseId: 20, TargetCourseId: 20, Dropped: true,
+			StarRating: 1, Status: dao.EvaluationStatusPublic},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("发出了 %d 个事件，想要 %d 个", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("第 %d 个事件 %+v，想要 %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"testing"
+)
+
+// laggingDAO 模拟从库还没追上：不走主库读到的都是写之前的旧数据
+type laggingDAO struct {
+	dao.EvaluationDAO
+}
+
+func (d *laggingDAO) rating(ctx context.Context) uint8 {
+	if dao.IsUsePrimary(ctx) {
+		return 5
+	}
+	return 1
+}
+
+func (d *laggingDAO) GetDetailById(ctx context.Context, evaluationId int64) (dao.Evaluation, error) {
+	return dao.Evaluation{Id: evaluationId, StarRating: d.rating(ctx), Version: int64(d.rating(ctx))}, nil
+}
+
+func (d *laggingDAO) GetListByIds(ctx context.Context, ids []int64) ([]dao.Evaluation, error) {
+	res := make([]dao.Evaluation, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, dao.Evaluation{Id: id, StarRating: d.rating(ctx)})
+	}
+	return res, nil
+}
+
+func (d *laggingDAO) GetCompositeScoreByCourseId(ctx context.Context, courseId int64) (dao.CompositeScore, error) {
+	return dao.CompositeScore{CourseId: courseId, Score: float64(d.rating(ctx)), RaterCnt: 1}, nil
+}
+
+func (d *laggingDAO) GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
+	return int64(d.rating(ctx)), nil
+}
+
+// emptyCaches 所有缓存都没有命中，记下回写的值
+type emptyCaches struct {
+	cache.EvaluationCache
+	cache.EvaluationDetailCache
+	cache.BloomFilter
+	detail map[int64]domain.Evaluation
+	score  domain.CompositeScore
+}
+
+func (c *emptyCaches) MightContain(ctx context.Context, id int64) (bool, error) {
+	return true, nil
+}
+
+func (c *emptyCaches) Get(ctx context.Context, evaluationId int64) (domain.Evaluation, error) {
+	return domain.Evaluation{}, cache.ErrKeyNotExists
+}
+
+func (c *emptyCaches) MGet(ctx context.Context, evaluationIds []int64) (map[int64]domain.Evaluation, error) {
+	return nil, nil
+}
+
+func (c *emptyCaches) Set(ctx context.Context, evaluation domain.Evaluation) error {
+	c.detail[evaluation.Id] = evaluation
+	return nil
+}
+
+func (c *emptyCaches) GetCompositeScore(ctx context.Context, courseId int64) (domain.CompositeScore, error) {
+	return domain.CompositeScore{}, cache.ErrKeyNotExists
+}
+
+func (c *emptyCaches) SetCompositeScore(ctx context.Context, courseId int64, res domain.CompositeScore) error {
+	c.score = res
+	return nil
+}
+
+type emptyCountCache struct {
+	cache.EvaluationCountCache
+	invisible int64
+}
+
+func (c *emptyCountCache) GetCountCourseInvisible(ctx context.Context, courseId int64) (int64, error) {
+	return 0, cache.ErrKeyNotExists
+}
+
+func (c *emptyCountCache) SetCountCourseInvisible(ctx context.Context, courseId int64, count int64) error {
+	c.invisible = count
+	return nil
+}
+
+func TestEvaluationRepository_FillFromPrimary(t *testing.T) {
+	ctx := context.Background()
+	c := &emptyCaches{detail: map[int64]domain.Evaluation{}}
+	cc := &emptyCountCache{}
+	repo := NewEvaluationRepository(&laggingDAO{}, c, nil, c, cc, c, c, nil, logger.NewNopLogger())
+
+	if _, err := repo.GetDetailById(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if e := c.detail[1]; e.StarRating != 5 || e.Version != 5 {
+		t.Fatalf("课评详情缓存回填了从库的旧数据 %+v", e)
+	}
+	if _, err := repo.GetCompositeScoreByCourseId(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if c.score.Score != 5 {
+		t.Fatalf("综合得分缓存回填了从库的旧数据 %+v", c.score)
+	}
+	if _, err := repo.GetCountCourseInvisible(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if cc.invisible != 5 {
+		t.Fatalf("不可见计数缓存回填了从库的旧数据 %d", cc.invisible)
+	}
+}
+
+func TestEvaluationRepository_FillListFromPrimary(t *testing.T) {
+	c := &emptyCaches{detail: map[int64]domain.Evaluation{}}
+	repo := &evaluationRepository{dao: &laggingDAO{}, detailCache: c, l: logger.NewNopLogger()}
+	if _, err := repo.getListByIds(context.Background(), []int64{1, 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{1, 2} {
+		if e := c.detail[id]; e.StarRating != 5 {
+			t.Fatalf("列表回填课评详情缓存读了从库 %+v", e)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"testing"
+	"time"
+)
+
+var errRedisDown = errors.New("redis 挂了")
+
+type importDAO struct {
+	dao.EvaluationDAO
+}
+
+func (d *importDAO) InsertWithTime(ctx context.Context, evaluation dao.Evaluation) (int64, error) {
+	return 1, nil
+}
+
+// brokenCaches 所有缓存操作都失败
+type brokenCaches struct {
+	cache.EvaluationCache
+	cache.EvaluationDetailCache
+	cache.RecentEvaluationCache
+	cache.BloomFilter
+	deletedScore bool
+}
+
+func (c *brokenCaches) AddRatingIfCompositeScorePresent(ctx context.Context, courseId int64, starRating uint8) error {
+	return errRedisDown
+}
+
+func (c *brokenCaches) DeleteCompositeScore(ctx context.Context, courseIds ...int64) error {
+	c.deletedScore = true
+	return nil
+}
+
+func (c *brokenCaches) Delete(ctx context.Context, evaluationIds ...int64) error {
+	return errRedisDown
+}
+
+func (c *brokenCaches) AddRecentIfPresent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64, utime int64) error {
+	return errRedisDown
+}
+
+func (c *brokenCaches) Add(ctx context.Context, ids ...int64) error {
+	return errRedisDown
+}
+
+type brokenCountCache struct {
+	cache.EvaluationCountCache
+}
+
+func (c brokenCountCache) Delete(ctx context.Context, uid int64, courseId int64) error {
+	return errRedisDown
+}
+
+func TestEvaluationRepository_ImportCacheError(t *testing.T) {
+	c := &brokenCaches{}
+	repo := &evaluationRepository{
+		dao:             &importDAO{},
+		cache:           c,
+		detailCache:     c,
+		recentCache:     c,
+		countCache:      brokenCountCache{},
+		courseBloom:     c,
+		evaluationBloom: c,
+		l:               logger.NewNopLogger(),
+	}
+	id, err := repo.Import(context.Background(), domain.Evaluation{
+		PublisherId: 1,
+		CourseId:    2,
+		StarRating:  5,
+		Status:      evaluationv1.EvaluationStatus_Public,
+		Ctime:       time.UnixMilli(1000),
+		Utime:       time.UnixMilli(1000),
+	})
+	// 数据库已经写进去了，缓存出错不能让调用方以为导入失败
+	if err != nil || id != 1 {
+		t.Fatalf("返回 %d, %v，应该是 1, nil", id, err)
+	}
+	if !c.deletedScore {
+		t.Fatal("综合得分没有更新成功的时候要删掉，让它回源")
+	}
+}
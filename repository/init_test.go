@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	glogger "gorm.io/gorm/logger"
+	"testing"
+)
+
+// newTestDAO 每个测试一个内存里的sqlite
+func newTestDAO(t *testing.T) dao.EvaluationDAO {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		TranslateError: true,
+		Logger:         glogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存库每个连接是独立的
+	sqlDB.SetMaxOpenConns(1)
+	if err = dao.InitTables(db); err != nil {
+		t.Fatal(err)
+	}
+	idGen, err := idgen.NewSnowflake(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dao.NewGORMEvaluationDAO(db, idGen)
+}
+
+// nopCaches 时间线、计数、布隆过滤器和读主库标记都当作不存在，写操作什么也不做
+type nopCaches struct {
+	cache.RecentEvaluationCache
+	cache.EvaluationCountCache
+	cache.BloomFilter
+	cache.PrimaryStickyCache
+}
+
+func (nopCaches) AddRecentIfPresent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64, utime int64) error {
+	return nil
+}
+
+func (nopCaches) DeleteRecent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64) error {
+	return nil
+}
+
+func (nopCaches) AddIfPresent(ctx context.Context, uid int64, courseId int64, status evaluationv1.EvaluationStatus) error {
+	return nil
+}
+
+func (nopCaches) ChangeStatusIfPresent(ctx context.Context, uid int64, courseId int64, oldStatus, newStatus evaluationv1.EvaluationStatus) error {
+	return nil
+}
+
+func (nopCaches) MightContain(ctx context.Context, id int64) (bool, error) {
+	return true, nil
+}
+
+func (nopCaches) Add(ctx context.Context, ids ...int64) error {
+	return nil
+}
+
+func (nopCaches) MarkWritten(ctx context.Context, uid int64) error {
+	return nil
+}
+
+func (nopCaches) RecentlyWritten(ctx context.Context, uid int64) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"github.com/ecodeclub/ekit/slice"
+	"time"
+)
+
+type PendingEvaluationRepository interface {
+	// Add 收下一条待校验的课评，返回课评id
+	Add(ctx context.Context, p domain.PendingEvaluation) (int64, error)
+	FindDue(ctx context.Context, limit int) ([]domain.PendingEvaluation, error)
+	// Claim 占住一条待校验课评，下次校验推迟到 nextTime，别的实例已经占住了返回false
+	Claim(ctx context.Context, p domain.PendingEvaluation, nextTime time.Time) (bool, error)
+	Delete(ctx context.Context, id int64) error
+	// ExistsCreate 用户对这门课有没有还在校验的新课评
+	ExistsCreate(ctx context.Context, publisherId int64, courseId int64) (bool, error)
+	// Bury 重试次数用完了，转成死信
+	Bury(ctx context.Context, id int64, reason string) error
+	FindDead(ctx context.Context, afterId int64, limit int) ([]domain.PendingEvaluation, error)
+	// Requeue 把死信放回去重新校验，返回放回去的条数
+	Requeue(ctx context.Context, ids []int64) (int64, error)
+}
+
+type pendingEvaluationRepository struct {
+	dao dao.PendingEvaluationDAO
+}
+
+func NewPendingEvaluationRepository(dao dao.PendingEvaluationDAO) PendingEvaluationRepository {
+	return &pendingEvaluationRepository{dao: dao}
+}
+
+func (repo *pendingEvaluationRepository) Add(ctx context.Context, p domain.PendingEvaluation) (int64, error) {
+	return repo.dao.Insert(ctx, repo.toEntity(p))
+}
+
+func (repo *pendingEvaluationRepository) FindDue(ctx context.Context, limit int) ([]domain.PendingEvaluation, error) {
+	ps, err := repo.dao.FindDue(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	return slice.Map(ps, func(idx int, src dao.PendingEvaluation) domain.PendingEvaluation {
+		return repo.toDomain(src)
+	}), nil
+}
+
+func (repo *pendingEvaluationRepository) Claim(ctx context.Context, p domain.PendingEvaluation, nextTime time.Time) (bool, error) {
+	return repo.dao.Claim(ctx, repo.toEntity(p), nextTime)
+}
+
+func (repo *pendingEvaluationRepository) Delete(ctx context.Context, id int64) error {
+	return repo.dao.Delete(ctx, id)
+}
+
+func (repo *pendingEvaluationRepository) ExistsCreate(ctx context.Context, publisherId int64, courseId int64) (bool, error) {
+	return repo.dao.ExistsCreate(ctx, publisherId, courseId)
+}
+
+func (repo *pendingEvaluationRepository) Bury(ctx context.Context, id int64, reason string) error {
+	return repo.dao.Bury(ctx, id, reason)
+}
+
+func (repo *pendingEvaluationRepository) FindDead(ctx context.Context, afterId int64, limit int) ([]domain.PendingEvaluation, error) {
+	ps, err := repo.dao.FindDead(ctx, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	return slice.Map(ps, func(idx int, src dao.PendingEvaluation) domain.PendingEvaluation {
+		return repo.toDomain(src)
+	}), nil
+}
+
+func (repo *pendingEvaluationRepository) Requeue(ctx context.Context, ids []int64) (int64, error) {
+	return repo.dao.Requeue(ctx, ids)
+}
+
+func (repo *pendingEvaluationRepository) toEntity(p domain.PendingEvaluation) dao.PendingEvaluation {
+	var nextTime int64
+	if !p.NextTime.IsZero() {
+		nextTime = p.NextTime.UnixMilli()
+	}
+	return dao.PendingEvaluation{
+		Id:           p.Id,
+		EvaluationId: p.Evaluation.Id,
+		IsUpdate:     p.IsUpdate,
+		PublisherId:  p.Evaluation.PublisherId,
+		CourseId:     p.Evaluation.CourseId,
+		StarRating:   p.Evaluation.StarRating,
+		Content:      p.Evaluation.Content,
+		Status:       int32(p.Evaluation.Status),
+		IsAnonymous:  p.Evaluation.IsAnonymous,
+		Version:      p.Evaluation.Version,
+		Attempts:     p.Attempts,
+		NextTime:     nextTime,
+	}
+}
+
+func (repo *pendingEvaluationRepository) toDomain(p dao.PendingEvaluation) domain.PendingEvaluation {
+	var deadTime time.Time
+	if p.DeadTime > 0 {
+		deadTime = time.UnixMilli(p.DeadTime)
+	}
+	return domain.PendingEvaluation{
+		Id: p.Id,
+		Evaluation: domain.Evaluation{
+			Id:          p.EvaluationId,
+			PublisherId: p.PublisherId,
+			CourseId:    p.CourseId,
+			StarRating:  p.StarRating,
+			Content:     p.Content,
+			Status:      evaluationv1.EvaluationStatus(p.Status),
+			IsAnonymous: p.IsAnonymous,
+			Version:     p.Version,
+		},
+		IsUpdate:  p.IsUpdate,
+		Attempts:  p.Attempts,
+		NextTime:  time.UnixMilli(p.NextTime),
+		DeadTime:  deadTime,
+		LastError: p.LastError,
+		Ctime:     time.UnixMilli(p.Ctime),
+	}
+}
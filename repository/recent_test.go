@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"testing"
+	"time"
+)
+
+// recordingRecentCache 记下加进时间线的utime
+type recordingRecentCache struct {
+	nopCaches
+	utimes map[int64]int64
+}
+
+func (c *recordingRecentCache) AddRecentIfPresent(ctx context.Context, property coursev1.CourseProperty, evaluationId int64, utime int64) error {
+	c.utimes[evaluationId] = utime
+	return nil
+}
+
+// 时间线的分数要和数据库里面的utime一致，否则重建前后的顺序不一样
+func TestEvaluationRepository_RecentUsesUtime(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDAO(t)
+	recent := &recordingRecentCache{utimes: map[int64]int64{}}
+	nop := nopCaches{}
+	repo := NewEvaluationRepository(d, cache.NewMemoryEvaluationCache(100), recent,
+		cache.NewLocalEvaluationDetailCache(100), nop, nop, nop, nop, logger.NewNopLogger())
+	check := func(id int64) {
+		e, err := d.GetDetailById(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recent.utimes[id] != e.Utime {
+			t.Fatalf("时间线的分数 %d, 数据库里面的utime %d", recent.utimes[id], e.Utime)
+		}
+	}
+
+	id, err := repo.Create(ctx, domain.Evaluation{PublisherId: 1, CourseId: 2, StarRating: 5,
+		Status: evaluationv1.EvaluationStatus_Public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(id)
+	time.Sleep(time.Millisecond * 5)
+	err = repo.Update(ctx, domain.Evaluation{Id: id, PublisherId: 1, CourseId: 2, StarRating: 4, Content: "改过",
+		Status: evaluationv1.EvaluationStatus_Public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(id)
+	if err = repo.UpdateStatus(ctx, id, evaluationv1.EvaluationStatus_Private, 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 5)
+	if err = repo.UpdateStatus(ctx, id, evaluationv1.EvaluationStatus_Public, 1); err != nil {
+		t.Fatal(err)
+	}
+	check(id)
+}
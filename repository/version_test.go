@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository/cache"
+	"testing"
+)
+
+// TestEvaluationRepository_EditReadEdit 同一个客户端改完马上读，拿读到的版本号接着改，不能冲突
+func TestEvaluationRepository_EditReadEdit(t *testing.T) {
+	ctx := context.Background()
+	nop := nopCaches{}
+	repo := NewEvaluationRepository(newTestDAO(t), cache.NewMemoryEvaluationCache(100), nop,
+		cache.NewLocalEvaluationDetailCache(100), nop, nop, nop, nop, logger.NewNopLogger())
+	e := domain.Evaluation{
+		PublisherId: 1,
+		CourseId:    2,
+		StarRating:  5,
+		Content:     "第一版",
+		Status:      evaluationv1.EvaluationStatus_Public,
+	}
+	id, err := repo.Create(ctx, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range []string{"第二版", "第三版", "第四版"} {
+		cur, err := repo.GetDetailById(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cur.Version != int64(i+1) {
+			t.Fatalf("第 %d 次读到的版本号是 %d", i+1, cur.Version)
+		}
+		cur.Content = content
+		if err = repo.Update(ctx, cur); err != nil {
+			t.Fatalf("第 %d 次修改: %v", i+1, err)
+		}
+	}
+
+	// 拿旧的版本号改要冲突
+	e.Id, e.Version = id, 1
+	var ce *VersionConflictError
+	if err = repo.Update(ctx, e); !errors.As(err, &ce) || ce.Current.Version != 4 || ce.Current.Content != "第四版" {
+		t.Fatalf("旧版本号的修改 %v", err)
+	}
+}
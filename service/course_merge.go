@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"errors"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+)
+
+var ErrInvalidCourseMerge = errors.New("不合法的课程合并")
+
+// CourseMergeService 课程服务合并了重复的课程之后，由管理员把课评也合并过去
+type CourseMergeService interface {
+	Merge(ctx context.Context, sourceCourseId int64, targetCourseId int64) error
+}
+
+type courseMergeService struct {
+	repo         repository.EvaluationRepository
+	courseClient coursev1.CourseServiceClient
+	l            logger.Logger
+}
+
+func NewCourseMergeService(repo repository.EvaluationRepository, courseClient coursev1.CourseServiceClient,
+	l logger.Logger) CourseMergeService {
+	return &courseMergeService{repo: repo, courseClient: courseClient, l: l}
+}
+
+func (s *courseMergeService) Merge(ctx context.Context, sourceCourseId int64, targetCourseId int64) error {
+	if sourceCourseId <= 0 || targetCourseId <= 0 || sourceCourseId == targetCourseId {
+		return ErrInvalidCourseMerge
+	}
+	err := s.repo.MergeCourse(ctx, sourceCourseId, targetCourseId)
+	if err != nil {
+		return err
+	}
+	// 挪过去的课评还带着源课程的性质
+	detailRes, err := s.courseClient.GetDetailById(ctx, &coursev1.GetDetailByIdRequest{
+		CourseId: targetCourseId,
+	})
+	if err != nil {
+		// 合并已经完成了，课程性质交给定时核对兜底
+		s.l.Error("查询课程详情失败", logger.Error(err), logger.Int64("courseId", targetCourseId))
+		return nil
+	}
+	return s.repo.SyncCourseProperty(ctx, targetCourseId, detailRes.GetCourse().GetProperty())
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+)
+
+// CoursePropertyService 课评上冗余了课程性质，课程被重新分类之后要跟着改
+type CoursePropertyService interface {
+	// SyncCourse 课程服务通知了新的课程性质
+	SyncCourse(ctx context.Context, courseId int64, property coursev1.CourseProperty) error
+	// ResyncAll 逐个向课程服务核对所有有课评的课程，兜底漏掉的或者消费失败的事件
+	ResyncAll(ctx context.Context) error
+}
+
+type coursePropertyService struct {
+	repo         repository.EvaluationRepository
+	courseClient coursev1.CourseServiceClient
+	l            logger.Logger
+}
+
+func NewCoursePropertyService(repo repository.EvaluationRepository, courseClient coursev1.CourseServiceClient,
+	l logger.Logger) CoursePropertyService {
+	return &coursePropertyService{repo: repo, courseClient: courseClient, l: l}
+}
+
+func (s *coursePropertyService) SyncCourse(ctx context.Context, courseId int64, property coursev1.CourseProperty) error {
+	return s.repo.SyncCourseProperty(ctx, courseId, property)
+}
+
+func (s *coursePropertyService) ResyncAll(ctx context.Context) error {
+	const batchSize = 100
+	var startCourseId int64
+	for {
+		courseIds, err := s.repo.GetCourseIdsAfter(ctx, startCourseId, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, courseId := range courseIds {
+			detailRes, err := s.courseClient.GetDetailById(ctx, &coursev1.GetDetailByIdRequest{
+				CourseId: courseId,
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				// 单个课程查不到不影响其它课程
+				s.l.Error("查询课程详情失败", logger.Error(err), logger.Int64("courseId", courseId))
+				continue
+			}
+			err = s.repo.SyncCourseProperty(ctx, courseId, detailRes.GetCourse().GetProperty())
+			if err != nil {
+				return err
+			}
+		}
+		if len(courseIds) < batchSize {
+			return nil
+		}
+		startCourseId = courseIds[len(courseIds)-1]
+	}
+}
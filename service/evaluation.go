@@ -3,18 +3,28 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
 	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
 	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/pkg/grpcx"
 	"github.com/MuxiKeStack/be-evaluation/repository"
 )
 
 var (
-	ErrPermissionDenied               = errors.New("没有权限")
+	ErrPermissionDenied               = repository.ErrPermissionDenied
 	ErrCannotEvaluateUnattendedCourse = errors.New("无法评未上过的课")
 	ErrEvaluationNotFound             = repository.ErrEvaluationNotFound
+	ErrDuplicateEvaluation            = repository.ErrDuplicateEvaluation
+	ErrIllegalStatusTransition        = repository.ErrIllegalStatusTransition
+	ErrTooManyRequests                = repository.ErrTooManyRequests
+	// ErrDependencyUnavailable 依赖的服务不可用、超时或者被熔断，不是请求本身的问题，可以稍后重试
+	ErrDependencyUnavailable = errors.New("依赖的服务暂时不可用")
 )
 
+// VersionConflictError 修改课评时带的版本号不是最新的，里面有最新的课评
+type VersionConflictError = repository.VersionConflictError
+
 type EvaluationService interface {
 	Evaluated(ctx context.Context, publisherId int64, courseId int64) (bool, error)
 	Save(ctx context.Context, evaluation domain.Evaluation) (int64, error)
@@ -29,13 +39,46 @@ type EvaluationService interface {
 	CompositeScoreCourse(ctx context.Context, courseId int64) (domain.CompositeScore, error)
 }
 
+// CourseUnavailablePolicy 课程服务不可用、校验不了选课关系的时候怎么处理提交的课评
+type CourseUnavailablePolicy string
+
+const (
+	// CourseUnavailableReject 直接返回错误
+	CourseUnavailableReject CourseUnavailablePolicy = "reject"
+	// CourseUnavailablePending 先收下来，不可见也不计分，课程服务恢复之后再校验
+	CourseUnavailablePending CourseUnavailablePolicy = "pending"
+)
+
+// VerificationMode 新提交的课评什么时候校验选课关系
+type VerificationMode string
+
+const (
+	// VerificationSync 提交的时候同步校验
+	VerificationSync VerificationMode = "sync"
+	// VerificationAsync 先收下来，由后台任务校验，通过之前不可见也不计分，高峰期减少提交的延迟
+	VerificationAsync VerificationMode = "async"
+)
+
 type evaluationService struct {
 	repo         repository.EvaluationRepository
+	pendingRepo  repository.PendingEvaluationRepository
 	courseClient coursev1.CourseServiceClient
+	validator    EvaluationValidator
+	policy       CourseUnavailablePolicy
+	mode         VerificationMode
 }
 
-func NewEvaluationService(repo repository.EvaluationRepository, courseClient coursev1.CourseServiceClient) EvaluationService {
-	return &evaluationService{repo: repo, courseClient: courseClient}
+func NewEvaluationService(repo repository.EvaluationRepository, pendingRepo repository.PendingEvaluationRepository,
+	courseClient coursev1.CourseServiceClient, validator EvaluationValidator, policy CourseUnavailablePolicy,
+	mode VerificationMode) EvaluationService {
+	return &evaluationService{
+		repo:         repo,
+		pendingRepo:  pendingRepo,
+		courseClient: courseClient,
+		validator:    validator,
+		policy:       policy,
+		mode:         mode,
+	}
 }
 
 func (s *evaluationService) CompositeScoreCourse(ctx context.Context, courseId int64) (domain.CompositeScore, error) {
@@ -74,37 +117,94 @@ func (s *evaluationService) ListRecent(ctx context.Context, curEvaluationId int6
 }
 
 func (s *evaluationService) UpdateStatus(ctx context.Context, evaluationId int64, status evaluationv1.EvaluationStatus, uid int64) error {
+	if err := s.validator.ValidateUpdateStatus(evaluationId, status, uid); err != nil {
+		return err
+	}
 	return s.repo.UpdateStatus(ctx, evaluationId, status, uid)
 }
 
 func (s *evaluationService) Save(ctx context.Context, evaluation domain.Evaluation) (int64, error) {
-	// 不是自己的课，不能评
-	subRes, err := s.courseClient.Subscribed(ctx, &coursev1.SubscribedRequest{
+	if err := s.validator.ValidateSave(evaluation, uint32(evaluation.StarRating)); err != nil {
+		return 0, err
+	}
+	if s.mode == VerificationAsync && evaluation.Id == 0 {
+		// 修改已有的课评还是同步校验，否则校验通过之前作者看到的一直是旧的内容
+		return s.addPending(ctx, evaluation)
+	}
+	property, err := checkCourse(ctx, s.courseClient, evaluation)
+	if err != nil {
+		if s.policy == CourseUnavailablePending && errors.Is(err, ErrDependencyUnavailable) {
+			return s.addPending(ctx, evaluation)
+		}
+		return 0, err
+	}
+	evaluation.CourseProperty = property
+	return save(ctx, s.repo, evaluation)
+}
+
+// addPending 先收下来等后台校验。已经评过这门课的新课评现在就拒绝，
+// 否则要等后台写入的时候才发现，作者拿到的课评id永远不会存在
+func (s *evaluationService) addPending(ctx context.Context, evaluation domain.Evaluation) (int64, error) {
+	if evaluation.Id == 0 {
+		ok, err := s.repo.Evaluated(ctx, evaluation.PublisherId, evaluation.CourseId)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return 0, ErrDuplicateEvaluation
+		}
+	}
+	return s.pendingRepo.Add(ctx, domain.PendingEvaluation{
+		Evaluation: evaluation,
+		IsUpdate:   evaluation.Id > 0,
+	})
+}
+
+// checkCourse 不是自己的课不能评，顺便查出课程性质冗余到课评上
+func checkCourse(ctx context.Context, courseClient coursev1.CourseServiceClient,
+	evaluation domain.Evaluation) (coursev1.CourseProperty, error) {
+	subRes, err := courseClient.Subscribed(ctx, &coursev1.SubscribedRequest{
 		Uid:      evaluation.PublisherId,
 		CourseId: evaluation.CourseId,
 	})
 	if err != nil {
-		return 0, err
+		return 0, courseError(err)
 	}
 	if !subRes.GetSubscribed() {
 		return 0, ErrCannotEvaluateUnattendedCourse
 	}
 	// 聚合property
-	detailRes, err := s.courseClient.GetDetailById(ctx, &coursev1.GetDetailByIdRequest{
+	detailRes, err := courseClient.GetDetailById(ctx, &coursev1.GetDetailByIdRequest{
 		CourseId: evaluation.CourseId,
 	})
 	if err != nil {
-		return 0, err
+		return 0, courseError(err)
+	}
+	return detailRes.GetCourse().GetProperty(), nil
+}
+
+// courseError 课程服务不可用的错误包一层 ErrDependencyUnavailable，课程不存在之类的错误原样返回
+func courseError(err error) error {
+	if grpcx.IsUnavailable(err) {
+		return fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
 	}
-	evaluation.CourseProperty = detailRes.GetCourse().GetProperty()
-	// 下面是一个upsert语义
+	return err
+}
+
+// save 是一个upsert语义
+func save(ctx context.Context, repo repository.EvaluationRepository, evaluation domain.Evaluation) (int64, error) {
 	if evaluation.Id > 0 {
-		err = s.repo.Update(ctx, evaluation)
+		err := repo.Update(ctx, evaluation)
 		return evaluation.Id, err
 	}
-	return s.repo.Create(ctx, evaluation)
+	return repo.Create(ctx, evaluation)
 }
 
 func (s *evaluationService) Evaluated(ctx context.Context, publisherId int64, courseId int64) (bool, error) {
-	return s.repo.Evaluated(ctx, publisherId, courseId)
+	ok, err := s.repo.Evaluated(ctx, publisherId, courseId)
+	if err != nil || ok {
+		return ok, err
+	}
+	// 还在校验的也算评过了，免得作者以为没提交成功又提交一次
+	return s.pendingRepo.ExistsCreate(ctx, publisherId, courseId)
 }
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"testing"
+)
+
+func TestEvaluationService_SaveAsync(t *testing.T) {
+	testCases := []struct {
+		name    string
+		existed []domain.Evaluation
+		wantErr error
+		// 收下之后还在校验的新课评
+		wantPending bool
+	}{
+		{
+			name:        "收下等后台校验",
+			wantPending: true,
+		},
+		{
+			name:    "已经评过这门课，现在就拒绝",
+			existed: []domain.Evaluation{{Id: 1, PublisherId: 1, CourseId: 2}},
+			wantErr: ErrDuplicateEvaluation,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			pendingRepo := newTestPendingRepo(t)
+			svc := NewEvaluationService(newMemoryEvaluationRepo(tc.existed...), pendingRepo,
+				&fakeCourseClient{subscribed: true}, NewEvaluationValidator(2000),
+				CourseUnavailableReject, VerificationAsync)
+			id, err := svc.Save(ctx, domain.Evaluation{
+				PublisherId: 1,
+				CourseId:    2,
+				StarRating:  5,
+				Status:      evaluationv1.EvaluationStatus_Public,
+			})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, 想要 %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil && id <= 0 {
+				t.Fatalf("没有分配课评id: %d", id)
+			}
+			ok, err := pendingRepo.ExistsCreate(ctx, 1, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.wantPending {
+				t.Fatalf("还在校验 = %v, 想要 %v", ok, tc.wantPending)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"strconv"
+)
+
+// ExportService 给教务和研究人员导出课评
+type ExportService interface {
+	// Export 按id顺序分批查出来逐条交给fn，fn返回错误就停止导出
+	Export(ctx context.Context, filter domain.ExportFilter, fn func(rec domain.ExportRecord) error) error
+}
+
+type exportService struct {
+	repo repository.EvaluationRepository
+	// 化名的密钥，同一个密钥下同一个用户的化名不变，方便按人分析又不暴露身份
+	pseudonymKey []byte
+	batchSize    int
+}
+
+func NewExportService(repo repository.EvaluationRepository, pseudonymKey []byte) ExportService {
+	return &exportService{repo: repo, pseudonymKey: pseudonymKey, batchSize: 500}
+}
+
+func (s *exportService) Export(ctx context.Context, filter domain.ExportFilter, fn func(rec domain.ExportRecord) error) error {
+	var afterId int64
+	for {
+		evaluations, err := s.repo.GetListForExport(ctx, filter, afterId, s.batchSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range evaluations {
+			if e.Status != evaluationv1.EvaluationStatus_Public {
+				// 仓库只会查出公开的课评，这里再挡一次，防止私密的课评流出去
+				continue
+			}
+			err = fn(s.toRecord(e))
+			if err != nil {
+				return err
+			}
+		}
+		if len(evaluations) < s.batchSize {
+			return nil
+		}
+		afterId = evaluations[len(evaluations)-1].Id
+	}
+}
+
+func (s *exportService) toRecord(e domain.Evaluation) domain.ExportRecord {
+	return domain.ExportRecord{
+		EvaluationId:   e.Id,
+		Publisher:      s.publisher(e),
+		CourseId:       e.CourseId,
+		CourseProperty: e.CourseProperty,
+		StarRating:     e.StarRating,
+		Content:        e.Content,
+		Status:         e.Status,
+		IsAnonymous:    e.IsAnonymous,
+		Utime:          e.Utime,
+		Ctime:          e.Ctime,
+	}
+}
+
+// publisher 匿名课评用化名，没有配置密钥的时候直接隐去，任何情况下都不输出匿名课评的uid
+func (s *exportService) publisher(e domain.Evaluation) string {
+	uid := strconv.FormatInt(e.PublisherId, 10)
+	if !e.IsAnonymous {
+		return uid
+	}
+	if len(s.pseudonymKey) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.pseudonymKey)
+	mac.Write([]byte(uid))
+	return "anon_" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
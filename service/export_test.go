@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"strings"
+	"testing"
+)
+
+type exportRepo struct {
+	repository.EvaluationRepository
+	evaluations []domain.Evaluation
+}
+
+func (r *exportRepo) GetListForExport(ctx context.Context, filter domain.ExportFilter, afterId int64,
+	limit int) ([]domain.Evaluation, error) {
+	var res []domain.Evaluation
+	for _, e := range r.evaluations {
+		if e.Id > afterId && len(res) < limit {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+func TestExportService_Export(t *testing.T) {
+	repo := &exportRepo{evaluations: []domain.Evaluation{
+		{Id: 1, PublisherId: 1001, Status: evaluationv1.EvaluationStatus_Public},
+		{Id: 2, PublisherId: 1002, Status: evaluationv1.EvaluationStatus_Public, IsAnonymous: true},
+		{Id: 3, PublisherId: 1003, Status: evaluationv1.EvaluationStatus_Private},
+		{Id: 4, PublisherId: 1004, Status: evaluationv1.EvaluationStatus_Folded},
+		{Id: 5, PublisherId: 1005, Status: evaluationv1.EvaluationStatus_Public, IsAnonymous: true},
+	}}
+	testCases := []struct {
+		name string
+		key  string
+		// 按顺序导出的发布者，空字符串表示隐去
+		wantPublishers []string
+	}{
+		{
+			name:           "匿名课评用化名",
+			key:            "secret",
+			wantPublishers: []string{"1001", "anon_", "anon_"},
+		},
+		{
+			name:           "没有密钥的时候隐去匿名课评的发布者",
+			wantPublishers: []string{"1001", "", ""},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &exportService{repo: repo, pseudonymKey: []byte(tc.key), batchSize: 2}
+			var recs []domain.ExportRecord
+			err := svc.Export(context.Background(), domain.ExportFilter{}, func(rec domain.ExportRecord) error {
+				recs = append(recs, rec)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recs) != len(tc.wantPublishers) {
+				t.Fatalf("导出了 %d 条，应该是 %d 条: %+v", len(recs), len(tc.wantPublishers), recs)
+			}
+			for i, rec := range recs {
+				if rec.Status != evaluationv1.EvaluationStatus_Public {
+					t.Errorf("导出了非公开的课评 %d", rec.EvaluationId)
+				}
+				if !strings.HasPrefix(rec.Publisher, tc.wantPublishers[i]) ||
+					(tc.wantPublishers[i] == "" && rec.Publisher != "") {
+					t.Errorf("课评 %d 的发布者是 %q，应该是 %q", rec.EvaluationId, rec.Publisher, tc.wantPublishers[i])
+				}
+				if rec.IsAnonymous && strings.Contains(rec.Publisher, "100") {
+					t.Errorf("匿名课评 %d 导出了uid: %q", rec.EvaluationId, rec.Publisher)
+				}
+			}
+			if tc.key != "" && recs[1].Publisher == recs[2].Publisher {
+				t.Errorf("不同用户的化名相同: %q", recs[1].Publisher)
+			}
+		})
+	}
+}
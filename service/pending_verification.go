@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/events"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"time"
+)
+
+// PendingVerificationService 后台校验待校验课评的选课关系，
+// 包括课程服务不可用的时候收下的，和异步校验模式下新提交的
+type PendingVerificationService interface {
+	// VerifyDue 校验一批到期的待校验课评，返回这一批取到的条数
+	VerifyDue(ctx context.Context, limit int) (int, error)
+}
+
+type pendingVerificationService struct {
+	repo         repository.EvaluationRepository
+	pendingRepo  repository.PendingEvaluationRepository
+	courseClient coursev1.CourseServiceClient
+	producer     events.Producer
+	l            logger.Logger
+	// 第n次校验失败之后等 baseBackoff*2^(n-1)，不超过 maxBackoff
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	// 校验了这么多次还没有结果就转成死信，等人工处理
+	maxAttempts int
+}
+
+func NewPendingVerificationService(repo repository.EvaluationRepository, pendingRepo repository.PendingEvaluationRepository,
+	courseClient coursev1.CourseServiceClient, producer events.Producer, l logger.Logger) PendingVerificationService {
+	return &pendingVerificationService{
+		repo:         repo,
+		pendingRepo:  pendingRepo,
+		courseClient: courseClient,
+		producer:     producer,
+		l:            l,
+		baseBackoff:  time.Second * 10,
+		maxBackoff:   time.Minute * 10,
+		// 大约两天
+		maxAttempts: 300,
+	}
+}
+
+func (s *pendingVerificationService) VerifyDue(ctx context.Context, limit int) (int, error) {
+	ps, err := s.pendingRepo.FindDue(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+	for i, p := range ps {
+		ok, err := s.pendingRepo.Claim(ctx, p, time.Now().Add(s.backoff(p.Attempts+1)))
+		if err != nil {
+			return i, err
+		}
+		if !ok {
+			// 别的实例在校验
+			continue
+		}
+		err = s.verify(ctx, p)
+		if err == nil {
+			continue
+		}
+		if p.Attempts+1 >= s.maxAttempts {
+			s.l.Error("待校验课评重试次数用完，转成死信", logger.Error(err), logger.Int64("id", p.Id),
+				logger.Int64("evaluationId", p.Evaluation.Id))
+			if er := s.pendingRepo.Bury(ctx, p.Id, err.Error()); er != nil {
+				return i, er
+			}
+			continue
+		}
+		if errors.Is(err, ErrDependencyUnavailable) {
+			// 课程服务还没恢复，这一批剩下的也不用试了，等下次
+			return i, nil
+		}
+		s.l.Error("校验待校验课评失败", logger.Error(err), logger.Int64("id", p.Id),
+			logger.Int64("evaluationId", p.Evaluation.Id))
+	}
+	return len(ps), nil
+}
+
+// verify 返回nil表示这条已经处理完了，不管是写入了还是丢弃了，返回错误的等下次重试
+func (s *pendingVerificationService) verify(ctx context.Context, p domain.PendingEvaluation) error {
+	evaluation := p.Evaluation
+	property, err := checkCourse(ctx, s.courseClient, evaluation)
+	switch {
+	case errors.Is(err, ErrCannotEvaluateUnattendedCourse):
+		return s.reject(ctx, p, events.RejectReasonNotSubscribed)
+	case kerrors.IsNotFound(err):
+		return s.reject(ctx, p, events.RejectReasonCourseNotFound)
+	case err != nil:
+		return err
+	}
+	evaluation.CourseProperty = property
+	if p.IsUpdate {
+		err = s.repo.Update(ctx, evaluation)
+	} else {
+		// 课评id是收下的时候预先分配的
+		_, err = s.repo.Create(ctx, evaluation)
+		if errors.Is(err, repository.ErrDuplicateEvaluation) {
+			return s.duplicateCreate(ctx, p)
+		}
+	}
+	var ce *VersionConflictError
+	if errors.As(err, &ce) {
+		return s.reject(ctx, p, events.RejectReasonVersionConflict)
+	}
+	if err != nil {
+		return err
+	}
+	return s.pendingRepo.Delete(ctx, p.Id)
+}
+
+// duplicateCreate 写入新课评的时候发现这个用户已经评过这门课了。
+// 预先分配的id已经存在，说明是上次写入成功了但是没来得及删除，当成功处理；
+// 否则是另一条课评，这条丢弃并通知作者，不能悄悄删掉
+func (s *pendingVerificationService) duplicateCreate(ctx context.Context, p domain.PendingEvaluation) error {
+	_, err := s.repo.GetDetailById(ctx, p.Evaluation.Id)
+	switch {
+	case err == nil:
+		return s.pendingRepo.Delete(ctx, p.Id)
+	case errors.Is(err, repository.ErrEvaluationNotFound):
+		return s.reject(ctx, p, events.RejectReasonDuplicate)
+	default:
+		return err
+	}
+}
+
+// reject 丢弃没通过校验的课评并通知作者，通知发出去之后才删除，所以作者可能收到重复的通知
+func (s *pendingVerificationService) reject(ctx context.Context, p domain.PendingEvaluation, reason string) error {
+	s.l.Warn("丢弃没有通过校验的课评", logger.String("reason", reason), logger.Int64("evaluationId", p.Evaluation.Id),
+		logger.Int64("publisherId", p.Evaluation.PublisherId), logger.Int64("courseId", p.Evaluation.CourseId))
+	err := s.producer.ProduceEvaluationRejectedEvent(ctx, events.EvaluationRejectedEvent{
+		EventId:      p.Id,
+		EvaluationId: p.Evaluation.Id,
+		PublisherId:  p.Evaluation.PublisherId,
+		CourseId:     p.Evaluation.CourseId,
+		Reason:       reason,
+		Ctime:        time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	return s.pendingRepo.Delete(ctx, p.Id)
+}
+
+func (s *pendingVerificationService) backoff(attempts int) time.Duration {
+	d := s.baseBackoff << (attempts - 1)
+	if d > s.maxBackoff || d <= 0 {
+		return s.maxBackoff
+	}
+	return d
+}
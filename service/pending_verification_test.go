@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	coursev1 "github.com/MuxiKeStack/be-api/gen/proto/course/v1"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"github.com/MuxiKeStack/be-evaluation/events"
+	"github.com/MuxiKeStack/be-evaluation/pkg/idgen"
+	"github.com/MuxiKeStack/be-evaluation/pkg/logger"
+	"github.com/MuxiKeStack/be-evaluation/repository"
+	"github.com/MuxiKeStack/be-evaluation/repository/dao"
+	"github.com/glebarez/sqlite"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
+	glogger "gorm.io/gorm/logger"
+	"testing"
+)
+
+// memoryEvaluationRepo 只实现校验和保存用到的方法，同一个用户同一门课只能有一条课评
+type memoryEvaluationRepo struct {
+	repository.EvaluationRepository
+	evaluations map[int64]domain.Evaluation
+}
+
+func newMemoryEvaluationRepo(evaluations ...domain.Evaluation) *memoryEvaluationRepo {
+	repo := &memoryEvaluationRepo{evaluations: map[int64]domain.Evaluation{}}
+	for _, e := range evaluations {
+		repo.evaluations[e.Id] = e
+	}
+	return repo
+}
+
+func (r *memoryEvaluationRepo) Create(ctx context.Context, evaluation domain.Evaluation) (int64, error) {
+	for _, e := range r.evaluations {
+		if e.Id == evaluation.Id || (e.PublisherId == evaluation.PublisherId && e.CourseId == evaluation.CourseId) {
+			return 0, repository.ErrDuplicateEvaluation
+		}
+	}
+	if evaluation.Id == 0 {
+		evaluation.Id = int64(len(r.evaluations) + 1000)
+	}
+	r.evaluations[evaluation.Id] = evaluation
+	return evaluation.Id, nil
+}
+
+func (r *memoryEvaluationRepo) Update(ctx context.Context, evaluation domain.Evaluation) error {
+	r.evaluations[evaluation.Id] = evaluation
+	return nil
+}
+
+func (r *memoryEvaluationRepo) GetDetailById(ctx context.Context, evaluationId int64) (domain.Evaluation, error) {
+	e, ok := r.evaluations[evaluationId]
+	if !ok {
+		return domain.Evaluation{}, repository.ErrEvaluationNotFound
+	}
+	return e, nil
+}
+
+func (r *memoryEvaluationRepo) Evaluated(ctx context.Context, publisherId int64, courseId int64) (bool, error) {
+	for _, e := range r.evaluations {
+		if e.PublisherId == publisherId && e.CourseId == courseId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type fakeCourseClient struct {
+	coursev1.CourseServiceClient
+	subscribed bool
+	err        error
+}
+
+func (c *fakeCourseClient) Subscribed(ctx context.Context, in *coursev1.SubscribedRequest,
+	opts ...grpc.CallOption) (*coursev1.SubscribedResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &coursev1.SubscribedResponse{Subscribed: c.subscribed}, nil
+}
+
+func (c *fakeCourseClient) GetDetailById(ctx context.Context, in *coursev1.GetDetailByIdRequest,
+	opts ...grpc.CallOption) (*coursev1.GetDetailByIdResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &coursev1.GetDetailByIdResponse{Course: &coursev1.Course{Id: in.CourseId, Property: 1}}, nil
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		TranslateError: true,
+		Logger:         glogger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存库每个连接是独立的
+	sqlDB.SetMaxOpenConns(1)
+	if err = dao.InitTables(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestPendingRepo(t *testing.T) repository.PendingEvaluationRepository {
+	idGen, err := idgen.NewSnowflake(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repository.NewPendingEvaluationRepository(dao.NewGORMPendingEvaluationDAO(newTestDB(t), idGen))
+}
+
+func TestPendingVerificationService_VerifyDue(t *testing.T) {
+	newEvaluation := domain.Evaluation{
+		PublisherId: 1,
+		CourseId:    2,
+		StarRating:  5,
+		Content:     "好课",
+		Status:      evaluationv1.EvaluationStatus_Public,
+	}
+	testCases := []struct {
+		name     string
+		existing []domain.Evaluation
+		// 返回已经写进去的课评，用预先分配的id
+		existingSelf bool
+		client       *fakeCourseClient
+		wantVerified int
+		wantStored   bool
+		wantPending  bool
+		wantReject   string
+	}{
+		{
+			name:         "校验通过写入",
+			client:       &fakeCourseClient{subscribed: true},
+			wantVerified: 1,
+			wantStored:   true,
+		},
+		{
+			name:         "没选这门课丢弃并通知",
+			client:       &fakeCourseClient{},
+			wantVerified: 1,
+			wantReject:   events.RejectReasonNotSubscribed,
+		},
+		{
+			name: "已经有另一条课评了，丢弃并通知",
+			existing: []domain.Evaluation{{Id: 1, PublisherId: 1, CourseId: 2, StarRating: 1,
+				Content: "先提交的"}},
+			client:       &fakeCourseClient{subscribed: true},
+			wantVerified: 1,
+			wantReject:   events.RejectReasonDuplicate,
+		},
+		{
+			name:         "上次已经写进去了，只是没来得及删除",
+			existingSelf: true,
+			client:       &fakeCourseClient{subscribed: true},
+			wantVerified: 1,
+			wantStored:   true,
+		},
+		{
+			name:         "课程服务不可用，留着下次再校验",
+			client:       &fakeCourseClient{err: kerrors.ServiceUnavailable("UNAVAILABLE", "课程服务挂了")},
+			wantVerified: 0,
+			wantPending:  true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			pendingRepo := newTestPendingRepo(t)
+			id, err := pendingRepo.Add(ctx, domain.PendingEvaluation{Evaluation: newEvaluation})
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo := newMemoryEvaluationRepo(tc.existing...)
+			if tc.existingSelf {
+				self := newEvaluation
+				self.Id = id
+				repo.evaluations[id] = self
+			}
+			producer := events.NewMemoryProducer()
+			svc := NewPendingVerificationService(repo, pendingRepo, tc.client, producer, logger.NewNopLogger())
+			n, err := svc.VerifyDue(ctx, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tc.wantVerified {
+				t.Errorf("处理了 %d 条，应该是 %d 条", n, tc.wantVerified)
+			}
+			stored, ok := repo.evaluations[id]
+			if ok != tc.wantStored || (ok && stored.Content != newEvaluation.Content) {
+				t.Errorf("预先分配的课评 %d 写进去了吗: %v，应该是 %v", id, ok, tc.wantStored)
+			}
+			pending, err := pendingRepo.ExistsCreate(ctx, newEvaluation.PublisherId, newEvaluation.CourseId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pending != tc.wantPending {
+				t.Errorf("还在待校验吗: %v，应该是 %v", pending, tc.wantPending)
+			}
+			rejected := producer.RejectedEvents()
+			switch {
+			case tc.wantReject == "" && len(rejected) > 0:
+				t.Errorf("不应该丢弃: %+v", rejected)
+			case tc.wantReject != "" && (len(rejected) != 1 || rejected[0].Reason != tc.wantReject ||
+				rejected[0].EvaluationId != id):
+				t.Errorf("丢弃通知是 %+v，原因应该是 %s", rejected, tc.wantReject)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
+	"github.com/MuxiKeStack/be-evaluation/domain"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinStarRating = 1
+	MaxStarRating = 5
+)
+
+// FieldError 某个字段不合法，Field 用 proto 里面的字段名
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError 一次把所有不合法的字段都带出去，调用方不用一个一个改
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return "参数不合法: " + strings.Join(msgs, "; ")
+}
+
+// EvaluationValidator 校验写课评的参数，grpc层和service层都会调用，
+// grpc层在类型转换之前校验，防止溢出之后绕过校验
+type EvaluationValidator interface {
+	// ValidateSave starRating 传转换成 uint8 之前的值
+	ValidateSave(evaluation domain.Evaluation, starRating uint32) error
+	ValidateUpdateStatus(evaluationId int64, status evaluationv1.EvaluationStatus, uid int64) error
+}
+
+type evaluationValidator struct {
+	// 按字符数算，不是字节数
+	maxContentLength int
+}
+
+func NewEvaluationValidator(maxContentLength int) EvaluationValidator {
+	return &evaluationValidator{maxContentLength: maxContentLength}
+}
+
+func (v *evaluationValidator) ValidateSave(evaluation domain.Evaluation, starRating uint32) error {
+	var fields []FieldError
+	if evaluation.Id < 0 {
+		fields = append(fields, FieldError{Field: "id", Message: "不能是负数"})
+	}
+	if evaluation.Version < 0 {
+		fields = append(fields, FieldError{Field: "version", Message: "不能是负数"})
+	}
+	if evaluation.PublisherId <= 0 {
+		fields = append(fields, FieldError{Field: "publisher_id", Message: "必须是正数"})
+	}
+	if evaluation.CourseId <= 0 {
+		fields = append(fields, FieldError{Field: "course_id", Message: "必须是正数"})
+	}
+	if starRating < MinStarRating || starRating > MaxStarRating {
+		fields = append(fields, FieldError{Field: "star_rating",
+			Message: fmt.Sprintf("必须在 %d 到 %d 之间", MinStarRating, MaxStarRating)})
+	}
+	if !utf8.ValidString(evaluation.Content) {
+		fields = append(fields, FieldError{Field: "content", Message: "不是合法的UTF-8"})
+	} else if n := utf8.RuneCountInString(evaluation.Content); n > v.maxContentLength {
+		fields = append(fields, FieldError{Field: "content",
+			Message: fmt.Sprintf("不能超过 %d 个字，现在是 %d 个字", v.maxContentLength, n)})
+	}
+	switch {
+	case !isUserSettableStatus(evaluation.Status):
+		fields = append(fields, FieldError{Field: "status", Message: "只能是公开或者私密"})
+	case evaluation.Id == 0 && evaluation.Status != evaluationv1.EvaluationStatus_Public:
+		fields = append(fields, FieldError{Field: "status", Message: "不可以非公开状态发布课评"})
+	}
+	return validationResult(fields)
+}
+
+func (v *evaluationValidator) ValidateUpdateStatus(evaluationId int64, status evaluationv1.EvaluationStatus, uid int64) error {
+	var fields []FieldError
+	if evaluationId <= 0 {
+		fields = append(fields, FieldError{Field: "evaluation_id", Message: "必须是正数"})
+	}
+	if uid <= 0 {
+		fields = append(fields, FieldError{Field: "uid", Message: "必须是正数"})
+	}
+	if !isUserSettableStatus(status) {
+		fields = append(fields, FieldError{Field: "status", Message: "只能是公开或者私密"})
+	}
+	return validationResult(fields)
+}
+
+// isUserSettableStatus 折叠是管理员的操作，作者只能在公开和私密之间切换，不认识的枚举值一律不合法
+func isUserSettableStatus(status evaluationv1.EvaluationStatus) bool {
+	return status == evaluationv1.EvaluationStatus_Public || status == evaluationv1.EvaluationStatus_Private
+}
+
+func validationResult(fields []FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: fields}
+}
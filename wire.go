@@ -3,30 +3,117 @@
 package main
 
 import (
+	"github.com/MuxiKeStack/be-evaluation/events/course"
 	"github.com/MuxiKeStack/be-evaluation/grpc"
 	"github.com/MuxiKeStack/be-evaluation/ioc"
-	"github.com/MuxiKeStack/be-evaluation/pkg/grpcx"
+	"github.com/MuxiKeStack/be-evaluation/job"
 	"github.com/MuxiKeStack/be-evaluation/repository"
-	"github.com/MuxiKeStack/be-evaluation/repository/cache"
 	"github.com/MuxiKeStack/be-evaluation/repository/dao"
 	"github.com/MuxiKeStack/be-evaluation/service"
 	"github.com/google/wire"
+	"github.com/redis/go-redis/v9"
 )
 
-func InitGRPCServer() grpcx.Server {
+var thirdPartySet = wire.NewSet(
+	ioc.InitRedis,
+	wire.Bind(new(redis.Cmdable), new(redis.UniversalClient)),
+	ioc.InitDB,
+	ioc.InitDstDB,
+	ioc.InitShardDBs,
+	ioc.InitIdGenerator,
+	ioc.InitLimiter,
+	ioc.InitEtcdClient,
+	ioc.InitLogger,
+	ioc.InitCourseClient,
+	ioc.InitCourseCache,
+)
+
+var evaluationRepoSet = wire.NewSet(
+	repository.NewEvaluationRepository,
+	ioc.InitEvaluationCache,
+	ioc.InitRecentEvaluationCache,
+	ioc.InitEvaluationDetailCache,
+	ioc.InitPrimaryStickyCache,
+	ioc.InitEvaluationCountCache,
+	ioc.InitCourseBloomFilter,
+	ioc.InitEvaluationBloomFilter,
+	ioc.InitEvaluationDAO,
+)
+
+// adminThirdPartySet 管理员命令用，不起后台goroutine
+var adminThirdPartySet = wire.NewSet(
+	ioc.InitRedis,
+	wire.Bind(new(redis.Cmdable), new(redis.UniversalClient)),
+	ioc.InitDB,
+	ioc.InitDstDB,
+	ioc.InitShardDBs,
+	ioc.InitIdGenerator,
+	ioc.InitLimiter,
+	ioc.InitEtcdClient,
+	ioc.InitLogger,
+	ioc.InitCourseClient,
+	ioc.InitAdminCourseCache,
+)
+
+var adminEvaluationRepoSet = wire.NewSet(
+	repository.NewEvaluationRepository,
+	ioc.InitAdminEvaluationCache,
+	ioc.InitRecentEvaluationCache,
+	ioc.InitAdminEvaluationDetailCache,
+	ioc.InitPrimaryStickyCache,
+	ioc.InitEvaluationCountCache,
+	ioc.InitAdminCourseBloomFilter,
+	ioc.InitAdminEvaluationBloomFilter,
+	ioc.InitEvaluationDAO,
+)
+
+func InitApp() *App {
 	wire.Build(
+		thirdPartySet,
+		evaluationRepoSet,
 		ioc.InitGRPCxKratosServer,
 		grpc.NewEvaluationServiceServer,
-		service.NewEvaluationService,
-		ioc.InitCourseClient,
-		repository.NewEvaluationRepository,
-		cache.NewRedisEvaluationCache,
-		dao.NewGORMEvaluationDAO,
-		ioc.InitRedis,
-		ioc.InitDB,
-		ioc.InitLimiter,
-		ioc.InitEtcdClient,
-		ioc.InitLogger,
+		grpc.NewExportServiceServer,
+		ioc.InitExportService,
+		ioc.InitEvaluationService,
+		ioc.InitEvaluationValidator,
+		service.NewPendingVerificationService,
+		repository.NewPendingEvaluationRepository,
+		dao.NewGORMPendingEvaluationDAO,
+		service.NewCoursePropertyService,
+		repository.NewEvaluationEventRepository,
+		ioc.InitEvaluationEventDAO,
+		ioc.InitEventProducer,
+		ioc.InitSyncProducer,
+		ioc.InitSaramaClient,
+		course.NewCourseUpdatedConsumer,
+		ioc.InitConsumers,
+		job.NewBloomBuildJob,
+		job.NewBloomRebuildJob,
+		job.NewEventRelayJob,
+		job.NewCoursePropertyResyncJob,
+		job.NewPendingVerificationJob,
+		ioc.InitJobs,
+		wire.Struct(new(App), "*"),
+	)
+	return new(App)
+}
+
+// InitCourseMergeService 管理员命令用，不启动服务
+func InitCourseMergeService() service.CourseMergeService {
+	wire.Build(
+		adminThirdPartySet,
+		adminEvaluationRepoSet,
+		service.NewCourseMergeService,
+	)
+	return nil
+}
+
+// InitEvaluationRepository 管理员命令用，不启动服务
+func InitEvaluationRepository() repository.EvaluationRepository {
+	wire.Build(
+		adminThirdPartySet,
+		adminEvaluationRepoSet,
 	)
-	return grpcx.Server(nil)
+	return nil
 }
@@ -7,29 +7,125 @@
 package main
 
 import (
+	"github.com/MuxiKeStack/be-evaluation/events/course"
 	"github.com/MuxiKeStack/be-evaluation/grpc"
 	"github.com/MuxiKeStack/be-evaluation/ioc"
-	"github.com/MuxiKeStack/be-evaluation/pkg/grpcx"
+	"github.com/MuxiKeStack/be-evaluation/job"
 	"github.com/MuxiKeStack/be-evaluation/repository"
-	"github.com/MuxiKeStack/be-evaluation/repository/cache"
 	"github.com/MuxiKeStack/be-evaluation/repository/dao"
 	"github.com/MuxiKeStack/be-evaluation/service"
+	"github.com/google/wire"
+	"github.com/redis/go-redis/v9"
 )
 
 // Injectors from wire.go:
 
-func InitGRPCServer() grpcx.Server {
+func InitApp() *App {
 	logger := ioc.InitLogger()
-	cmdable := ioc.InitRedis()
-	limiter := ioc.InitLimiter(cmdable)
+	universalClient := ioc.InitRedis()
+	limiter := ioc.InitLimiter(universalClient)
 	db := ioc.InitDB(logger, limiter)
-	evaluationDAO := dao.NewGORMEvaluationDAO(db)
-	evaluationCache := cache.NewRedisEvaluationCache(cmdable)
-	evaluationRepository := repository.NewEvaluationRepository(evaluationDAO, evaluationCache, logger)
+	dstDB := ioc.InitDstDB(logger, limiter)
+	shardDBs := ioc.InitShardDBs(logger, limiter)
 	client := ioc.InitEtcdClient()
-	courseServiceClient := ioc.InitCourseClient(client)
-	evaluationService := service.NewEvaluationService(evaluationRepository, courseServiceClient)
-	evaluationServiceServer := grpc.NewEvaluationServiceServer(evaluationService)
-	server := ioc.InitGRPCxKratosServer(evaluationServiceServer, client, logger)
-	return server
+	generator := ioc.InitIdGenerator(client, logger)
+	evaluationDAO := ioc.InitEvaluationDAO(db, dstDB, shardDBs, generator, logger)
+	evaluationCache := ioc.InitEvaluationCache(universalClient, logger)
+	recentEvaluationCache := ioc.InitRecentEvaluationCache(universalClient)
+	evaluationDetailCache := ioc.InitEvaluationDetailCache(universalClient, logger)
+	evaluationCountCache := ioc.InitEvaluationCountCache(universalClient)
+	courseBloomFilter := ioc.InitCourseBloomFilter(universalClient, logger)
+	evaluationBloomFilter := ioc.InitEvaluationBloomFilter(universalClient, logger)
+	primaryStickyCache := ioc.InitPrimaryStickyCache(universalClient)
+	evaluationRepository := repository.NewEvaluationRepository(evaluationDAO, evaluationCache, recentEvaluationCache, evaluationDetailCache, evaluationCountCache, courseBloomFilter, evaluationBloomFilter, primaryStickyCache, logger)
+	pendingEvaluationDAO := dao.NewGORMPendingEvaluationDAO(db, generator)
+	pendingEvaluationRepository := repository.NewPendingEvaluationRepository(pendingEvaluationDAO)
+	courseCache := ioc.InitCourseCache(universalClient, logger)
+	courseServiceClient := ioc.InitCourseClient(client, courseCache, logger)
+	evaluationValidator := ioc.InitEvaluationValidator()
+	evaluationService := ioc.InitEvaluationService(evaluationRepository, pendingEvaluationRepository, courseServiceClient, evaluationValidator)
+	evaluationServiceServer := grpc.NewEvaluationServiceServer(evaluationService, evaluationValidator)
+	exportService := ioc.InitExportService(evaluationRepository, logger)
+	exportServiceServer := grpc.NewExportServiceServer(exportService)
+	server := ioc.InitGRPCxKratosServer(evaluationServiceServer, exportServiceServer, client, logger)
+	bloomBuildJob := job.NewBloomBuildJob(evaluationRepository)
+	bloomRebuildJob := job.NewBloomRebuildJob(evaluationRepository)
+	evaluationEventDAO := ioc.InitEvaluationEventDAO(db, dstDB, shardDBs)
+	saramaClient := ioc.InitSaramaClient()
+	syncProducer := ioc.InitSyncProducer(saramaClient)
+	producer := ioc.InitEventProducer(syncProducer)
+	evaluationEventRepository := repository.NewEvaluationEventRepository(evaluationEventDAO, producer)
+	eventRelayJob := job.NewEventRelayJob(evaluationEventRepository)
+	coursePropertyService := service.NewCoursePropertyService(evaluationRepository, courseServiceClient, logger)
+	coursePropertyResyncJob := job.NewCoursePropertyResyncJob(coursePropertyService)
+	pendingVerificationService := service.NewPendingVerificationService(evaluationRepository, pendingEvaluationRepository, courseServiceClient, producer, logger)
+	pendingVerificationJob := job.NewPendingVerificationJob(pendingVerificationService)
+	cron := ioc.InitJobs(logger, bloomBuildJob, bloomRebuildJob, eventRelayJob, coursePropertyResyncJob, pendingVerificationJob)
+	courseUpdatedConsumer := course.NewCourseUpdatedConsumer(saramaClient, coursePropertyService, courseCache, logger)
+	v := ioc.InitConsumers(saramaClient, courseUpdatedConsumer)
+	app := &App{
+		server:    server,
+		cron:      cron,
+		consumers: v,
+		l:         logger,
+	}
+	return app
 }
+
+// InitCourseMergeService 管理员命令用，不启动服务
+func InitCourseMergeService() service.CourseMergeService {
+	logger := ioc.InitLogger()
+	universalClient := ioc.InitRedis()
+	limiter := ioc.InitLimiter(universalClient)
+	db := ioc.InitDB(logger, limiter)
+	dstDB := ioc.InitDstDB(logger, limiter)
+	shardDBs := ioc.InitShardDBs(logger, limiter)
+	client := ioc.InitEtcdClient()
+	generator := ioc.InitIdGenerator(client, logger)
+	evaluationDAO := ioc.InitEvaluationDAO(db, dstDB, shardDBs, generator, logger)
+	evaluationCache := ioc.InitAdminEvaluationCache(universalClient)
+	recentEvaluationCache := ioc.InitRecentEvaluationCache(universalClient)
+	evaluationDetailCache := ioc.InitAdminEvaluationDetailCache(universalClient)
+	evaluationCountCache := ioc.InitEvaluationCountCache(universalClient)
+	courseBloomFilter := ioc.InitAdminCourseBloomFilter(universalClient, logger)
+	evaluationBloomFilter := ioc.InitAdminEvaluationBloomFilter(universalClient, logger)
+	primaryStickyCache := ioc.InitPrimaryStickyCache(universalClient)
+	evaluationRepository := repository.NewEvaluationRepository(evaluationDAO, evaluationCache, recentEvaluationCache, evaluationDetailCache, evaluationCountCache, courseBloomFilter, evaluationBloomFilter, primaryStickyCache, logger)
+	courseCache := ioc.InitAdminCourseCache()
+	courseServiceClient := ioc.InitCourseClient(client, courseCache, logger)
+	courseMergeService := service.NewCourseMergeService(evaluationRepository, courseServiceClient, logger)
+	return courseMergeService
+}
+
+// InitEvaluationRepository 管理员命令用，不启动服务
+func InitEvaluationRepository() repository.EvaluationRepository {
+	logger := ioc.InitLogger()
+	universalClient := ioc.InitRedis()
+	limiter := ioc.InitLimiter(universalClient)
+	db := ioc.InitDB(logger, limiter)
+	dstDB := ioc.InitDstDB(logger, limiter)
+	shardDBs := ioc.InitShardDBs(logger, limiter)
+	client := ioc.InitEtcdClient()
+	generator := ioc.InitIdGenerator(client, logger)
+	evaluationDAO := ioc.InitEvaluationDAO(db, dstDB, shardDBs, generator, logger)
+	evaluationCache := ioc.InitAdminEvaluationCache(universalClient)
+	recentEvaluationCache := ioc.InitRecentEvaluationCache(universalClient)
+	evaluationDetailCache := ioc.InitAdminEvaluationDetailCache(universalClient)
+	evaluationCountCache := ioc.InitEvaluationCountCache(universalClient)
+	courseBloomFilter := ioc.InitAdminCourseBloomFilter(universalClient, logger)
+	evaluationBloomFilter := ioc.InitAdminEvaluationBloomFilter(universalClient, logger)
+	primaryStickyCache := ioc.InitPrimaryStickyCache(universalClient)
+	evaluationRepository := repository.NewEvaluationRepository(evaluationDAO, evaluationCache, recentEvaluationCache, evaluationDetailCache, evaluationCountCache, courseBloomFilter, evaluationBloomFilter, primaryStickyCache, logger)
+	return evaluationRepository
+}
+
+// wire.go:
+
+var thirdPartySet = wire.NewSet(ioc.InitRedis, wire.Bind(new(redis.Cmdable), new(redis.UniversalClient)), ioc.InitDB, ioc.InitDstDB, ioc.InitShardDBs, ioc.InitIdGenerator, ioc.InitLimiter, ioc.InitEtcdClient, ioc.InitLogger, ioc.InitCourseClient, ioc.InitCourseCache)
+
+var evaluationRepoSet = wire.NewSet(repository.NewEvaluationRepository, ioc.InitEvaluationCache, ioc.InitRecentEvaluationCache, ioc.InitEvaluationDetailCache, ioc.InitPrimaryStickyCache, ioc.InitEvaluationCountCache, ioc.InitCourseBloomFilter, ioc.InitEvaluationBloomFilter, ioc.InitEvaluationDAO)
+
+// adminThirdPartySet 管理员命令用，不起后台goroutine
+var adminThirdPartySet = wire.NewSet(ioc.InitRedis, wire.Bind(new(redis.Cmdable), new(redis.UniversalClient)), ioc.InitDB, ioc.InitDstDB, ioc.InitShardDBs, ioc.InitIdGenerator, ioc.InitLimiter, ioc.InitEtcdClient, ioc.InitLogger, ioc.InitCourseClient, ioc.InitAdminCourseCache)
+
+var adminEvaluationRepoSet = wire.NewSet(repository.NewEvaluationRepository, ioc.InitAdminEvaluationCache, ioc.InitRecentEvaluationCache, ioc.InitAdminEvaluationDetailCache, ioc.InitPrimaryStickyCache, ioc.InitEvaluationCountCache, ioc.InitAdminCourseBloomFilter, ioc.InitAdminEvaluationBloomFilter, ioc.InitEvaluationDAO)